@@ -0,0 +1,301 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/apix/response"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/domainx/dx"
+	"github.com/jom-io/gorig/utils/errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is the type of the values of a filterable field
+type Type string
+
+const (
+	String  Type = "string"
+	Int     Type = "int"
+	Float   Type = "float"
+	Bool    Type = "bool"
+	Time    Type = "time" // RFC3339, 2006-01-02 or unix seconds
+	Strings Type = "strings"
+	Ints    Type = "ints"
+)
+
+// Op is an operator of the query string, written as field[op]=value, field=value is eq
+type Op string
+
+const (
+	Eq     Op = "eq"
+	Ne     Op = "ne"
+	Gt     Op = "gt"
+	Gte    Op = "gte"
+	Lt     Op = "lt"
+	Lte    Op = "lte"
+	Like   Op = "like"
+	In     Op = "in"
+	NotIn  Op = "nin"
+	Has    Op = "has"
+	HasAny Op = "has_any"
+	HasAll Op = "has_all"
+)
+
+var matchTypes = map[Op]domainx.MatchType{
+	Eq: domainx.MEq, Ne: domainx.MNE, Gt: domainx.MGt, Gte: domainx.MGte, Lt: domainx.MLt, Lte: domainx.MLte,
+	Like: domainx.MLIKE, In: domainx.MIN, NotIn: domainx.MNOTIN,
+	Has: domainx.MHas, HasAny: domainx.MHasAny, HasAll: domainx.MHasAll,
+}
+
+// defaultOps are the operators allowed on a field of a type without Field.Ops
+var defaultOps = map[Type][]Op{
+	String:  {Eq, Ne, Like, In, NotIn},
+	Int:     {Eq, Ne, Gt, Gte, Lt, Lte, In, NotIn},
+	Float:   {Eq, Ne, Gt, Gte, Lt, Lte},
+	Bool:    {Eq, Ne},
+	Time:    {Eq, Gt, Gte, Lt, Lte},
+	Strings: {Has, HasAny, HasAll},
+	Ints:    {Has, HasAny, HasAll},
+}
+
+// reserved are the params read by other parsers, e.g. apix.GetPageReq
+var reserved = []string{"sort", "fields", "page", "size", "lastID", "cursor"}
+
+// Field is a field of the whitelist of an endpoint
+type Field struct {
+	Type     Type
+	Ops      []Op   // allowed operators, the defaults of Type if empty
+	Sortable bool   // allowed in sort
+	Column   string // name in the database if it differs from the param name
+}
+
+// Schema is the whitelist of an endpoint, params of other fields are rejected if they use an operator and ignored otherwise
+type Schema struct {
+	Fields map[string]Field
+	// Select lists the fields allowed in the fields param, the param is rejected if empty
+	Select []string
+	// MaxValues limits the values of a list operator, 100 if 0
+	MaxValues int
+}
+
+// Query is the parsed query string
+type Query struct {
+	Matches domainx.Matches
+	Sorts   domainx.Sorts
+	Fields  []string
+}
+
+// FieldError is the field-level message reported through response.ErrorParam
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Parse parses the query string of the request, e.g. ?status=1&age[gte]=18&tags[has_any]=a,b&sort=-created_at,name&fields=id,name.
+// An invalid param is reported through response.ErrorParam and returned as a verify error.
+func Parse(ctx *gin.Context, schema Schema) (*Query, *errors.Error) {
+	q, fErr := ParseValues(ctx.Request.URL.Query(), schema)
+	if fErr != nil {
+		response.ErrorParam(ctx, fErr)
+		return nil, errors.Verify(fmt.Sprintf("param: %s", fErr.Error()))
+	}
+	return q, nil
+}
+
+// ParseValues parses values against schema
+func ParseValues(values url.Values, schema Schema) (*Query, *FieldError) {
+	q := &Query{}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if slices.Contains(reserved, key) {
+			continue
+		}
+		name, op := key, Eq
+		if i := strings.IndexByte(key, '['); i > 0 && strings.HasSuffix(key, "]") {
+			name, op = key[:i], Op(key[i+1:len(key)-1])
+		}
+		field, ok := schema.Fields[name]
+		if !ok {
+			if name != key {
+				return nil, &FieldError{Field: name, Message: "is not filterable"}
+			}
+			continue
+		}
+		if err := q.addMatch(schema, name, field, op, values.Get(key)); err != nil {
+			return nil, err
+		}
+	}
+	if err := q.parseSort(schema, values.Get("sort")); err != nil {
+		return nil, err
+	}
+	if err := q.parseFields(schema, values.Get("fields")); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *Query) addMatch(schema Schema, name string, field Field, op Op, raw string) *FieldError {
+	ops := field.Ops
+	if len(ops) == 0 {
+		ops = defaultOps[field.Type]
+	}
+	if !slices.Contains(ops, op) {
+		return &FieldError{Field: name, Message: fmt.Sprintf("operator %s is not allowed", op)}
+	}
+	var value interface{}
+	var err error
+	switch op {
+	case In, NotIn, HasAny, HasAll:
+		parts := strings.Split(raw, ",")
+		limit := schema.MaxValues
+		if limit <= 0 {
+			limit = 100
+		}
+		if len(parts) > limit {
+			return &FieldError{Field: name, Message: fmt.Sprintf("at most %d values are allowed", limit)}
+		}
+		value, err = parseList(field.Type, parts)
+	default:
+		value, err = parseValue(field.Type, raw)
+	}
+	if err != nil {
+		return &FieldError{Field: name, Message: fmt.Sprintf("invalid %s value %q", field.Type, raw)}
+	}
+	column := name
+	if field.Column != "" {
+		column = field.Column
+	}
+	q.Matches.AddMatch(&domainx.Match{Field: column, Value: value, Type: matchTypes[op]})
+	return nil
+}
+
+// parseValue parses a single value, the element of an array type
+func parseValue(t Type, raw string) (interface{}, error) {
+	switch t {
+	case Int, Ints:
+		return strconv.ParseInt(raw, 10, 64)
+	case Float:
+		return strconv.ParseFloat(raw, 64)
+	case Bool:
+		return strconv.ParseBool(raw)
+	case Time:
+		if ts, err := time.Parse(time.RFC3339, raw); err == nil {
+			return ts, nil
+		}
+		if ts, err := time.ParseInLocation(time.DateOnly, raw, time.Local); err == nil {
+			return ts, nil
+		}
+		sec, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(sec, 0), nil
+	default:
+		return raw, nil
+	}
+}
+
+func parseList(t Type, parts []string) (interface{}, error) {
+	switch t {
+	case Int, Ints:
+		list := make([]int64, 0, len(parts))
+		for _, p := range parts {
+			v, err := strconv.ParseInt(p, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case String, Strings:
+		return parts, nil
+	default:
+		list := make([]interface{}, 0, len(parts))
+		for _, p := range parts {
+			v, err := parseValue(t, p)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+}
+
+// parseSort parses sort=-created_at,name, a leading - sorts descending
+func (q *Query) parseSort(schema Schema, raw string) *FieldError {
+	if raw == "" {
+		return nil
+	}
+	for _, part := range strings.Split(raw, ",") {
+		name, desc := strings.CutPrefix(part, "-")
+		field, ok := schema.Fields[name]
+		if !ok || !field.Sortable {
+			return &FieldError{Field: "sort", Message: fmt.Sprintf("%s is not sortable", name)}
+		}
+		if field.Column != "" {
+			name = field.Column
+		}
+		q.Sorts.AddSort(name, !desc)
+	}
+	return nil
+}
+
+func (q *Query) parseFields(schema Schema, raw string) *FieldError {
+	if raw == "" {
+		return nil
+	}
+	for _, name := range strings.Split(raw, ",") {
+		if !slices.Contains(schema.Select, name) {
+			return &FieldError{Field: "fields", Message: fmt.Sprintf("%s is not selectable", name)}
+		}
+		column := name
+		if field, ok := schema.Fields[name]; ok && field.Column != "" {
+			column = field.Column
+		}
+		q.Fields = append(q.Fields, column)
+	}
+	return nil
+}
+
+// Apply adds the matches, sorts and selected fields of q to the query d
+func Apply[T any](d dx.DQuery[T], q *Query) dx.DQuery[T] {
+	if q == nil {
+		return d
+	}
+	for i := range q.Matches {
+		m := q.Matches[i]
+		switch m.Type {
+		case domainx.MEq:
+			// through Eq, In... an encrypted field is matched by its blind index
+			d.Eq(m.Field, m.Value, true)
+		case domainx.MNE:
+			d.Ne(m.Field, m.Value, true)
+		case domainx.MIN:
+			d.In(m.Field, m.Value, true)
+		case domainx.MNOTIN:
+			d.NotIn(m.Field, m.Value, true)
+		default:
+			d.AddMatch(&m)
+		}
+	}
+	for _, s := range q.Sorts {
+		d.Sort(s.Field, s.Asc)
+	}
+	if len(q.Fields) > 0 {
+		d.Select(q.Fields...)
+	}
+	return d
+}
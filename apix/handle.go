@@ -7,6 +7,7 @@ import (
 	"github.com/jom-io/gorig/utils/errors"
 	"github.com/jom-io/gorig/utils/logger"
 	"github.com/jom-io/gorig/utils/notify/dingding"
+	"net/http"
 	"runtime/debug"
 )
 
@@ -34,13 +35,24 @@ func HandleError(ctx *gin.Context, code int, data *interface{}, error *errors.Er
 	}
 	if error.Type == errors.System {
 		logger.Warn(ctx, error.Error())
-		response.ErrorSystem(ctx, GetTraceID(ctx), GetTraceID(ctx))
+		if error.IsTimeout() {
+			response.ErrorTimeout(ctx, http.StatusText(http.StatusGatewayTimeout)+" "+GetTraceID(ctx), GetTraceID(ctx))
+		} else {
+			response.ErrorSystem(ctx, GetTraceID(ctx), GetTraceID(ctx))
+		}
 		log := fmt.Sprintf("TraceID: %s, \nError: %v, \nRequest: %v,  \nStack: %s", GetTraceID(ctx), error, ctx.Request, string(debug.Stack()))
 		go dingding.ErrNotifyDefault(log)
 	}
 	if error.Type == errors.Application {
 		logger.Error(ctx, error.Error())
-		response.Fail(ctx, code, error.Message, data)
+		switch {
+		case error.IsNotFound():
+			response.ErrorNotFound(ctx, error.Message, data)
+		case error.IsConflict(), error.IsDuplicateKey():
+			response.ErrorConflict(ctx, error.Message, data)
+		default:
+			response.Fail(ctx, code, error.Message, data)
+		}
 	}
 }
 
@@ -11,22 +11,28 @@ type Page struct {
 	Page   int64 `json:"page" form:"page"`
 	Size   int64 `json:"size" form:"size"`
 	LastID int64 `json:"lastID" form:"lastID"`
+	// Cursor is the opaque keyset cursor returned as nextCursor/prevCursor, it takes precedence over Page and LastID
+	Cursor string `json:"cursor" form:"cursor"`
 }
 
 type PageResp struct {
-	Page   int64  `json:"page"`
-	Size   int64  `json:"size"`
-	Total  *Total `json:"total"`
-	LastID int64  `json:"lastID"`
-	Result any    `json:"result"`
+	Page       int64  `json:"page"`
+	Size       int64  `json:"size"`
+	Total      *Total `json:"total"`
+	LastID     int64  `json:"lastID"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	PrevCursor string `json:"prevCursor,omitempty"`
+	Result     any    `json:"result"`
 }
 
 type PageRespT[T any] struct {
-	Page   int64  `json:"page"`
-	Size   int64  `json:"size"`
-	Total  *Total `json:"total"`
-	LastID int64  `json:"lastID"`
-	Result *[]T   `json:"result"`
+	Page       int64  `json:"page"`
+	Size       int64  `json:"size"`
+	Total      *Total `json:"total"`
+	LastID     int64  `json:"lastID"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	PrevCursor string `json:"prevCursor,omitempty"`
+	Result     *[]T   `json:"result"`
 }
 
 type Total int64
@@ -109,6 +115,16 @@ func (r *PageRespT[T]) Build(page *Page, total *Total, LastID int64, result *[]T
 	r.Result = result
 }
 
+func (r *PageResp) SetCursor(next, prev string) {
+	r.NextCursor = next
+	r.PrevCursor = prev
+}
+
+func (r *PageRespT[T]) SetCursor(next, prev string) {
+	r.NextCursor = next
+	r.PrevCursor = prev
+}
+
 func (r *PageResp) BuildS(page *Page, LastID int64, result any) {
 	r.Page = page.Page
 	r.Size = page.Size
@@ -134,11 +150,13 @@ func Covert[T any](r *PageResp) *PageRespT[T] {
 			logger.Error(nil, "CovertT", zap.Any("err", e))
 		} else {
 			return &PageRespT[T]{
-				Page:   r.Page,
-				Size:   r.Size,
-				Total:  r.Total,
-				LastID: r.LastID,
-				Result: result,
+				Page:       r.Page,
+				Size:       r.Size,
+				Total:      r.Total,
+				LastID:     r.LastID,
+				NextCursor: r.NextCursor,
+				PrevCursor: r.PrevCursor,
+				Result:     result,
 			}
 		}
 	}
@@ -155,6 +173,8 @@ func (t *PageRespT[T]) ParsePageResp(r *PageResp, result *[]T) *PageRespT[T] {
 	t.Size = r.Size
 	t.Total = r.Total
 	t.LastID = r.LastID
+	t.NextCursor = r.NextCursor
+	t.PrevCursor = r.PrevCursor
 	t.Result = result
 	return t
 }
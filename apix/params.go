@@ -502,6 +502,11 @@ func GetPageReq(ctx *gin.Context) (pageReq *load.Page, err *errors.Error) {
 	if err != nil {
 		return
 	}
+	cursor, err := GetParamStr(ctx, "cursor")
+	if err != nil {
+		return
+	}
 	pageReq = load.BuildPage(ctx, page, size, lastID)
+	pageReq.Cursor = cursor
 	return
 }
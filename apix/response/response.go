@@ -147,6 +147,24 @@ func ErrorSystem(c *gin.Context, msg string, data interface{}) {
 	c.Abort()
 }
 
+// ErrorNotFound 404
+func ErrorNotFound(c *gin.Context, msg string, data interface{}) {
+	ReturnJson(c, http.StatusNotFound, http.StatusNotFound, msg, data)
+	c.Abort()
+}
+
+// ErrorConflict 409
+func ErrorConflict(c *gin.Context, msg string, data interface{}) {
+	ReturnJson(c, http.StatusConflict, http.StatusConflict, msg, data)
+	c.Abort()
+}
+
+// ErrorTimeout 504
+func ErrorTimeout(c *gin.Context, msg string, data interface{}) {
+	ReturnJson(c, http.StatusGatewayTimeout, http.StatusGatewayTimeout, msg, data)
+	c.Abort()
+}
+
 // StatusTooManyRequests 429
 func ErrorTooManyRequests(c *gin.Context) {
 	ReturnJson(c, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests), nil)
@@ -58,6 +58,12 @@ func SetUserID(ctx *gin.Context, userID string) {
 	ctx.Request = ctx.Request.WithContext(newCtx)
 }
 
+func SetTenantID(ctx *gin.Context, tenantID string) {
+	ctx.Set(consts.TenantIDKey, tenantID)
+	newCtx := context.WithValue(ctx.Request.Context(), consts.TenantIDKey, tenantID)
+	ctx.Request = ctx.Request.WithContext(newCtx)
+}
+
 func GetUserInfo(ctx *gin.Context) map[string]interface{} {
 	value, exists := ctx.Get(consts.UserInfo)
 	if !exists {
@@ -1,9 +1,11 @@
 package bootstrap
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	_ "github.com/jom-io/gorig/cache"
-	_ "github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/domainx/migrate"
 	_ "github.com/jom-io/gorig/global/variable"
 	"github.com/jom-io/gorig/httpx"
 	"github.com/jom-io/gorig/serv"
@@ -25,7 +27,40 @@ func regWebService() {
 	}
 }
 
-func StartUp() {
+type Option func(o *options)
+
+type options struct {
+	migrate bool
+}
+
+// WithMigrate applies the pending domainx/migrate migrations before the services start,
+// also enabled by ${ domainx.migrate.bootstrap }
+func WithMigrate() Option {
+	return func(o *options) {
+		o.migrate = true
+	}
+}
+
+func runMigrations() {
+	sys.Warn("# Run migrations ...... #")
+	if err := serv.StartCode(domainx.ServiceCode); err != nil {
+		sys.Exit(err)
+	}
+	applied, err := migrate.Up(context.Background())
+	if err != nil {
+		sys.Exit(err)
+	}
+	sys.Success("# Run migrations [OK] applied: ", len(applied), " #")
+}
+
+func StartUp(opts ...Option) {
+	o := &options{migrate: configure.GetBool("domainx.migrate.bootstrap", false)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.migrate {
+		runMigrations()
+	}
 	regWebService()
 	sys.Warn("# All registered API information ...... #")
 	httpx.DumpRouters(func(info gin.RouteInfo) {
@@ -1,19 +1,19 @@
 package domainx
 
 import (
-	"math/rand"
-	"time"
+	"github.com/jom-io/gorig/utils/snowflake"
 )
 
 type ID int64
 
+// GenerateID returns a snowflake id of the default node, see snowflake.Default
 func (i ID) GenerateID() int64 {
-	randSource := rand.NewSource(time.Now().UnixNano())
-	rnd := rand.New(randSource)
-	currentTimestampMillis := time.Now().UnixNano() / 1_000_000
-	randomNumber := rnd.Intn(900) + 100
-	result := currentTimestampMillis*1000 + int64(randomNumber)
-	return result
+	return snowflake.NextID()
+}
+
+// Info decodes the generation time, worker id and sequence of the id
+func (i ID) Info() snowflake.Info {
+	return snowflake.Parse(i.Int64())
 }
 
 func (i ID) New() *ID {
@@ -0,0 +1,196 @@
+package domainx
+
+import (
+	"fmt"
+	"github.com/jom-io/gorig/apix/load"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/spf13/cast"
+)
+
+type AggType string
+
+const (
+	AggCount         AggType = "count"
+	AggSum           AggType = "sum"
+	AggAvg           AggType = "avg"
+	AggMin           AggType = "min"
+	AggMax           AggType = "max"
+	AggCountDistinct AggType = "count_distinct"
+)
+
+type AggField struct {
+	Field string
+	Agg   AggType
+	Alias string
+}
+
+// AggItem is one group row of an aggregation, shaped like cache.PageGroupItem
+type AggItem struct {
+	Group map[string]string  `json:"group"`
+	Value map[string]float64 `json:"value"`
+}
+
+// Aggregation describes a group-by query: group fields, aggregate fields,
+// HAVING conditions on aggregate aliases, sorting by alias or group field and paging of the groups
+type Aggregation struct {
+	GroupFields []string
+	AggFields   []AggField
+	Having      Matches
+	Sort        Sorts
+	Page        *load.Page
+}
+
+func NewAggregation(groupFields ...string) *Aggregation {
+	return &Aggregation{GroupFields: sanitizeFields(groupFields...)}
+}
+
+func (a *Aggregation) GroupBy(fields ...string) *Aggregation {
+	a.GroupFields = sanitizeFields(append(a.GroupFields, fields...)...)
+	return a
+}
+
+func (a *Aggregation) add(agg AggType, field, alias string) *Aggregation {
+	if alias == "" {
+		alias = string(agg)
+		if field != "" {
+			alias += "_" + field
+		}
+	}
+	a.AggFields = append(a.AggFields, AggField{Field: field, Agg: agg, Alias: alias})
+	return a
+}
+
+func (a *Aggregation) Count(alias string) *Aggregation {
+	return a.add(AggCount, "", alias)
+}
+
+func (a *Aggregation) Sum(field, alias string) *Aggregation {
+	return a.add(AggSum, field, alias)
+}
+
+func (a *Aggregation) Avg(field, alias string) *Aggregation {
+	return a.add(AggAvg, field, alias)
+}
+
+func (a *Aggregation) Min(field, alias string) *Aggregation {
+	return a.add(AggMin, field, alias)
+}
+
+func (a *Aggregation) Max(field, alias string) *Aggregation {
+	return a.add(AggMax, field, alias)
+}
+
+func (a *Aggregation) CountDistinct(field, alias string) *Aggregation {
+	return a.add(AggCountDistinct, field, alias)
+}
+
+// HavingBy filters groups by an aggregate alias, e.g. HavingBy("cnt", MGt, 10)
+func (a *Aggregation) HavingBy(alias string, t MatchType, value interface{}) *Aggregation {
+	a.Having.AddMatch(&Match{Field: alias, Value: value, Type: t})
+	return a
+}
+
+// OrderBy sorts groups by an aggregate alias or a group field
+func (a *Aggregation) OrderBy(field string, asc bool) *Aggregation {
+	a.Sort.AddSort(field, asc)
+	return a
+}
+
+func (a *Aggregation) Paging(page, size int64) *Aggregation {
+	a.Page = &load.Page{Page: page, Size: size}
+	return a
+}
+
+func (a *Aggregation) isAlias(field string) bool {
+	for _, f := range a.AggFields {
+		if f.Alias == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Aggregation) isGroup(field string) bool {
+	for _, f := range a.GroupFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that all identifiers are safe to be used in a query
+func (a *Aggregation) Validate() *errors.Error {
+	if a == nil {
+		return errors.Sys("aggregation is nil")
+	}
+	if len(a.GroupFields) == 0 && len(a.AggFields) == 0 {
+		return errors.Sys("aggregation needs group fields or aggregate fields")
+	}
+	for _, g := range a.GroupFields {
+		if !ValueField(g).Check() {
+			return errors.Sys(fmt.Sprintf("group field is not valid: %s", g))
+		}
+	}
+	for _, f := range a.AggFields {
+		if !ValueField(f.Alias).Check() {
+			return errors.Sys(fmt.Sprintf("aggregate alias is not valid: %s", f.Alias))
+		}
+		if a.isGroup(f.Alias) {
+			return errors.Sys(fmt.Sprintf("aggregate alias conflicts with group field: %s", f.Alias))
+		}
+		switch f.Agg {
+		case AggCount:
+			continue
+		case AggSum, AggAvg, AggMin, AggMax, AggCountDistinct:
+			if !ValueField(f.Field).Check() {
+				return errors.Sys(fmt.Sprintf("aggregate field is not valid: %s", f.Field))
+			}
+		default:
+			return errors.Sys(fmt.Sprintf("aggregate type is not supported: %s", f.Agg))
+		}
+	}
+	for _, h := range a.Having {
+		if !a.isAlias(h.Field) {
+			return errors.Sys(fmt.Sprintf("having field must be an aggregate alias: %s", h.Field))
+		}
+	}
+	for _, s := range a.Sort {
+		if !a.isAlias(s.Field) && !a.isGroup(s.Field) {
+			return errors.Sys(fmt.Sprintf("sort field must be an aggregate alias or group field: %s", s.Field))
+		}
+	}
+	if a.Page != nil && (a.Page.Page <= 0 || a.Page.Size <= 0) {
+		return errors.Sys("aggregation page and size must be greater than 0")
+	}
+	return nil
+}
+
+// toAggItem converts a driver row into an AggItem
+func (a *Aggregation) toAggItem(row map[string]interface{}) *AggItem {
+	item := &AggItem{
+		Group: make(map[string]string, len(a.GroupFields)),
+		Value: make(map[string]float64, len(a.AggFields)),
+	}
+	for _, g := range a.GroupFields {
+		item.Group[g] = aggString(row[g])
+	}
+	for _, f := range a.AggFields {
+		item.Value[f.Alias] = aggFloat(row[f.Alias])
+	}
+	return item
+}
+
+func aggString(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return cast.ToString(v)
+}
+
+func aggFloat(v interface{}) float64 {
+	if b, ok := v.([]byte); ok {
+		return cast.ToFloat64(string(b))
+	}
+	return cast.ToFloat64(v)
+}
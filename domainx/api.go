@@ -2,10 +2,13 @@ package domainx
 
 import (
 	"context"
+	checkErr "errors"
 	"fmt"
 	"github.com/jom-io/gorig/apix/load"
 	"github.com/jom-io/gorig/utils/errors"
 	"github.com/jom-io/gorig/utils/logger"
+	"go.uber.org/zap"
+	"slices"
 	"strings"
 )
 
@@ -34,27 +37,38 @@ func UseCon(ctx context.Context, conType ConType, dbName string, table string) *
 }
 
 func CtIdx(idxType IdxType, fileds ...string) Index {
-	// Validate that there are no empty strings, duplicate fields, or hyphens
+	// Validate that there are no empty strings, duplicate fields, or hyphens other than the Desc prefix
+	names := make([]string, 0, len(fileds))
 	for _, v := range fileds {
-		if v == "" {
+		name, desc := indexField(v)
+		if name == "" {
 			logger.Logger.Fatal("CreateIdx field is nil")
 		}
-		if strings.Contains(v, "-") {
+		if strings.Contains(name, "-") {
 			logger.Logger.Fatal("CreateIdx field can not contain -")
 		}
+		if desc {
+			name += "_desc"
+		}
+		names = append(names, name)
 	}
 	// Validate duplicate fields
 	for i := 0; i < len(fileds); i++ {
 		for j := i + 1; j < len(fileds); j++ {
-			if fileds[i] == fileds[j] {
+			a, _ := indexField(fileds[i])
+			b, _ := indexField(fileds[j])
+			if a == b {
 				logger.Logger.Fatal("CreateIdx field can not repeat")
 			}
 		}
 	}
+	if idxType == TTL && len(fileds) != 1 {
+		logger.Logger.Fatal("CreateIdx ttl index must have one field")
+	}
 	var g Index
 	g.IdxType = idxType
 	g.Fields = fileds
-	g.IdxName = string(idxType) + "-" + strings.Join(fileds, "-")
+	g.IdxName = string(idxType) + "-" + strings.Join(names, "-")
 	return g
 }
 
@@ -68,8 +82,12 @@ func AutoMigrate(getDB func() (value ConTable), index ...Index) {
 
 func (c *Con) HandleWithErr(err error) (error *errors.Error) {
 	if err != nil {
-		if err.Error() == "mongo: no documents in result" {
-			return nil
+		var appErr *errors.Error
+		if checkErr.As(err, &appErr) && (appErr.IsApplication() || appErr.IsTimeout()) {
+			return appErr
+		}
+		if typed := c.typedErr(err); typed != nil {
+			return typed
 		}
 		error = errors.Sys(fmt.Sprintf("%s database operation failed: %s", c.TableName(), err.Error()))
 		return error
@@ -88,10 +106,20 @@ func GetByID[T any](c *Con, id int64, result *T) (err *errors.Error) {
 	if c == nil {
 		return errors.Sys("con not init")
 	}
+	if c.Partition != nil {
+		return getPartitionByID(c, id, result)
+	}
+	if c.tenantScoped() {
+		return GetByMatch(c, []Match{c.idMatch(id)}, result)
+	}
 
 	dbService := GetDBService(c.GetConType())
 
 	gErr := dbService.GetByID(c, id, result)
+	if noRecord(gErr) {
+		// a missing record is an empty result, see MustGetByID
+		return nil
+	}
 	if gErr != nil {
 		return c.HandleWithErr(gErr)
 	}
@@ -104,7 +132,7 @@ func MustGetByID[T Identifiable](c *Con, id int64, result T) *errors.Error {
 		return err
 	}
 	if &result == nil || result.GetID().IsNil() {
-		return errors.Verify(fmt.Sprintf("%s record id %d not found", c.TableName(), id))
+		return errors.NotFound(fmt.Sprintf("%s record id %d not found", c.TableName(), id))
 	}
 	return nil
 }
@@ -122,12 +150,18 @@ func save(c *Con, data Identifiable, version int, newIDs ...int64) (int64, *erro
 	if c == nil {
 		return 0, errors.Sys("con not init")
 	}
+	if c.Partition != nil {
+		return savePartition(c, data, version, newIDs...)
+	}
 
 	dbService := GetDBService(c.GetConType())
 
 	if !data.GetID().IsNil() {
 		c.ID = data.GetID().Int64()
 	}
+	if err := c.scopeSave(data); err != nil {
+		return 0, err
+	}
 
 	newID := int64(0)
 	if len(newIDs) > 0 {
@@ -141,6 +175,7 @@ func save(c *Con, data Identifiable, version int, newIDs ...int64) (int64, *erro
 	if gErr != nil {
 		return 0, c.HandleWithErr(gErr)
 	}
+	c.written()
 	data.SetID(id)
 	return id, nil
 }
@@ -158,13 +193,21 @@ func Delete(c *Con, data Identifiable) *errors.Error {
 	if c == nil {
 		return errors.Sys("con not init")
 	}
+	if c.Partition != nil {
+		return deletePartition(c, data)
+	}
+
+	if err := c.mustOwn(data.GetID().Int64()); err != nil {
+		return err
+	}
 
 	dbService := GetDBService(c.GetConType())
 
-	gErr := dbService.Delete(c, data)
+	_, gErr := dbService.Delete(c, data)
 	if gErr != nil {
 		return c.HandleWithErr(gErr)
 	}
+	c.written()
 	return nil
 }
 
@@ -172,13 +215,24 @@ func DeleteByMatch(c *Con, matchList []Match) *errors.Error {
 	if c == nil {
 		return errors.Sys("con not init")
 	}
+	if c.Partition != nil {
+		return writePartitions(c, matchList, func() *errors.Error {
+			return DeleteByMatch(c, matchList)
+		})
+	}
+
+	matchList, sErr := c.scopeMatches(matchList)
+	if sErr != nil {
+		return sErr
+	}
 
 	dbService := GetDBService(c.GetConType())
 
-	gErr := dbService.DeleteByMatch(c, matchList)
+	_, gErr := dbService.DeleteByMatch(c, matchList)
 	if gErr != nil {
 		return c.HandleWithErr(gErr)
 	}
+	c.written()
 	return nil
 }
 
@@ -239,6 +293,14 @@ func FindByMatch[T any](c *Con, matchList []Match, result *[]T, prefixes ...stri
 	if result == nil {
 		return errors.Sys("result is nil")
 	}
+	if c.Partition != nil {
+		return findPartitions(c, matchList, result, prefixes...)
+	}
+
+	matchList, sErr := c.scopeMatches(matchList)
+	if sErr != nil {
+		return sErr
+	}
 
 	dbService := GetDBService(c.GetConType())
 
@@ -254,10 +316,21 @@ func GetByMatch[T any](c *Con, matchList []Match, result *T) (err *errors.Error)
 	if c == nil {
 		return errors.Sys("con not init")
 	}
+	if c.Partition != nil {
+		return getPartitionByMatch(c, matchList, result)
+	}
+
+	matchList, sErr := c.scopeMatches(matchList)
+	if sErr != nil {
+		return sErr
+	}
 
 	dbService := GetDBService(c.GetConType())
 
 	gErr := dbService.GetByMatch(c, matchList, result)
+	if noRecord(gErr) {
+		return nil
+	}
 	if gErr != nil {
 		return c.HandleWithErr(gErr)
 	}
@@ -284,6 +357,14 @@ func CountByMatch(c *Con, matchList []Match) (int64, *errors.Error) {
 	if c == nil {
 		return 0, errors.Sys("con not init")
 	}
+	if c.Partition != nil {
+		return countPartitions(c, matchList)
+	}
+
+	matchList, sErr := c.scopeMatches(matchList)
+	if sErr != nil {
+		return 0, sErr
+	}
 
 	dbService := GetDBService(c.GetConType())
 
@@ -298,6 +379,15 @@ func ExistsByMatch(c *Con, matchList []Match) (bool, *errors.Error) {
 	if c == nil {
 		return false, errors.Sys("con not init")
 	}
+	if c.Partition != nil {
+		tables, err := c.matchedPartitions(matchList)
+		return len(tables) > 0, err
+	}
+
+	matchList, sErr := c.scopeMatches(matchList)
+	if sErr != nil {
+		return false, sErr
+	}
 
 	dbService := GetDBService(c.GetConType())
 
@@ -313,6 +403,14 @@ func SumByMatch(c *Con, matchList []Match, field string) (float64, *errors.Error
 	if c == nil {
 		return 0, errors.Sys("con not init")
 	}
+	if c.Partition != nil {
+		return sumPartitions(c, matchList, field)
+	}
+
+	matchList, sErr := c.scopeMatches(matchList)
+	if sErr != nil {
+		return 0, sErr
+	}
 
 	dbService := GetDBService(c.GetConType())
 
@@ -328,13 +426,24 @@ func UpdatePart(c *Con, id int64, data map[string]interface{}) *errors.Error {
 	if c == nil {
 		return errors.Sys("con not init")
 	}
+	if err := c.scopeUpdate(data); err != nil {
+		return err
+	}
+	if c.Partition != nil {
+		return updatePartition(c, id, data)
+	}
+
+	if err := c.mustOwn(id); err != nil {
+		return err
+	}
 
 	dbService := GetDBService(c.GetConType())
 
-	gErr := dbService.UpdatePart(c, id, data)
+	_, gErr := dbService.UpdatePart(c, id, data)
 	if gErr != nil {
 		return c.HandleWithErr(gErr)
 	}
+	c.written()
 	return nil
 }
 
@@ -343,13 +452,27 @@ func UpdateByMatch(c *Con, matchList []Match, data map[string]interface{}) *erro
 	if c == nil {
 		return errors.Sys("con not init")
 	}
+	if err := c.scopeUpdate(data); err != nil {
+		return err
+	}
+	if c.Partition != nil {
+		return writePartitions(c, matchList, func() *errors.Error {
+			return UpdateByMatch(c, matchList, data)
+		})
+	}
+
+	matchList, sErr := c.scopeMatches(matchList)
+	if sErr != nil {
+		return sErr
+	}
 
 	dbService := GetDBService(c.GetConType())
 
-	gErr := dbService.UpdateByMatch(c, matchList, data)
+	_, gErr := dbService.UpdateByMatch(c, matchList, data)
 	if gErr != nil {
 		return c.HandleWithErr(gErr)
 	}
+	c.written()
 	return nil
 }
 
@@ -378,6 +501,19 @@ func FindByPageMatch[T Identifiable](c *Con, matchList []Match, page *load.Page,
 	if pageResp == nil {
 		return errors.Sys("pageResp is nil")
 	}
+	if c.Partition != nil {
+		total, err := findPagePartitions(c, matchList, page, result, prefixes...)
+		if err != nil {
+			return err
+		}
+		pageResp.Build(page, total, GetLastID(*result), result)
+		return nil
+	}
+
+	matchList, sErr := c.scopeMatches(matchList)
+	if sErr != nil {
+		return sErr
+	}
 
 	dbService := GetDBService(c.GetConType())
 
@@ -387,6 +523,7 @@ func FindByPageMatch[T Identifiable](c *Con, matchList []Match, page *load.Page,
 		return c.HandleWithErr(gErr)
 	}
 	pageResp.Build(page, total, GetLastID(*result), result)
+	pageResp.SetCursor(pageCursors(c, dbService, page, *result))
 	return nil
 }
 
@@ -400,6 +537,19 @@ func FindByPageMatchT[T Identifiable](c *Con, matchList []Match, page *load.Page
 	if pageResp == nil {
 		return errors.Sys("pageResp is nil")
 	}
+	if c.Partition != nil {
+		total, err := findPagePartitions(c, matchList, page, result, prefixes...)
+		if err != nil {
+			return err
+		}
+		pageResp.Build(page, total, GetLastID(*result), result)
+		return nil
+	}
+
+	matchList, sErr := c.scopeMatches(matchList)
+	if sErr != nil {
+		return sErr
+	}
 
 	dbService := GetDBService(c.GetConType())
 
@@ -409,5 +559,214 @@ func FindByPageMatchT[T Identifiable](c *Con, matchList []Match, page *load.Page
 		return c.HandleWithErr(gErr)
 	}
 	pageResp.Build(page, total, GetLastID(*result), result)
+	pageResp.SetCursor(pageCursors(c, dbService, page, *result))
 	return nil
 }
+
+// pageCursors builds the cursors of the rows around a page, nextCursor is only set when the page is full
+func pageCursors[T Identifiable](c *Con, dbService DBService, page *load.Page, result []T) (next, prev string) {
+	if len(result) == 0 || (page.LastID > 0 && page.Cursor == "") {
+		return "", ""
+	}
+	cursorOf := func(row T, prev bool) string {
+		values, err := dbService.CursorValues(c, row)
+		if err != nil {
+			logger.Warn(c.Ctx, "page cursor skipped", zap.String("table", c.TableName()), zap.Error(err))
+			return ""
+		}
+		return NewPageCursor(c.Sort, values, row.GetID().Int64(), prev)
+	}
+	first, last := result[0], result[len(result)-1]
+	full := int64(len(result)) >= page.Size
+	backward := false
+	if page.Cursor != "" {
+		if cur, err := DecodePageCursor(page.Cursor, c.Sort); err == nil {
+			backward = cur.Prev
+		}
+	}
+	if backward {
+		if full {
+			prev = cursorOf(first, true)
+		}
+		return cursorOf(last, false), prev
+	}
+	if full {
+		next = cursorOf(last, false)
+	}
+	if page.Cursor != "" || page.Page > 1 {
+		prev = cursorOf(first, true)
+	}
+	return next, prev
+}
+
+// AggregateByMatch groups the matched records and computes the aggregate fields of agg, returns the groups and the total number of groups
+func AggregateByMatch(c *Con, matchList []Match, agg *Aggregation) ([]*AggItem, int64, *errors.Error) {
+	if c == nil {
+		return nil, 0, errors.Sys("con not init")
+	}
+	if c.Partition != nil {
+		return nil, 0, c.partitionUnsupported("aggregation")
+	}
+
+	matchList, sErr := c.scopeMatches(matchList)
+	if sErr != nil {
+		return nil, 0, sErr
+	}
+
+	dbService := GetDBService(c.GetConType())
+
+	result := make([]*AggItem, 0)
+	total := new(load.Total)
+	gErr := dbService.AggregateByMatch(c, matchList, agg, total, &result)
+	if gErr != nil {
+		return nil, 0, c.HandleWithErr(gErr)
+	}
+	return result, total.Get(), nil
+}
+
+func bulkIDs(dataList []Identifiable, result *BulkResult) {
+	result.IDs = make([]int64, len(dataList))
+	for i, data := range dataList {
+		if !result.Failed(i) {
+			result.IDs[i] = data.GetID().Int64()
+		}
+	}
+}
+
+// SaveMany inserts the records in batches, failed rows are reported in BulkResult.Failures
+func SaveMany(c *Con, dataList []Identifiable, batchSize ...int) (*BulkResult, *errors.Error) {
+	if c == nil {
+		return nil, errors.Sys("con not init")
+	}
+	if c.Partition != nil {
+		return nil, c.partitionUnsupported("SaveMany")
+	}
+	result := &BulkResult{}
+	if len(dataList) == 0 {
+		return result, nil
+	}
+
+	dbService := GetDBService(c.GetConType())
+
+	for _, data := range dataList {
+		if err := c.scopeData(data); err != nil {
+			return nil, err
+		}
+		prepareInsert(c, data)
+	}
+	size := 0
+	if len(batchSize) > 0 {
+		size = batchSize[0]
+	}
+	if gErr := dbService.SaveMany(c, dataList, size, result); gErr != nil {
+		return nil, c.HandleWithErr(gErr)
+	}
+	c.written()
+	bulkIDs(dataList, result)
+	return result, nil
+}
+
+// UpsertBy inserts the records or updates the existing ones matched by fields, which must be covered by a unique index
+func UpsertBy(c *Con, dataList []Identifiable, fields []string, batchSize ...int) (*BulkResult, *errors.Error) {
+	if c == nil {
+		return nil, errors.Sys("con not init")
+	}
+	if c.Partition != nil {
+		return nil, c.partitionUnsupported("UpsertBy")
+	}
+	if err := checkUpsertFields(fields); err != nil {
+		return nil, err
+	}
+	if c.tenantScoped() && !slices.Contains(fields, c.TenantField) {
+		fields = append(slices.Clone(fields), c.TenantField)
+	}
+	result := &BulkResult{}
+	if len(dataList) == 0 {
+		return result, nil
+	}
+
+	dbService := GetDBService(c.GetConType())
+
+	for _, data := range dataList {
+		if err := c.scopeData(data); err != nil {
+			return nil, err
+		}
+		prepareInsert(c, data)
+	}
+	size := 0
+	if len(batchSize) > 0 {
+		size = batchSize[0]
+	}
+	if gErr := dbService.UpsertBy(c, dataList, fields, size, result); gErr != nil {
+		return nil, c.HandleWithErr(gErr)
+	}
+	c.written()
+	bulkIDs(dataList, result)
+	return result, nil
+}
+
+// BulkWrite executes mixed insert, update and delete operations, failed operations are reported in BulkResult.Failures
+func BulkWrite(c *Con, ops []BulkOp) (*BulkResult, *errors.Error) {
+	if c == nil {
+		return nil, errors.Sys("con not init")
+	}
+	if c.Partition != nil {
+		return nil, c.partitionUnsupported("BulkWrite")
+	}
+	result := &BulkResult{}
+	if len(ops) == 0 {
+		return result, nil
+	}
+
+	dbService := GetDBService(c.GetConType())
+
+	for i := range ops {
+		op := &ops[i]
+		switch op.Type {
+		case BulkInsert:
+			if op.Data == nil {
+				result.addFailure(i, 0, errors.Sys("insert data cannot be nil"))
+				continue
+			}
+			if err := c.scopeData(op.Data); err != nil {
+				result.addFailure(i, 0, err)
+				continue
+			}
+			prepareInsert(c, op.Data)
+		case BulkUpdate:
+			if len(op.Update) == 0 {
+				result.addFailure(i, op.ID, errors.Sys("update data cannot be empty"))
+				continue
+			}
+			if err := c.scopeUpdate(op.Update); err != nil {
+				result.addFailure(i, op.ID, err)
+				continue
+			}
+			fallthrough
+		case BulkDelete:
+			if op.ID <= 0 && len(op.Matches) == 0 {
+				result.addFailure(i, op.ID, errors.Sys("id is zero or matches not set"))
+				continue
+			}
+			matches, err := c.scopeMatches(op.Matches)
+			if err != nil {
+				result.addFailure(i, op.ID, err)
+				continue
+			}
+			op.Matches = matches
+		default:
+			result.addFailure(i, op.ID, errors.Sys(fmt.Sprintf("bulk operation is not supported: %s", op.Type)))
+		}
+	}
+	if gErr := dbService.BulkWrite(c, ops, result); gErr != nil {
+		return nil, c.HandleWithErr(gErr)
+	}
+	c.written()
+	result.IDs = make([]int64, len(ops))
+	for i := range ops {
+		if !result.Failed(i) {
+			result.IDs[i] = ops[i].id()
+		}
+	}
+	return result, nil
+}
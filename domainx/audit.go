@@ -0,0 +1,207 @@
+package domainx
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jom-io/gorig/apix/load"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type AuditOp string
+
+const (
+	AuditCreate AuditOp = "create"
+	AuditUpdate AuditOp = "update"
+	AuditDelete AuditOp = "delete"
+)
+
+type AuditChange struct {
+	Before interface{} `bson:"before" json:"before"`
+	After  interface{} `bson:"after" json:"after"`
+}
+
+// AuditRecord is one write of an entity, Diff holds the changed fields by column (mysql) or bson (mongo) name
+type AuditRecord struct {
+	Entity   string                 `gorm:"column:entity;type:varchar(128)" bson:"entity" json:"entity"`
+	EntityID int64                  `gorm:"column:entity_id" bson:"entity_id" json:"entityID"`
+	Op       AuditOp                `gorm:"column:op;type:varchar(16)" bson:"op" json:"op"`
+	Diff     map[string]AuditChange `gorm:"column:diff;type:json;serializer:json" bson:"diff" json:"diff"`
+	UserID   string                 `gorm:"column:user_id;type:varchar(64)" bson:"user_id" json:"userID"`
+	TraceID  string                 `gorm:"column:trace_id;type:varchar(64)" bson:"trace_id" json:"traceID"`
+	At       time.Time              `gorm:"column:at" bson:"at" json:"at"`
+}
+
+// AuditTable is the table or collection of the audit records, ${ domainx.audit.table }
+func AuditTable() string {
+	return configure.GetString("domainx.audit.table", "audit_log")
+}
+
+func auditComplex(ctx context.Context, conType ConType, dbName string) *Complex[AuditRecord] {
+	return CreateComplex[AuditRecord](ctx, conType, dbName, AuditTable(), nil)
+}
+
+// AutoMigrateAudit registers the migration of the audit table in the database of audited tables
+func AutoMigrateAudit(conType ConType, dbName string) {
+	AutoMigrate(func() ConTable {
+		return auditComplex(context.Background(), conType, dbName)
+	}, CtIdx(Idx, "entity", "entity_id"))
+}
+
+// NewAuditRecord builds a record with the user ID and trace ID of ctx
+func NewAuditRecord(ctx context.Context, entity string, id int64, op AuditOp, diff map[string]AuditChange) *AuditRecord {
+	return &AuditRecord{
+		Entity:   entity,
+		EntityID: id,
+		Op:       op,
+		Diff:     diff,
+		UserID:   logger.GetUserID(ctx),
+		TraceID:  logger.GetTraceID(ctx),
+		At:       time.Now(),
+	}
+}
+
+// SaveAudit writes the records into the audit table of the database of c
+func SaveAudit(c *Con, records []*AuditRecord) *errors.Error {
+	if c == nil {
+		return errors.Sys("con not init")
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	audit := auditComplex(c.Ctx, c.GetConType(), c.DBName)
+	if audit.Con == nil {
+		return errors.Sys("audit con not init")
+	}
+	dataList := make([]Identifiable, 0, len(records))
+	for _, r := range records {
+		dataList = append(dataList, audit.Derive(r))
+	}
+	result, err := SaveMany(audit.Con, dataList)
+	if err != nil {
+		return err
+	}
+	if result.HasFailures() {
+		return result.Failures[0].Err
+	}
+	return nil
+}
+
+// AuditHistory pages the audit records of an entity, newest first
+func AuditHistory(ctx context.Context, conType ConType, dbName, entity string, id int64, page *load.Page) (*load.PageRespT[*Complex[AuditRecord]], *errors.Error) {
+	audit := auditComplex(ctx, conType, dbName)
+	if audit.Con == nil {
+		return nil, errors.Sys("audit con not init")
+	}
+	audit.Sort.AddSort("at", false)
+	matches := NewMatches().Eq("entity", entity).Eq("entity_id", id)
+	resp := &load.PageRespT[*Complex[AuditRecord]]{Result: &[]*Complex[AuditRecord]{}}
+	if err := FindByPageMatchT(audit.Con, *matches, page, resp, resp.Result); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// AuditSnapshot reads the fields of data by the names used in update maps: column names on mysql, bson names on mongo
+func AuditSnapshot(c *Con, data interface{}) map[string]interface{} {
+	rv := reflect.Indirect(reflect.ValueOf(data))
+	if !rv.IsValid() || rv.Kind() != reflect.Struct {
+		return nil
+	}
+	snapshot := make(map[string]interface{})
+	if c.GetConType() == Mysql && c.MysqlDB != nil {
+		sch, err := schema.Parse(data, gormSchemaCache, c.MysqlDB.NamingStrategy)
+		if err != nil {
+			logger.Warn(c.Ctx, "audit snapshot failed", zap.String("table", c.TableName()), zap.Error(err))
+			return nil
+		}
+		for _, field := range sch.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			value, _ := field.ValueOf(c.Ctx, rv)
+			snapshot[field.DBName] = auditValue(value)
+		}
+		return snapshot
+	}
+	bsonSnapshot(rv, snapshot)
+	return snapshot
+}
+
+func bsonSnapshot(rv reflect.Value, snapshot map[string]interface{}) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("bson")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := rv.Field(i)
+		if strings.Contains(opts, "inline") {
+			if fv = reflect.Indirect(fv); fv.IsValid() && fv.Kind() == reflect.Struct {
+				bsonSnapshot(fv, snapshot)
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		snapshot[name] = auditValue(fv.Interface())
+	}
+}
+
+// auditValue normalizes a value through json, so values read from the database and from update maps compare equal
+func auditValue(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var n interface{}
+	if err = json.Unmarshal(b, &n); err != nil {
+		return v
+	}
+	return n
+}
+
+// AuditDiff returns the changed fields between two snapshots, a nil snapshot stands for a missing record
+func AuditDiff(before, after map[string]interface{}) map[string]AuditChange {
+	diff := make(map[string]AuditChange)
+	for k, b := range before {
+		a, ok := after[k]
+		if !ok && after != nil {
+			continue
+		}
+		if !reflect.DeepEqual(a, b) {
+			diff[k] = AuditChange{Before: b, After: a}
+		}
+	}
+	for k, a := range after {
+		if _, ok := before[k]; !ok && a != nil {
+			diff[k] = AuditChange{After: a}
+		}
+	}
+	return diff
+}
+
+// AuditApply returns the snapshot after applying an update map of plain values, an update with UpdateExpr values
+// is audited from the records read after the write
+func AuditApply(before map[string]interface{}, update map[string]interface{}) map[string]interface{} {
+	after := make(map[string]interface{}, len(before)+len(update))
+	for k, v := range before {
+		after[k] = v
+	}
+	for k, v := range update {
+		after[k] = auditValue(v)
+	}
+	return after
+}
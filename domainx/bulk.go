@@ -0,0 +1,144 @@
+package domainx
+
+import (
+	checkErr "errors"
+	"fmt"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"reflect"
+	"strings"
+)
+
+type BulkOpType string
+
+const (
+	BulkInsert BulkOpType = "insert"
+	BulkUpdate BulkOpType = "update"
+	BulkDelete BulkOpType = "delete"
+)
+
+// BulkOp is one operation of BulkWrite.
+// Insert uses Data; update and delete match ID and Matches together, at least one of them must be set.
+type BulkOp struct {
+	Type    BulkOpType
+	Data    Identifiable
+	ID      int64
+	Matches []Match
+	Update  map[string]interface{}
+}
+
+// BulkFailure reports a failed row, Index is the position in the input list
+type BulkFailure struct {
+	Index int           `json:"index"`
+	ID    int64         `json:"id"`
+	Err   *errors.Error `json:"err"`
+}
+
+type BulkResult struct {
+	Inserted int64          `json:"inserted"`
+	Updated  int64          `json:"updated"`
+	Upserted int64          `json:"upserted"`
+	Deleted  int64          `json:"deleted"`
+	IDs      []int64        `json:"ids"`
+	Failures []*BulkFailure `json:"failures"`
+}
+
+func (r *BulkResult) HasFailures() bool {
+	return r != nil && len(r.Failures) > 0
+}
+
+func (r *BulkResult) addFailure(index int, id int64, err error) {
+	var e *errors.Error
+	if !checkErr.As(err, &e) {
+		e = errors.Sys(err.Error(), err)
+	}
+	r.Failures = append(r.Failures, &BulkFailure{Index: index, ID: id, Err: e})
+}
+
+// Failed reports whether the row at index has been recorded as a failure
+func (r *BulkResult) Failed(index int) bool {
+	for _, f := range r.Failures {
+		if f.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// DefBatchSize is the default batch size of bulk operations, ${ domainx.bulk.batch }
+func DefBatchSize() int {
+	return configure.GetInt("domainx.bulk.batch", 500)
+}
+
+// bulkChunks splits n items into [start, end) ranges of size batchSize
+func bulkChunks(n, batchSize int) [][2]int {
+	if batchSize <= 0 {
+		batchSize = DefBatchSize()
+	}
+	chunks := make([][2]int, 0, n/batchSize+1)
+	for start := 0; start < n; start += batchSize {
+		end := start + batchSize
+		if end > n {
+			end = n
+		}
+		chunks = append(chunks, [2]int{start, end})
+	}
+	return chunks
+}
+
+// typedSlice converts the items into a slice of their concrete type, gorm can not create from []Identifiable
+func typedSlice(items []Identifiable) interface{} {
+	if len(items) == 0 {
+		return nil
+	}
+	slice := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(items[0])), 0, len(items))
+	for _, item := range items {
+		slice = reflect.Append(slice, reflect.ValueOf(item))
+	}
+	return slice.Interface()
+}
+
+// bulkKey joins the values of the upsert fields into a comparable key
+func bulkKey(values []interface{}) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		parts = append(parts, aggString(v))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// prepareInsert assigns an ID, starts the version and applies the SaveCreateTime/SaveUpdateTime hooks of each row
+func prepareInsert(c *Con, data Identifiable) {
+	if data.GetID().IsNil() {
+		data.SetID(c.GenerateID())
+	}
+	initVersion(data)
+	if ct, ok := data.(ConTable); ok && ct.GetCon() != nil {
+		con := ct.GetCon()
+		if con.SaveCreateTime != nil {
+			con.SaveCreateTime()
+		}
+		if con.SaveUpdateTime != nil {
+			con.SaveUpdateTime()
+		}
+	}
+}
+
+func (op *BulkOp) id() int64 {
+	if op.Type == BulkInsert && op.Data != nil {
+		return op.Data.GetID().Int64()
+	}
+	return op.ID
+}
+
+func checkUpsertFields(fields []string) *errors.Error {
+	if len(fields) == 0 {
+		return errors.Sys("upsert fields cannot be empty")
+	}
+	for _, f := range fields {
+		if !ValueField(f).Check() {
+			return errors.Sys(fmt.Sprintf("upsert field is not valid: %s", f))
+		}
+	}
+	return nil
+}
@@ -2,10 +2,13 @@ package domainx
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/jom-io/gorig/global/variable"
 	configure "github.com/jom-io/gorig/utils/cofigure"
 	"gorm.io/gorm"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,6 +16,8 @@ type Options struct {
 	CreatedAt time.Time      `gorm:"autoCreateTime:second;" bson:"createAt" json:"created_at"`
 	UpdatedAt time.Time      `gorm:"autoUpdateTime:second" bson:"updateAt" json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"deleted_at" bson:"-"`
+	// Version is increased on every update, a record saved with a version > 0 is only written if the stored version still matches
+	Version int64 `gorm:"column:version;default:0" bson:"version" json:"version"`
 }
 
 func (o *Options) SaveCreate() {
@@ -23,10 +28,35 @@ func (o *Options) SaveUpdate() {
 	o.UpdatedAt = time.Now()
 }
 
+func (o *Options) GetVersion() int64 {
+	return o.Version
+}
+
+func (o *Options) SetVersion(version int64) {
+	o.Version = version
+}
+
 type Complex[T any] struct {
 	*Con
 	Data *T `bson:"data" gorm:"embedded" json:"data"`
 	Options
+	// Relations holds the preloaded related records by relation name, see dx.Preload
+	Relations map[string]any `bson:"-" gorm:"-" json:"relations,omitempty"`
+}
+
+// Related returns the preloaded records of the relation name, nil if it was not preloaded
+func (c *Complex[T]) Related(name string) any {
+	if c == nil {
+		return nil
+	}
+	return c.Relations[name]
+}
+
+func (c *Complex[T]) SetRelated(name string, value any) {
+	if c.Relations == nil {
+		c.Relations = make(map[string]any)
+	}
+	c.Relations[name] = value
 }
 
 func NewComplex[T any](conType ConType, dbName string, table string) *Complex[T] {
@@ -92,6 +122,23 @@ func CreateComplex[T any](ctx context.Context, conType ConType, dbName string, t
 	return &c
 }
 
+// Derive creates a Complex for data on the same connection and table as c, with its own ID and hooks
+func (c *Complex[T]) Derive(data *T) *Complex[T] {
+	if c == nil || c.Con == nil {
+		return nil
+	}
+	con := *c.Con
+	con.ID = 0
+	d := &Complex[T]{Con: &con, Data: data}
+	d.Con.SaveCreateTime = func() {
+		d.SaveCreate()
+	}
+	d.Con.SaveUpdateTime = func() {
+		d.SaveUpdate()
+	}
+	return d
+}
+
 type ComplexList[T any] []*Complex[T]
 
 func (c *ComplexList[T]) List() []*T {
@@ -106,3 +153,41 @@ func (c *ComplexList[T]) List() []*T {
 	}
 	return respList
 }
+
+// complexJSON is Complex without its json methods
+type complexJSON[T any] Complex[T]
+
+// MarshalJSON writes the id as a string since snowflake ids are beyond the 2^53 integers of javascript,
+// set ${ domainx.id.string } to false for the former json numbers. UnmarshalJSON reads both.
+func (c Complex[T]) MarshalJSON() ([]byte, error) {
+	if !configure.GetBool("domainx.id.string", true) {
+		return json.Marshal(complexJSON[T](c))
+	}
+	return json.Marshal(struct {
+		complexJSON[T]
+		ID string `json:"id"`
+	}{complexJSON[T](c), strconv.FormatInt(c.GetID().Int64(), 10)})
+}
+
+// UnmarshalJSON reads the id as a number or a string
+func (c *Complex[T]) UnmarshalJSON(b []byte) error {
+	v := struct {
+		*complexJSON[T]
+		ID json.RawMessage `json:"id"`
+	}{complexJSON: (*complexJSON[T])(c)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if len(v.ID) == 0 || string(v.ID) == "null" {
+		return nil
+	}
+	id, err := strconv.ParseInt(strings.Trim(string(v.ID), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("domainx: invalid id %s", v.ID)
+	}
+	if c.Con == nil {
+		c.Con = &Con{}
+	}
+	c.ID = id
+	return nil
+}
@@ -2,6 +2,7 @@ package domainx
 
 import (
 	"context"
+	checkErr "errors"
 	"github.com/jom-io/gorig/utils/errors"
 	"github.com/qiniu/qmgo"
 	"gorm.io/gorm"
@@ -19,6 +20,11 @@ type Con struct {
 	Sort           Sorts        `gorm:"-" bson:"-" json:"-"`
 	SelectFields   []string     `gorm:"-" bson:"-" json:"-"`
 	OmitFields     []string     `gorm:"-" bson:"-" json:"-"`
+	ExpectVersion  int64        `gorm:"-" bson:"-" json:"-"` // expected stored version for UpdatePart/UpdateByMatch, 0 means no check
+	TenantField    string       `gorm:"-" bson:"-" json:"-"` // field holding the tenant ID, set to scope every query to the tenant of Ctx
+	Unscoped       bool         `gorm:"-" bson:"-" json:"-"` // skips the tenant scope
+	ReadRoute      string       `gorm:"-" bson:"-" json:"-"` // RoutePrimary, RouteReplica or a replica name, see SetReadRoute
+	Partition      *Partition   `gorm:"-" bson:"-" json:"-"` // splits GTable into tables by time, see Partition
 	SaveCreateTime func()       `gorm:"-" bson:"-" json:"-"`
 	SaveUpdateTime func()       `gorm:"-" bson:"-" json:"-"`
 }
@@ -37,6 +43,38 @@ type Identifiable interface {
 	SetID(id int64)
 }
 
+// Versioned is implemented by records carrying an optimistic lock version, see Options.Version
+type Versioned interface {
+	GetVersion() int64
+	SetVersion(version int64)
+}
+
+// ErrVersionConflict is returned by the db services when the stored version no longer matches
+var ErrVersionConflict = checkErr.New("version conflict")
+
+// ErrCodeConflict is the code of the *errors.Error returned for a version conflict or a concurrent write, see ErrConflict
+const ErrCodeConflict = errors.CodeConflict
+
+// IsConflict reports whether err is a version conflict or a concurrent write, the record should be reloaded before retrying
+func IsConflict(err *errors.Error) bool {
+	return err.IsConflict()
+}
+
+// initVersion starts the version of a new record at 1
+func initVersion(data interface{}) {
+	if v, ok := data.(Versioned); ok && v.GetVersion() == 0 {
+		v.SetVersion(1)
+	}
+}
+
+// getVersion returns the version carried by data, 0 if it is not versioned
+func getVersion(data interface{}) int64 {
+	if v, ok := data.(Versioned); ok {
+		return v.GetVersion()
+	}
+	return 0
+}
+
 func GetLastID[T Identifiable](conList []T) int64 {
 	if len(conList) > 0 {
 		return conList[len(conList)-1].GetID().Int64()
@@ -107,6 +145,10 @@ func (c *Con) GenerateSetID() {
 	c.ID = c.GenerateID()
 }
 
+func (c *Con) SetExpectVersion(version int64) {
+	c.ExpectVersion = version
+}
+
 func (c *Con) SetSelectFields(fields ...string) {
 	c.SelectFields = sanitizeFields(fields...)
 }
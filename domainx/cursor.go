@@ -0,0 +1,177 @@
+package domainx
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/spf13/cast"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// PageCursor is the decoded keyset position of a page: the values of all sort keys and the id of a row.
+// The encoded form is opaque to clients, see load.Page.Cursor.
+type PageCursor struct {
+	Sort   string        `json:"s"`
+	Values []cursorValue `json:"v"`
+	ID     int64         `json:"id"`
+	Prev   bool          `json:"p,omitempty"` // page backwards from the row
+}
+
+type cursorValue struct {
+	T string `json:"t"`
+	V string `json:"v"`
+}
+
+// sortSignature binds a cursor to the sort it was built for
+func sortSignature(sorts Sorts) string {
+	parts := make([]string, 0, len(sorts))
+	for _, s := range sorts {
+		dir := "desc"
+		if s.Asc {
+			dir = "asc"
+		}
+		field := s.Field
+		if s.Prefix != "" {
+			field = s.Prefix + "." + field
+		}
+		parts = append(parts, field+":"+dir)
+	}
+	return strings.Join(parts, ",")
+}
+
+func toCursorValue(v interface{}) (cursorValue, bool) {
+	switch val := v.(type) {
+	case nil:
+		return cursorValue{}, false
+	case time.Time:
+		return cursorValue{T: "t", V: val.Format(time.RFC3339Nano)}, true
+	case *time.Time:
+		if val == nil {
+			return cursorValue{}, false
+		}
+		return cursorValue{T: "t", V: val.Format(time.RFC3339Nano)}, true
+	case primitive.DateTime:
+		return cursorValue{T: "t", V: val.Time().Format(time.RFC3339Nano)}, true
+	case string:
+		return cursorValue{T: "s", V: val}, true
+	case []byte:
+		return cursorValue{T: "s", V: string(val)}, true
+	case bool:
+		return cursorValue{T: "b", V: cast.ToString(val)}, true
+	case float32, float64:
+		return cursorValue{T: "f", V: cast.ToString(val)}, true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return cursorValue{T: "i", V: cast.ToString(val)}, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return cursorValue{}, false
+		}
+		return toCursorValue(rv.Elem().Interface())
+	}
+	return cursorValue{T: "s", V: cast.ToString(v)}, true
+}
+
+func (v cursorValue) value() (interface{}, error) {
+	switch v.T {
+	case "t":
+		return time.Parse(time.RFC3339Nano, v.V)
+	case "s":
+		return v.V, nil
+	case "b":
+		return cast.ToBoolE(v.V)
+	case "f":
+		return cast.ToFloat64E(v.V)
+	case "i":
+		return cast.ToInt64E(v.V)
+	}
+	return nil, fmt.Errorf("unknown cursor value type %s", v.T)
+}
+
+// NewPageCursor builds the cursor of a row from its sort values, returns "" when a sort value is null
+func NewPageCursor(sorts Sorts, values []interface{}, id int64, prev bool) string {
+	cur := &PageCursor{Sort: sortSignature(sorts), ID: id, Prev: prev}
+	for _, v := range values {
+		cv, ok := toCursorValue(v)
+		if !ok {
+			return ""
+		}
+		cur.Values = append(cur.Values, cv)
+	}
+	b, err := json.Marshal(cur)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodePageCursor decodes a cursor and checks that it was built for sorts
+func DecodePageCursor(cursor string, sorts Sorts) (*PageCursor, *errors.Error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.Verify("page cursor is not valid", err)
+	}
+	cur := &PageCursor{}
+	if err = json.Unmarshal(b, cur); err != nil {
+		return nil, errors.Verify("page cursor is not valid", err)
+	}
+	if cur.Sort != sortSignature(sorts) || len(cur.Values) != len(sorts) {
+		return nil, errors.Verify("page cursor does not match the sort of the query")
+	}
+	return cur, nil
+}
+
+// SortValues returns the typed sort values of the cursor
+func (p *PageCursor) SortValues() ([]interface{}, error) {
+	values := make([]interface{}, 0, len(p.Values))
+	for _, v := range p.Values {
+		value, err := v.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// keysetTerm is one OR branch of the keyset condition: equal on the first sort keys, strict on the last one
+type keysetTerm struct {
+	Eq    []int // indexes of sorts compared with =
+	Index int   // index of the strict compared sort, len(sorts) for the id
+	Gt    bool
+}
+
+// keysetTerms expands (s1, s2, ..., id) > (v1, v2, ..., vid) for mixed directions,
+// rows after the cursor in sort order when forward, before it when prev. The id is always sorted desc.
+func keysetTerms(sorts Sorts, prev bool) []keysetTerm {
+	terms := make([]keysetTerm, 0, len(sorts)+1)
+	for i := 0; i <= len(sorts); i++ {
+		asc := false
+		if i < len(sorts) {
+			asc = sorts[i].Asc
+		}
+		eq := make([]int, 0, i)
+		for j := 0; j < i; j++ {
+			eq = append(eq, j)
+		}
+		terms = append(terms, keysetTerm{Eq: eq, Index: i, Gt: asc != prev})
+	}
+	return terms
+}
+
+// reverseSlice reverses a pointer to slice in place, used for backward pages
+func reverseSlice(result interface{}) {
+	rv := reflect.Indirect(reflect.ValueOf(result))
+	if rv.Kind() != reflect.Slice {
+		return
+	}
+	swap := reflect.Swapper(rv.Interface())
+	for i, j := 0, rv.Len()-1; i < j; i, j = i+1, j-1 {
+		swap(i, j)
+	}
+}
@@ -1,17 +1,25 @@
 package domainx
 
 import (
+	"database/sql"
 	"fmt"
 	"github.com/jom-io/gorig/apix/load"
 	"github.com/jom-io/gorig/global/errc"
 	configure "github.com/jom-io/gorig/utils/cofigure"
 	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/geo"
 	"github.com/jom-io/gorig/utils/gormt"
 	"github.com/jom-io/gorig/utils/logger"
 	"github.com/jom-io/gorig/utils/sys"
+	"github.com/spf13/cast"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -61,25 +69,7 @@ func (*gormDBService) Migrate(con *Con, tableName string, value ConTable, indexL
 		sys.Error("AutoMigrate error", err)
 		logger.Logger.Fatal("AutoMigrate error", zap.Error(err))
 	}
-	for _, v := range indexList {
-		var count int64
-		con.MysqlDB.Raw("SELECT count(1) FROM information_schema.STATISTICS WHERE table_schema = ? AND table_name = ? AND index_name = ?", con.MysqlDB.Migrator().CurrentDatabase(), tableName, v.IdxName).Count(&count)
-		if count == 0 {
-			sql := "CREATE IDXTYPE% `" + v.IdxName + "` ON `" + tableName + "` ("
-			for _, field := range v.Fields {
-				sql += "`" + field + "`,"
-			}
-			sql = sql[:len(sql)-1] + ")"
-			if v.IdxType == Unique {
-				sql = strings.Replace(sql, "IDXTYPE%", " UNIQUE INDEX", -1)
-			} else {
-				sql = strings.Replace(sql, "IDXTYPE%", " INDEX", -1)
-			}
-			con.MysqlDB.Exec(sql)
-		}
-	}
-
-	return nil
+	return migrateMysqlIndexes(con, tableName, indexList)
 }
 
 func (*gormDBService) End() error {
@@ -103,10 +93,10 @@ func initMysqlDB(dbname ...string) {
 }
 
 func (s *gormDBService) GetByID(c *Con, id int64, result interface{}) error {
-	if c.MysqlDB.WithContext(c.Ctx) == nil {
+	if c.mysqlDB() == nil {
 		return fmt.Errorf("get db is nil")
 	}
-	tx := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName())
+	tx := c.mysqlDB().Table(c.TableName())
 	tx = applyMysqlFields(tx, c, nil)
 	if err := tx.Where("id = ?", id).First(result).Error; err != nil {
 		return err
@@ -121,21 +111,29 @@ func (s *gormDBService) Save(c *Con, data Identifiable, newID int64, version ...
 		if newID != 0 {
 			c.ID = newID
 		}
-		tx = c.MysqlDB.WithContext(c.Ctx).Table(c.TableName()).Create(data)
+		initVersion(data)
+		tx = c.mysqlDB().Table(c.TableName()).Create(data)
 	} else {
 		if version != nil && len(version) > 0 && version[0] > 0 {
-			tx = c.MysqlDB.WithContext(c.Ctx).Table(c.TableName()).Where("id", data.GetID()).Updates(data)
+			if err = s.updateSaved(c, data); err != nil {
+				return 0, err
+			}
+			return data.GetID().Int64(), nil
 		} else {
-			if eg := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName()).Where("id = ?", data.GetID()).Count(&id); eg.Error != nil {
+			if eg := c.mysqlDB().Table(c.TableName()).Where("id = ?", data.GetID()).Count(&id); eg.Error != nil {
 				return 0, eg.Error
 			}
 			if id == 0 {
-				tx = c.MysqlDB.WithContext(c.Ctx).Table(c.TableName()).Create(data)
+				initVersion(data)
+				tx = c.mysqlDB().Table(c.TableName()).Create(data)
 			} else {
-				tx = c.MysqlDB.WithContext(c.Ctx).Table(c.TableName()).Where("id = ?", data.GetID()).Updates(data)
+				if err = s.updateSaved(c, data); err != nil {
+					return 0, err
+				}
+				return data.GetID().Int64(), nil
 			}
 		}
-		//tx = c.MysqlDB.WithContext(c.Ctx).Table(c.TableName()).Save(data)
+		//tx = c.mysqlDB().Table(c.TableName()).Save(data)
 	}
 	if tx.Error != nil {
 		return 0, tx.Error
@@ -143,48 +141,107 @@ func (s *gormDBService) Save(c *Con, data Identifiable, newID int64, version ...
 	return data.GetID().Int64(), nil
 }
 
-func (s *gormDBService) UpdatePart(c *Con, id int64, data map[string]interface{}) error {
-	data["updated_at"] = time.Now()
-	if err := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName()).Where("id = ?", id).Updates(data).Error; err != nil {
-		return err
+// updateSaved updates an existing record, a versioned record is only written if the stored version still matches
+func (s *gormDBService) updateSaved(c *Con, data Identifiable) error {
+	tx := c.mysqlDB().Table(c.TableName()).Where("id = ?", data.GetID())
+	expect := getVersion(data)
+	if expect <= 0 {
+		// the version is still bumped like the $inc of mongo, so a later versioned write sees this one
+		return c.mysqlDB().Transaction(func(db *gorm.DB) error {
+			if err := db.Table(c.TableName()).Where("id = ?", data.GetID()).Updates(data).Error; err != nil {
+				return err
+			}
+			return db.Table(c.TableName()).Where("id = ?", data.GetID()).UpdateColumn("version", gorm.Expr("version + 1")).Error
+		})
+	}
+	data.(Versioned).SetVersion(expect + 1)
+	tx = tx.Where("version = ?", expect).Updates(data)
+	if tx.Error != nil {
+		data.(Versioned).SetVersion(expect)
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		data.(Versioned).SetVersion(expect)
+		exists, err := s.ExistsByMatch(c, []Match{{Field: "id", Value: data.GetID().Int64(), Type: MEq}})
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrVersionConflict
+		}
 	}
 	return nil
 }
 
-func (s *gormDBService) UpdateByMatch(c *Con, matchList []Match, data map[string]interface{}) error {
-	tx := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName())
+func (s *gormDBService) UpdatePart(c *Con, id int64, data map[string]interface{}) (int64, error) {
+	tx := c.mysqlDB().Table(c.TableName()).Where("id = ?", id)
+	if c.ExpectVersion > 0 {
+		tx = tx.Where("version = ?", c.ExpectVersion)
+	}
+	columns, err := mysqlUpdate(data)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Updates(columns).Error; err != nil {
+		return 0, err
+	}
+	if c.ExpectVersion > 0 && tx.RowsAffected == 0 {
+		exists, err := s.ExistsByMatch(c, []Match{{Field: "id", Value: id, Type: MEq}})
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			return 0, ErrVersionConflict
+		}
+	}
+	return tx.RowsAffected, nil
+}
+
+func (s *gormDBService) UpdateByMatch(c *Con, matchList []Match, data map[string]interface{}) (int64, error) {
+	tx := c.mysqlDB().Table(c.TableName())
 	tx, _ = matchMysqlCond(matchList, tx)
-	data["updated_at"] = time.Now()
-	tx = tx.Updates(data)
+	if c.ExpectVersion > 0 {
+		tx = tx.Where("version = ?", c.ExpectVersion)
+	}
+	columns, err := mysqlUpdate(data)
+	if err != nil {
+		return 0, err
+	}
+	tx = tx.Updates(columns)
 	if err := tx.Error; err != nil {
-		return err
+		return 0, err
+	}
+	if c.ExpectVersion > 0 && tx.RowsAffected == 0 {
+		exists, err := s.ExistsByMatch(c, matchList)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			return 0, ErrVersionConflict
+		}
 	}
 	if len(matchList) > 0 && tx.RowsAffected == 0 {
 		exists, err := s.ExistsByMatch(c, matchList)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		if !exists {
-			return fmt.Errorf("no records matched update condition")
+			return 0, errNoMatch
 		}
 	}
-	return nil
+	return tx.RowsAffected, nil
 }
 
-func (s *gormDBService) Delete(c *Con, data Identifiable) error {
-	if err := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName()).Where("id = ?", data.GetID()).Delete(&Options{}).Error; err != nil {
-		return err
-	}
-	return nil
+func (s *gormDBService) Delete(c *Con, data Identifiable) (int64, error) {
+	tx := c.mysqlDB().Table(c.TableName()).Where("id = ?", data.GetID()).Delete(&Options{})
+	return tx.RowsAffected, tx.Error
 }
 
-func (s *gormDBService) DeleteByMatch(c *Con, matchList []Match) error {
-	tx := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName())
+func (s *gormDBService) DeleteByMatch(c *Con, matchList []Match) (int64, error) {
+	tx := c.mysqlDB().Table(c.TableName())
 	tx, _ = matchMysqlCond(matchList, tx)
-	if err := tx.Delete(&Options{}).Error; err != nil {
-		return err
-	}
-	return tx.Error
+	tx = tx.Delete(&Options{})
+	return tx.RowsAffected, tx.Error
 }
 
 var mysqlKeywords = []string{
@@ -192,6 +249,22 @@ var mysqlKeywords = []string{
 	"DESCRIBE", "EXPLAIN", "SHOW", "GRANT", "REVOKE", "USE", "LOCK", "UNLOCK", "SET", "COMMIT", "ROLLBACK",
 }
 
+// mysqlColumn is a plain or backquoted column name, the columns of text and geo matches are written into the sql
+var mysqlColumn = regexp.MustCompile("^(`[a-zA-Z_][a-zA-Z0-9_]*`|[a-zA-Z_][a-zA-Z0-9_]*)$")
+
+func mysqlColumns(columns []string) bool {
+	for _, column := range columns {
+		if !mysqlColumn.MatchString(column) {
+			return false
+		}
+	}
+	return true
+}
+
+func invalidMatchField(match Match) *errors.Error {
+	return errors.Verify(fmt.Sprintf("%s match field %q is not a valid column list", match.Type, match.Field))
+}
+
 func matchMysqlCond(matchList []Match, tx *gorm.DB) (*gorm.DB, *NearMatch) {
 	var nearMatch *NearMatch
 	for _, match := range matchList {
@@ -261,6 +334,18 @@ func matchMysqlCond(matchList []Match, tx *gorm.DB) (*gorm.DB, *NearMatch) {
 		case MNEmpty:
 			tx = tx.Where(match.Field + " != '' and " + match.Field + " is not null")
 			continue
+		case MWithinPolygon, MWithinBox, MIntersects:
+			tx = matchMysqlGeo(tx, match)
+			continue
+		case MText:
+			expr, ok := mysqlMatchExpr(match.Field)
+			if !ok {
+				// dropping the match would return every row
+				_ = tx.AddError(invalidMatchField(match))
+				continue
+			}
+			tx = tx.Where(expr, match.Value).Set(mysqlTextKey, &mysqlText{expr: expr, query: match.Value})
+			continue
 		case Near:
 			near := match.ToNearMatch()
 			if near.Distance > 0 {
@@ -284,10 +369,71 @@ func matchMysqlCond(matchList []Match, tx *gorm.DB) (*gorm.DB, *NearMatch) {
 	return tx, nearMatch
 }
 
+// matchMysqlGeo matches a spatial column with ST_Within or ST_Intersects, the shape is in SRID 0 as lng lat.
+// "lat,lng" columns are matched by the bounding box of the shape, and by ST_Contains for a polygon.
+// An invalid match fails the query instead of matching every row.
+func matchMysqlGeo(tx *gorm.DB, match Match) *gorm.DB {
+	shape := match.GeoShape()
+	columns := strings.Split(match.Field, ",")
+	if len(columns) > 2 || !mysqlColumns(columns) {
+		_ = tx.AddError(invalidMatchField(match))
+		return tx
+	}
+	if len(shape) == 0 || (match.Type != MWithinBox && len(shape) == 2) {
+		_ = tx.AddError(errors.Verify(fmt.Sprintf("%s match on %s needs one point or a polygon of at least 3 points", match.Type, match.Field)))
+		return tx
+	}
+	if len(columns) == 1 {
+		if match.Type == MIntersects {
+			return tx.Where("ST_Intersects("+match.Field+", ST_GeomFromText(?))", geo.WKT(shape))
+		}
+		return tx.Where("ST_Within("+match.Field+", ST_GeomFromText(?))", geo.WKT(shape))
+	}
+	lat, lng := columns[0], columns[1]
+	box := geo.Bounds(shape)
+	tx = tx.Where(lat+" between ? and ? and "+lng+" between ? and ?", box.Min.Lat, box.Max.Lat, box.Min.Lng, box.Max.Lng)
+	if match.Type != MWithinBox && len(shape) > 2 {
+		tx = tx.Where("ST_Contains(ST_GeomFromText(?), POINT("+lng+", "+lat+"))", geo.WKT(shape))
+	}
+	return tx
+}
+
 func mysqlNearExpr(near NearMatch) string {
 	return "6371 * acos(cos(radians(?)) * cos(radians(" + near.LatField + ")) * cos(radians(" + near.LngField + ") - radians(?)) + sin(radians(?)) * sin(radians(" + near.LatField + ")))"
 }
 
+// mysqlTextKey holds the mysqlText of a query with a MText match, read to select and sort by its score
+const mysqlTextKey = "domainx:text"
+
+type mysqlText struct {
+	expr  string
+	query interface{}
+}
+
+// mysqlMatchExpr is the MATCH ... AGAINST of the comma separated fields of a MText match
+func mysqlMatchExpr(fields string) (string, bool) {
+	columns := strings.Split(fields, ",")
+	if !mysqlColumns(columns) {
+		return "", false
+	}
+	return "MATCH(" + strings.Join(columns, ",") + ") AGAINST(? IN BOOLEAN MODE)", true
+}
+
+func mysqlTextOf(tx *gorm.DB) *mysqlText {
+	if v, ok := tx.Get(mysqlTextKey); ok {
+		return v.(*mysqlText)
+	}
+	return nil
+}
+
+// orderMysqlText sorts the results of a full-text search by relevance before the other sorts
+func orderMysqlText(tx *gorm.DB) *gorm.DB {
+	if mysqlTextOf(tx) == nil {
+		return tx
+	}
+	return tx.Order("score desc")
+}
+
 func sortMysqlCond(sortList Sorts, tx *gorm.DB) {
 	if len(sortList) > 0 {
 		for _, v := range sortList {
@@ -306,12 +452,23 @@ func applyMysqlFields(tx *gorm.DB, c *Con, near *NearMatch) *gorm.DB {
 	}
 
 	selectFields := append([]string{}, c.SelectFields...)
+	var args []interface{}
 	if near != nil {
 		if len(selectFields) == 0 {
 			selectFields = append(selectFields, "*")
 		}
 		selectFields = append(selectFields, "("+mysqlNearExpr(*near)+") AS distance")
-		tx = tx.Select(strings.Join(selectFields, ","), near.Lat, near.Lng, near.Lat)
+		args = append(args, near.Lat, near.Lng, near.Lat)
+	}
+	if text := mysqlTextOf(tx); text != nil {
+		if len(selectFields) == 0 {
+			selectFields = append(selectFields, "*")
+		}
+		selectFields = append(selectFields, text.expr+" AS score")
+		args = append(args, text.query)
+	}
+	if len(args) > 0 {
+		tx = tx.Select(strings.Join(selectFields, ","), args...)
 	} else if len(selectFields) > 0 {
 		tx = tx.Select(selectFields)
 	}
@@ -323,8 +480,9 @@ func applyMysqlFields(tx *gorm.DB, c *Con, near *NearMatch) *gorm.DB {
 }
 
 func (s *gormDBService) FindByMatch(c *Con, matchList []Match, result interface{}, prefixes ...string) error {
-	tx := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName())
+	tx := c.mysqlDB().Table(c.TableName())
 	tx, near := matchMysqlCond(matchList, tx)
+	tx = orderMysqlText(tx)
 	sortMysqlCond(c.Sort, tx)
 	tx = applyMysqlFields(tx, c, near)
 	if err := tx.Limit(10000).Find(result).Error; err != nil {
@@ -333,9 +491,117 @@ func (s *gormDBService) FindByMatch(c *Con, matchList []Match, result interface{
 	return tx.Error
 }
 
+// gormRowCursor scans the rows of a query one by one, the mysql driver streams them from the server
+type gormRowCursor struct {
+	tx   *gorm.DB
+	rows *sql.Rows
+	err  error
+}
+
+func (r *gormRowCursor) Next(result interface{}) bool {
+	if r.err != nil || !r.rows.Next() {
+		return false
+	}
+	if err := r.tx.ScanRows(r.rows, result); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+func (r *gormRowCursor) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.rows.Err()
+}
+
+func (r *gormRowCursor) Close() error {
+	return r.rows.Close()
+}
+
+// IterByMatch streams the rows of one query, the driver reads them from the connection as Next is called.
+// batchSize does not apply, FindInBatches would run a query per batch and only in the primary key order.
+func (s *gormDBService) IterByMatch(c *Con, matchList []Match, batchSize int, prefixes ...string) (RowCursor, error) {
+	tx := c.mysqlDB().Table(c.TableName())
+	tx, near := matchMysqlCond(matchList, tx)
+	tx = orderMysqlText(tx.Where("deleted_at is null"))
+	sortMysqlCond(c.Sort, tx)
+	tx = applyMysqlFields(tx, c, near)
+	rows, err := tx.Rows()
+	if err != nil {
+		return nil, err
+	}
+	return &gormRowCursor{tx: tx, rows: rows}, nil
+}
+
+// mysqlUpdate returns the columns written by an update of data, its UpdateExpr values replaced with their sql expressions,
+// with the updated_at and version of the record. data is left as is, callers reuse it.
+func mysqlUpdate(data map[string]interface{}) (map[string]interface{}, error) {
+	columns := make(map[string]interface{}, len(data)+2)
+	for col, v := range data {
+		if e, ok := v.(UpdateExpr); ok {
+			expr, err := mysqlUpdateExpr(col, e)
+			if err != nil {
+				return nil, err
+			}
+			v = expr
+		}
+		columns[col] = v
+	}
+	columns["updated_at"] = time.Now()
+	columns["version"] = gorm.Expr("version + 1")
+	return columns, nil
+}
+
+// jsonSearchEscape escapes the wildcards of a JSON_SEARCH string, its escape char defaults to \
+var jsonSearchEscape = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func mysqlUpdateExpr(col string, e UpdateExpr) (interface{}, error) {
+	switch e.Op {
+	case OpInc:
+		return gorm.Expr("COALESCE("+col+", 0) + ?", e.Values[0]), nil
+	case OpMin:
+		return gorm.Expr("LEAST(COALESCE("+col+", ?), ?)", e.Values[0], e.Values[0]), nil
+	case OpMax:
+		return gorm.Expr("GREATEST(COALESCE("+col+", ?), ?)", e.Values[0], e.Values[0]), nil
+	case OpUnset:
+		return nil, nil
+	case OpPush:
+		return gorm.Expr("JSON_ARRAY_APPEND(COALESCE("+col+", JSON_ARRAY())"+strings.Repeat(", '$', ?", len(e.Values))+")", e.Values...), nil
+	case OpAddToSet:
+		expr, args := "COALESCE("+col+", JSON_ARRAY())", []interface{}{}
+		for _, v := range e.Values {
+			next := make([]interface{}, 0, len(args)*3+2)
+			next = append(append(next, args...), v)
+			next = append(append(append(next, args...), args...), v)
+			expr = fmt.Sprintf("IF(JSON_CONTAINS(%s, JSON_ARRAY(?)), %s, JSON_ARRAY_APPEND(%s, '$', ?))", expr, expr, expr)
+			args = next
+		}
+		return gorm.Expr(expr, args...), nil
+	case OpPull:
+		expr, args := col, []interface{}{}
+		for _, v := range e.Values {
+			if _, ok := v.(string); !ok {
+				return nil, fmt.Errorf("pull on %s: mysql only pulls string values", col)
+			}
+			next := make([]interface{}, 0, len(args)*3+1)
+			next = append(append(append(next, args...), args...), jsonSearchEscape.Replace(v.(string)))
+			next = append(next, args...)
+			expr = fmt.Sprintf("COALESCE(JSON_REMOVE(%s, JSON_UNQUOTE(JSON_SEARCH(%s, 'one', ?))), %s)", expr, expr, expr)
+			args = next
+		}
+		return gorm.Expr(expr, args...), nil
+	case OpSetOnInsert:
+		return nil, fmt.Errorf("set on insert of %s is mongo only, use UpsertBy on mysql", col)
+	}
+	return nil, fmt.Errorf("unknown update operator %s on %s", e.Op, col)
+}
+
 func (s *gormDBService) GetByMatch(c *Con, matchList []Match, result interface{}) error {
-	tx := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName())
+	tx := c.mysqlDB().Table(c.TableName())
 	tx, near := matchMysqlCond(matchList, tx)
+	tx = orderMysqlText(tx)
 	sortMysqlCond(c.Sort, tx)
 	tx = applyMysqlFields(tx, c, near)
 	if err := tx.First(result).Error; err != nil {
@@ -344,8 +610,43 @@ func (s *gormDBService) GetByMatch(c *Con, matchList []Match, result interface{}
 	return tx.Error
 }
 
+// FindOneAndUpdate locks the first matched record in a transaction, reads it before or after the update
+func (s *gormDBService) FindOneAndUpdate(c *Con, matchList []Match, data map[string]interface{}, returnNew bool, result interface{}) error {
+	columns, err := mysqlUpdate(data)
+	if err != nil {
+		return err
+	}
+	return c.mysqlDB().Transaction(func(db *gorm.DB) error {
+		tx := db.Table(c.TableName()).Where("deleted_at is null").Clauses(clause.Locking{Strength: "UPDATE"})
+		tx, _ = matchMysqlCond(matchList, tx)
+		sortMysqlCond(c.Sort, tx)
+		var ids []int64
+		if err := tx.Limit(1).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		load := func() error {
+			return applyMysqlFields(db.Table(c.TableName()).Where("id = ?", ids[0]), c, nil).First(result).Error
+		}
+		if !returnNew {
+			if err := load(); err != nil {
+				return err
+			}
+		}
+		if err := db.Table(c.TableName()).Where("id = ?", ids[0]).Updates(columns).Error; err != nil {
+			return err
+		}
+		if returnNew {
+			return load()
+		}
+		return nil
+	})
+}
+
 func (s *gormDBService) CountByMatch(c *Con, matchList []Match) (int64, error) {
-	tx := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName()).Where("deleted_at is null")
+	tx := c.mysqlDB().Table(c.TableName()).Where("deleted_at is null")
 	tx, _ = matchMysqlCond(matchList, tx)
 	var count int64
 	if err := tx.Count(&count).Error; err != nil {
@@ -355,7 +656,7 @@ func (s *gormDBService) CountByMatch(c *Con, matchList []Match) (int64, error) {
 }
 
 func (s *gormDBService) ExistsByMatch(c *Con, matchList []Match) (bool, error) {
-	tx := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName())
+	tx := c.mysqlDB().Table(c.TableName())
 	tx, _ = matchMysqlCond(matchList, tx)
 	var exists int
 	if err := tx.Select("1").Limit(1).Scan(&exists).Error; err != nil {
@@ -365,7 +666,7 @@ func (s *gormDBService) ExistsByMatch(c *Con, matchList []Match) (bool, error) {
 }
 
 func (s *gormDBService) SumByMatch(c *Con, matchList []Match, field string) (float64, error) {
-	tx := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName())
+	tx := c.mysqlDB().Table(c.TableName())
 	if !Check(field) {
 		return 0, errors.Sys(fmt.Sprintf("field is not valid: %s", field))
 	}
@@ -381,8 +682,12 @@ func (s *gormDBService) SumByMatch(c *Con, matchList []Match, field string) (flo
 }
 
 func (s *gormDBService) FindByPageMatch(c *Con, matchList []Match, page *load.Page, total *load.Total, result interface{}, prefixes ...string) error {
-	tx := c.MysqlDB.WithContext(c.Ctx).Table(c.TableName())
+	tx := c.mysqlDB().Table(c.TableName())
 	tx, near := matchMysqlCond(matchList, tx)
+	if page.Cursor != "" {
+		return s.findByCursor(c, tx, near, page, total, result)
+	}
+	tx = orderMysqlText(tx)
 	sortMysqlCond(c.Sort, tx)
 	count := int64(0)
 	if err := tx.Model(result).Count(&count).Error; err != nil {
@@ -400,3 +705,338 @@ func (s *gormDBService) FindByPageMatch(c *Con, matchList []Match, page *load.Pa
 	total.Set(count)
 	return tx.Error
 }
+
+func mysqlAggExpr(f AggField) string {
+	switch f.Agg {
+	case AggCount:
+		return "count(1)"
+	case AggCountDistinct:
+		return "count(distinct " + f.Field + ")"
+	default:
+		return string(f.Agg) + "(" + f.Field + ")"
+	}
+}
+
+func havingMysqlCond(havingList []Match, tx *gorm.DB) *gorm.DB {
+	for _, h := range havingList {
+		switch h.Type {
+		case MEq, MLt, MLte, MGt, MGte, MNE:
+			tx = tx.Having(h.Field+" "+string(h.Type)+" ?", h.Value)
+		case MIN:
+			tx = tx.Having(h.Field+" in (?)", h.Value)
+		case MNOTIN:
+			tx = tx.Having(h.Field+" not in (?)", h.Value)
+		default:
+			tx = tx.Having(h.Field+" = ?", h.Value)
+		}
+	}
+	return tx
+}
+
+// findByCursor loads the page after (or before) the cursor row with a keyset condition on the sort fields and id
+func (s *gormDBService) findByCursor(c *Con, tx *gorm.DB, near *NearMatch, page *load.Page, total *load.Total, result interface{}) error {
+	cur, cErr := DecodePageCursor(page.Cursor, c.Sort)
+	if cErr != nil {
+		return cErr
+	}
+	values, err := cur.SortValues()
+	if err != nil {
+		return err
+	}
+	count := int64(0)
+	if err = tx.Model(result).Count(&count).Error; err != nil {
+		return err
+	}
+
+	columns := make([]string, 0, len(c.Sort)+1)
+	for _, v := range c.Sort {
+		if !ValueField(v.Field).Check() {
+			return errors.Verify(fmt.Sprintf("sort field is not valid: %s", v.Field))
+		}
+		columns = append(columns, v.Field)
+	}
+	columns = append(columns, "id")
+	values = append(values, cur.ID)
+
+	terms := keysetTerms(c.Sort, cur.Prev)
+	conds := make([]string, 0, len(terms))
+	args := make([]interface{}, 0, len(terms)*len(columns))
+	for _, term := range terms {
+		parts := make([]string, 0, len(term.Eq)+1)
+		for _, j := range term.Eq {
+			parts = append(parts, columns[j]+" = ?")
+			args = append(args, values[j])
+		}
+		op := " < ?"
+		if term.Gt {
+			op = " > ?"
+		}
+		parts = append(parts, columns[term.Index]+op)
+		args = append(args, values[term.Index])
+		conds = append(conds, "("+strings.Join(parts, " AND ")+")")
+	}
+	query := tx.Where(strings.Join(conds, " OR "), args...)
+	for i, v := range c.Sort {
+		dir := " desc"
+		if v.Asc != cur.Prev {
+			dir = " asc"
+		}
+		query = query.Order(columns[i] + dir)
+	}
+	if cur.Prev {
+		query = query.Order("id asc")
+	} else {
+		query = query.Order("id desc")
+	}
+	query = applyMysqlFields(query.Limit(int(page.Size)), c, near)
+	if err = query.Find(result).Error; err != nil {
+		return err
+	}
+	if cur.Prev {
+		reverseSlice(result)
+	}
+	total.Set(count)
+	return nil
+}
+
+func (s *gormDBService) CursorValues(c *Con, row interface{}) ([]interface{}, error) {
+	columns := make([]string, 0, len(c.Sort))
+	for _, v := range c.Sort {
+		columns = append(columns, v.Field)
+	}
+	return mysqlFieldValues(c, row, columns)
+}
+
+func (s *gormDBService) AggregateByMatch(c *Con, matchList []Match, agg *Aggregation, total *load.Total, result *[]*AggItem) error {
+	if err := agg.Validate(); err != nil {
+		return err
+	}
+	selectList := make([]string, 0, len(agg.GroupFields)+len(agg.AggFields))
+	selectList = append(selectList, agg.GroupFields...)
+	for _, f := range agg.AggFields {
+		selectList = append(selectList, mysqlAggExpr(f)+" AS "+f.Alias)
+	}
+	build := func() *gorm.DB {
+		tx := c.mysqlDB().Table(c.TableName()).Where("deleted_at is null")
+		tx, _ = matchMysqlCond(matchList, tx)
+		tx = tx.Select(strings.Join(selectList, ","))
+		if len(agg.GroupFields) > 0 {
+			tx = tx.Group(strings.Join(agg.GroupFields, ","))
+		}
+		return havingMysqlCond(agg.Having, tx)
+	}
+
+	var count int64
+	if err := c.mysqlDB().Table("(?) AS agg_t", build()).Count(&count).Error; err != nil {
+		return err
+	}
+
+	tx := build()
+	sortMysqlCond(agg.Sort, tx)
+	if agg.Page != nil {
+		tx = tx.Limit(int(agg.Page.Size)).Offset(int(agg.Page.Offset()))
+	} else {
+		tx = tx.Limit(10000)
+	}
+	rows := make([]map[string]interface{}, 0)
+	if err := tx.Find(&rows).Error; err != nil {
+		return err
+	}
+	items := make([]*AggItem, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, agg.toAggItem(row))
+	}
+	*result = items
+	total.Set(count)
+	return nil
+}
+
+var gormSchemaCache = &sync.Map{}
+
+// mysqlFieldValues reads the column values of data by column name
+func mysqlFieldValues(c *Con, data interface{}, columns []string) ([]interface{}, error) {
+	sch, err := schema.Parse(data, gormSchemaCache, c.MysqlDB.NamingStrategy)
+	if err != nil {
+		return nil, err
+	}
+	rv := reflect.Indirect(reflect.ValueOf(data))
+	values := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		field := sch.LookUpField(column)
+		if field == nil {
+			return nil, fmt.Errorf("field %s not found in %s", column, sch.Name)
+		}
+		value, _ := field.ValueOf(c.Ctx, rv)
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (s *gormDBService) SaveMany(c *Con, dataList []Identifiable, batchSize int, result *BulkResult) error {
+	for _, chunk := range bulkChunks(len(dataList), batchSize) {
+		batch := dataList[chunk[0]:chunk[1]]
+		tx := c.mysqlDB().Table(c.TableName()).Create(typedSlice(batch))
+		if tx.Error == nil {
+			result.Inserted += tx.RowsAffected
+			continue
+		}
+		// the batch failed as a whole, retry row by row to find out the failed rows
+		for i, data := range batch {
+			if err := c.mysqlDB().Table(c.TableName()).Create(data).Error; err != nil {
+				result.addFailure(chunk[0]+i, data.GetID().Int64(), err)
+				continue
+			}
+			result.Inserted++
+		}
+	}
+	return nil
+}
+
+// mysqlUpsertClause updates all columns on conflict except the creation time, and increases the version
+func mysqlUpsertClause(c *Con, data interface{}) (clause.OnConflict, error) {
+	sch, err := schema.Parse(data, gormSchemaCache, c.MysqlDB.NamingStrategy)
+	if err != nil {
+		return clause.OnConflict{}, err
+	}
+	columns := make([]string, 0, len(sch.DBNames))
+	for _, name := range sch.DBNames {
+		field := sch.LookUpField(name)
+		if field.PrimaryKey || field.AutoCreateTime > 0 || name == "version" {
+			continue
+		}
+		columns = append(columns, name)
+	}
+	upsert := clause.OnConflict{DoUpdates: clause.AssignmentColumns(columns)}
+	if sch.LookUpField("version") != nil {
+		upsert.DoUpdates = append(upsert.DoUpdates, clause.Assignment{Column: clause.Column{Name: "version"}, Value: gorm.Expr("version + 1")})
+	}
+	return upsert, nil
+}
+
+func (s *gormDBService) UpsertBy(c *Con, dataList []Identifiable, fields []string, batchSize int, result *BulkResult) error {
+	if len(dataList) == 0 {
+		return nil
+	}
+	upsert, err := mysqlUpsertClause(c, dataList[0])
+	if err != nil {
+		return err
+	}
+	for _, chunk := range bulkChunks(len(dataList), batchSize) {
+		batch := dataList[chunk[0]:chunk[1]]
+		if err := c.mysqlDB().Table(c.TableName()).Clauses(upsert).Create(typedSlice(batch)).Error; err != nil {
+			for i, data := range batch {
+				if err = c.mysqlDB().Table(c.TableName()).Clauses(upsert).Create(data).Error; err != nil {
+					result.addFailure(chunk[0]+i, data.GetID().Int64(), err)
+				}
+			}
+		}
+		if err := s.resolveUpsertIDs(c, batch, chunk[0], fields, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveUpsertIDs loads the IDs of the upserted rows by the upsert fields,
+// rows that already existed keep their stored ID instead of the generated one
+func (s *gormDBService) resolveUpsertIDs(c *Con, batch []Identifiable, offset int, fields []string, result *BulkResult) error {
+	keys := make([]string, len(batch))
+	tuples := make([][]interface{}, 0, len(batch))
+	for i, data := range batch {
+		if result.Failed(offset + i) {
+			continue
+		}
+		values, err := mysqlFieldValues(c, data, fields)
+		if err != nil {
+			return err
+		}
+		keys[i] = bulkKey(values)
+		tuples = append(tuples, values)
+	}
+	if len(tuples) == 0 {
+		return nil
+	}
+	rows := make([]map[string]interface{}, 0, len(tuples))
+	if err := c.mysqlDB().Table(c.TableName()).
+		Select(append([]string{"id"}, fields...)).
+		Where("("+strings.Join(fields, ",")+") IN ?", tuples).
+		Find(&rows).Error; err != nil {
+		return err
+	}
+	stored := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		values := make([]interface{}, 0, len(fields))
+		for _, f := range fields {
+			values = append(values, row[f])
+		}
+		stored[bulkKey(values)] = cast.ToInt64(row["id"])
+	}
+	for i, data := range batch {
+		if result.Failed(offset + i) {
+			continue
+		}
+		id, ok := stored[keys[i]]
+		if !ok || id == data.GetID().Int64() {
+			result.Upserted++
+			continue
+		}
+		result.Updated++
+		data.SetID(id)
+	}
+	return nil
+}
+
+func (s *gormDBService) BulkWrite(c *Con, ops []BulkOp, result *BulkResult) error {
+	for i, op := range ops {
+		if result.Failed(i) {
+			continue
+		}
+		tx := c.mysqlDB().Table(c.TableName())
+		switch op.Type {
+		case BulkInsert:
+			tx = tx.Create(op.Data)
+			result.Inserted += tx.RowsAffected
+		case BulkUpdate:
+			if op.ID > 0 {
+				tx = tx.Where("id = ?", op.ID)
+			}
+			tx, _ = matchMysqlCond(op.Matches, tx)
+			columns, err := mysqlUpdate(op.Update)
+			if err != nil {
+				result.addFailure(i, op.id(), err)
+				continue
+			}
+			tx = tx.Updates(columns)
+			result.Updated += tx.RowsAffected
+		case BulkDelete:
+			if op.ID > 0 {
+				tx = tx.Where("id = ?", op.ID)
+			}
+			tx, _ = matchMysqlCond(op.Matches, tx)
+			tx = tx.Delete(&Options{})
+			result.Deleted += tx.RowsAffected
+		}
+		if tx.Error != nil {
+			result.addFailure(i, op.id(), tx.Error)
+		}
+	}
+	return nil
+}
+
+func (s *gormDBService) Tables(c *Con, prefix string) ([]string, error) {
+	all, err := c.mysqlDB().Migrator().GetTables()
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(all))
+	for _, table := range all {
+		if strings.HasPrefix(table, prefix) {
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
+}
+
+func (s *gormDBService) DropTable(c *Con, table string) error {
+	return c.mysqlDB().Migrator().DropTable(table)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/jom-io/gorig/global/errc"
 	configure "github.com/jom-io/gorig/utils/cofigure"
 	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/geo"
 	"github.com/jom-io/gorig/utils/logger"
 	"github.com/jom-io/gorig/utils/sys"
 	"github.com/qiniu/qmgo"
@@ -17,6 +18,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -93,7 +95,7 @@ func (*mongoDBService) Start() error {
 
 func getColl(c *Con) (*qmgo.Collection, *errors.Error) {
 	mDb := c.MongoDB
-	gDdb, e := ddbCon(mDb, c.DBName)
+	gDdb, e := ddbCon(mDb, c.DBName, c.mongoReadPref()...)
 	if e != nil {
 		return nil, e
 	}
@@ -108,45 +110,7 @@ func (s *mongoDBService) Migrate(con *Con, tableName string, value ConTable, ind
 	if con.MongoDB == nil {
 		return errors.Sys("Migrate: mdb is nil")
 	}
-	if coll, e := getColl(con); e != nil {
-		return e
-	} else {
-		for _, index := range indexList {
-			var unique *bool
-			if index.IdxType == Unique {
-				unique = new(bool)
-				*unique = true
-			}
-			if index.Fields[0] != preCon.str() && index.Fields[0] != preOption.str() {
-				for i, v := range index.Fields {
-					index.Fields[i] = preData.ad(v)
-				}
-			}
-			if index.IdxType == Spatial2D {
-				mColl, err := coll.CloneCollection()
-				if err != nil {
-					return err
-				}
-				if _, err = mColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
-					Keys: bson.D{
-						{Key: index.Fields[0], Value: "2dsphere"},
-					},
-				}); err != nil {
-					return err
-				}
-				continue
-			}
-			if err := coll.CreateIndexes(context.Background(), []qoptions.IndexModel{{
-				Key: index.Fields,
-				IndexOptions: &options.IndexOptions{
-					Unique: unique,
-				},
-			}}); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+	return migrateMongoIndexes(con, tableName, indexList)
 }
 
 func (*mongoDBService) End() error {
@@ -213,11 +177,11 @@ func initMgoDB(dbname ...string) error {
 	return nil
 }
 
-func ddbCon(mdb *qmgo.Client, db string) (*qmgo.Database, *errors.Error) {
+func ddbCon(mdb *qmgo.Client, db string, opts ...*qoptions.DatabaseOptions) (*qmgo.Database, *errors.Error) {
 	if mdb == nil {
 		return nil, errors.Sys("DDB: mdb is nil")
 	}
-	database := mdb.Database(configure.GetString(configName + "." + db + ".db.name"))
+	database := mdb.Database(configure.GetString(configName+"."+db+".db.name"), opts...)
 	if database == nil {
 		return nil, errors.Sys("DDB: database is nil")
 	}
@@ -263,10 +227,29 @@ func (s *mongoDBService) Save(c *Con, data Identifiable, newID int64, version ..
 			if err != nil {
 				return 0, err
 			}
-			mErr := coll.UpdateOne(c.Ctx, bson.M{"con.id": c.ID}, bson.M{"$set": updateDoc})
+			filter := bson.M{"con.id": c.ID}
+			update := bson.M{"$set": updateDoc}
+			expect := getVersion(data)
+			if expect > 0 {
+				filter["options.version"] = expect
+				updateDoc["options.version"] = expect + 1
+			} else {
+				update["$inc"] = bson.M{"options.version": 1}
+			}
+			mErr := coll.UpdateOne(c.Ctx, filter, update)
+			if expect > 0 && checkErr.Is(mErr, qmgo.ErrNoSuchDocuments) {
+				if exists, eErr := s.ExistsByMatch(c, []Match{{Field: "con.id", Value: c.ID, Type: MEq}}); eErr != nil {
+					return 0, eErr
+				} else if exists {
+					return 0, ErrVersionConflict
+				}
+			}
 			if mErr != nil {
 				return 0, mErr
 			}
+			if expect > 0 {
+				data.(Versioned).SetVersion(expect + 1)
+			}
 			return c.ID, nil
 		} else {
 			if newID > 0 {
@@ -280,6 +263,7 @@ func (s *mongoDBService) Save(c *Con, data Identifiable, newID int64, version ..
 			if c.SaveUpdateTime != nil {
 				c.SaveUpdateTime()
 			}
+			initVersion(data)
 			one, mErr := coll.InsertOne(c.Ctx, data)
 			if mErr != nil {
 				return 0, mErr
@@ -312,50 +296,109 @@ func buildMongoSaveUpdateDoc(data Identifiable) (bson.M, error) {
 	return updateDoc, nil
 }
 
-func (s *mongoDBService) UpdatePart(c *Con, id int64, data map[string]interface{}) error {
+func (s *mongoDBService) UpdatePart(c *Con, id int64, data map[string]interface{}) (int64, error) {
 	if coll, e := getColl(c); e != nil {
-		return e
+		return 0, e
 	} else {
-		m := mapToBsonM(data)
-		m["options.updateTime"] = time.Now()
-		mErr := coll.UpdateOne(c.Ctx, bson.M{"con.id": id}, bson.M{"$set": mapToBsonM(data)})
-		return mErr
+		filter := bson.M{"con.id": id}
+		if c.ExpectVersion > 0 {
+			filter["options.version"] = c.ExpectVersion
+		}
+		// UpdateOne fails with ErrNoSuchDocuments when nothing matched, one document is written otherwise
+		mErr := coll.UpdateOne(c.Ctx, filter, mongoUpdate(data))
+		if c.ExpectVersion > 0 && checkErr.Is(mErr, qmgo.ErrNoSuchDocuments) {
+			if exists, eErr := s.ExistsByMatch(c, []Match{{Field: "con.id", Value: id, Type: MEq}}); eErr != nil {
+				return 0, eErr
+			} else if exists {
+				return 0, ErrVersionConflict
+			}
+		}
+		if mErr != nil {
+			return 0, mErr
+		}
+		return 1, nil
 	}
 }
 
-func (s *mongoDBService) UpdateByMatch(c *Con, matchList []Match, data map[string]interface{}) error {
+func (s *mongoDBService) UpdateByMatch(c *Con, matchList []Match, data map[string]interface{}) (int64, error) {
 	if coll, e := getColl(c); e != nil {
-		return e
+		return 0, e
 	} else {
-		condition := matchMongoCond(matchList)
-		result, mErr := coll.UpdateAll(c.Ctx, mapToBsonM(condition), bson.M{"$set": mapToBsonM(data)})
+		condition := mapToBsonM(matchMongoCond(matchList))
+		if c.ExpectVersion > 0 {
+			condition["options.version"] = c.ExpectVersion
+		}
+		result, mErr := coll.UpdateAll(c.Ctx, condition, mongoUpdate(data))
 		if mErr != nil {
-			return mErr
+			return 0, mErr
 		}
 		if len(matchList) > 0 && result.MatchedCount == 0 {
-			return fmt.Errorf("no records matched update condition")
+			if c.ExpectVersion > 0 {
+				if exists, eErr := s.ExistsByMatch(c, matchList); eErr != nil {
+					return 0, eErr
+				} else if exists {
+					return 0, ErrVersionConflict
+				}
+			}
+			return 0, errNoMatch
 		}
-		return mErr
+		return result.ModifiedCount, nil
 	}
 }
 
-func (s *mongoDBService) Delete(c *Con, data Identifiable) error {
+func (s *mongoDBService) Delete(c *Con, data Identifiable) (int64, error) {
 	if coll, e := getColl(c); e != nil {
-		return e
+		return 0, e
 	} else {
-		mErr := coll.Remove(c.Ctx, bson.M{"con.id": data.GetID()})
-		return mErr
+		// Remove fails with ErrNoSuchDocuments when nothing matched
+		if mErr := coll.Remove(c.Ctx, bson.M{"con.id": data.GetID()}); mErr != nil {
+			return 0, mErr
+		}
+		return 1, nil
 	}
 }
 
-func (s *mongoDBService) DeleteByMatch(c *Con, matchList []Match) error {
+func (s *mongoDBService) DeleteByMatch(c *Con, matchList []Match) (int64, error) {
 	if coll, e := getColl(c); e != nil {
-		return e
+		return 0, e
 	} else {
 		condition := matchMongoCond(matchList)
-		_, mErr := coll.RemoveAll(c.Ctx, mapToBsonM(condition))
-		return mErr
+		result, mErr := coll.RemoveAll(c.Ctx, mapToBsonM(condition))
+		if mErr != nil {
+			return 0, mErr
+		}
+		return result.DeletedCount, nil
+	}
+}
+
+// mongoUpdate builds the update document of data, an UpdateExpr value uses its operator and other values are set
+func mongoUpdate(data map[string]interface{}) bson.M {
+	set := bson.M{"options.updateAt": time.Now()}
+	update := bson.M{"$set": set, "$inc": bson.M{"options.version": 1}}
+	for k, v := range data {
+		e, ok := v.(UpdateExpr)
+		if !ok {
+			set[mongoFieldPath(k)] = v
+			continue
+		}
+		fields, ok := update[string(e.Op)].(bson.M)
+		if !ok {
+			fields = bson.M{}
+			update[string(e.Op)] = fields
+		}
+		key := mongoFieldPath(k)
+		switch {
+		case e.Op == OpUnset:
+			fields[key] = ""
+		case len(e.Values) == 1:
+			fields[key] = e.Values[0]
+		case e.Op == OpPull:
+			fields[key] = bson.M{"$in": e.Values}
+		default:
+			fields[key] = bson.M{"$each": e.Values}
+		}
 	}
+	return update
 }
 
 func mapToBsonM(m map[string]interface{}, prefixes ...string) bson.M {
@@ -370,7 +413,7 @@ func mapToBsonM(m map[string]interface{}, prefixes ...string) bson.M {
 	bm := bson.M{}
 	for k, v := range m {
 		key := k
-		if !hasDef(k) {
+		if !hasDef(k) && !strings.HasPrefix(k, "$") {
 			key = prefix + k
 		}
 		if strings.HasSuffix(key, ".") {
@@ -410,17 +453,76 @@ func sortMongoFields(s []*Sort) []string {
 			if !v.Asc {
 				order = "-"
 			}
-			prefix := preData
-			if v.Prefix != "" {
-				prefix = pre(v.Prefix)
-			}
-			sortList = append(sortList, order+prefix.ad(v.Field))
+			sortList = append(sortList, order+mongoSortPath(v))
 		}
 	}
 	sortList = append(sortList, "-con.id")
 	return sortList
 }
 
+func hasMongoText(filter bson.M) bool {
+	_, ok := filter["$text"]
+	return ok
+}
+
+// textMongoQuery is a $text search with the text score projected as score, sorted by relevance before the other sorts.
+// qmgo sorts by field names only, so it runs on the driver collection.
+func textMongoQuery(c *Con, coll *qmgo.Collection, filter bson.M, skip, limit int64) (*mongo.Cursor, error) {
+	mColl, err := coll.CloneCollection()
+	if err != nil {
+		return nil, err
+	}
+	projection := buildMongoProjection(c)
+	if projection == nil {
+		projection = bson.M{}
+	}
+	projection["score"] = bson.M{"$meta": "textScore"}
+	sort := bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}}
+	for _, field := range sortMongoFields(c.Sort) {
+		key, n := qmgo.SplitSortField(field)
+		sort = append(sort, bson.E{Key: key, Value: n})
+	}
+	opts := options.Find().SetProjection(projection).SetSort(sort)
+	if skip > 0 {
+		opts.SetSkip(skip)
+	}
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	return mColl.Find(c.Ctx, filter, opts)
+}
+
+func findMongoText(c *Con, coll *qmgo.Collection, filter bson.M, skip, limit int64, result interface{}) error {
+	cursor, err := textMongoQuery(c, coll, filter, skip, limit)
+	if err != nil {
+		return err
+	}
+	return cursor.All(c.Ctx, result)
+}
+
+func getMongoText(c *Con, coll *qmgo.Collection, filter bson.M, result interface{}) error {
+	cursor, err := textMongoQuery(c, coll, filter, 0, 1)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(c.Ctx)
+	if !cursor.Next(c.Ctx) {
+		if err = cursor.Err(); err != nil {
+			return err
+		}
+		return qmgo.ErrNoSuchDocuments
+	}
+	return cursor.Decode(result)
+}
+
+func mongoSortPath(v *Sort) string {
+	prefix := preData
+	if v.Prefix != "" {
+		prefix = pre(v.Prefix)
+	}
+	return prefix.ad(v.Field)
+}
+
 var mongoKeywords = []string{
 	"db", "collection", "aggregate", "find", "insert", "update", "delete",
 }
@@ -429,6 +531,10 @@ func matchMongoCond(matchList []Match) map[string]interface{} {
 	condition := make(map[string]interface{})
 
 	for _, match := range matchList {
+		if match.Type == MText {
+			condition["$text"] = bson.M{"$search": match.Value}
+			continue
+		}
 		// Normalize array helpers to existing operators to avoid duplications
 		if match.Type == MHas {
 			match.Type = MEq
@@ -495,6 +601,10 @@ func matchMongoCond(matchList []Match) map[string]interface{} {
 		case MNEmpty:
 			condMap["$exists"] = true
 			condMap["$not"] = bson.M{"$size": 0}
+		case MWithinPolygon, MWithinBox:
+			condMap["$geoWithin"] = bson.M{"$geometry": mongoGeometry(match.GeoShape())}
+		case MIntersects:
+			condMap["$geoIntersects"] = bson.M{"$geometry": mongoGeometry(match.GeoShape())}
 		case NearLoc:
 			near := match.ToNearMatch()
 			if near.Distance == 0 {
@@ -520,12 +630,29 @@ func matchMongoCond(matchList []Match) map[string]interface{} {
 	return condition
 }
 
+// mongoGeometry is the GeoJSON Point of a single point or the Polygon of more points
+func mongoGeometry(points []geo.Point) bson.M {
+	if len(points) == 1 {
+		return bson.M{"type": "Point", "coordinates": []float64{points[0].Lng, points[0].Lat}}
+	}
+	ring := geo.Closed(points)
+	coordinates := make([][]float64, len(ring))
+	for i, p := range ring {
+		coordinates[i] = []float64{p.Lng, p.Lat}
+	}
+	return bson.M{"type": "Polygon", "coordinates": [][][]float64{coordinates}}
+}
+
 func (s *mongoDBService) FindByMatch(c *Con, matchList []Match, result interface{}, prefixes ...string) error {
 	condition := matchMongoCond(matchList)
 	if coll, e := getColl(c); e != nil {
 		return e
 	} else {
-		query := coll.Find(c.Ctx, mapToBsonM(condition, prefixes...)).Sort(sortMongoFields(c.Sort)...)
+		filter := mapToBsonM(condition, prefixes...)
+		if hasMongoText(filter) {
+			return findMongoText(c, coll, filter, 0, 10000, result)
+		}
+		query := coll.Find(c.Ctx, filter).Sort(sortMongoFields(c.Sort)...)
 		if projection := buildMongoProjection(c); projection != nil {
 			query = query.Select(projection)
 		}
@@ -534,12 +661,32 @@ func (s *mongoDBService) FindByMatch(c *Con, matchList []Match, result interface
 	}
 }
 
+func (s *mongoDBService) IterByMatch(c *Con, matchList []Match, batchSize int, prefixes ...string) (RowCursor, error) {
+	coll, e := getColl(c)
+	if e != nil {
+		return nil, e
+	}
+	query := coll.Find(c.Ctx, mapToBsonM(matchMongoCond(matchList), prefixes...)).Sort(sortMongoFields(c.Sort)...).BatchSize(int64(batchSize))
+	if projection := buildMongoProjection(c); projection != nil {
+		query = query.Select(projection)
+	}
+	cursor := query.Cursor()
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
 func (s *mongoDBService) GetByMatch(c *Con, matchList []Match, result interface{}) error {
 	condition := matchMongoCond(matchList)
 	if coll, e := getColl(c); e != nil {
 		return e
 	} else {
-		query := coll.Find(c.Ctx, mapToBsonM(condition)).Sort(sortMongoFields(c.Sort)...)
+		filter := mapToBsonM(condition)
+		if hasMongoText(filter) {
+			return getMongoText(c, coll, filter, result)
+		}
+		query := coll.Find(c.Ctx, filter).Sort(sortMongoFields(c.Sort)...)
 		if projection := buildMongoProjection(c); projection != nil {
 			query = query.Select(projection)
 		}
@@ -548,6 +695,28 @@ func (s *mongoDBService) GetByMatch(c *Con, matchList []Match, result interface{
 	}
 }
 
+func (s *mongoDBService) FindOneAndUpdate(c *Con, matchList []Match, data map[string]interface{}, returnNew bool, result interface{}) error {
+	coll, e := getColl(c)
+	if e != nil {
+		return e
+	}
+	update := mongoUpdate(data)
+	upsert := hasUpsert(data)
+	if upsert {
+		insert := update[string(OpSetOnInsert)].(bson.M)
+		insert["con.id"] = c.ID
+		if c.ID == 0 {
+			insert["con.id"] = c.GenerateID()
+		}
+		insert["options.createAt"] = time.Now()
+	}
+	query := coll.Find(c.Ctx, mapToBsonM(matchMongoCond(matchList))).Sort(sortMongoFields(c.Sort)...)
+	if projection := buildMongoProjection(c); projection != nil {
+		query = query.Select(projection)
+	}
+	return query.Apply(qmgo.Change{Update: update, Upsert: upsert, ReturnNew: returnNew}, result)
+}
+
 func (s *mongoDBService) CountByMatch(c *Con, matchList []Match) (int64, error) {
 	condition := matchMongoCond(matchList)
 	if coll, e := getColl(c); e != nil {
@@ -614,12 +783,19 @@ func (s *mongoDBService) FindByPageMatch(c *Con, matchList []Match, page *load.P
 		return e
 	} else {
 		condition := matchMongoCond(matchList)
+		if page.Cursor != "" {
+			return s.findByCursor(c, coll, mapToBsonM(condition, prefixes...), page, total, result)
+		}
 		var mErr error
 		var count int64
 		if page.LastID > 0 {
 			m := mapToBsonM(condition, prefixes...)
 			m["con.id"] = bson.M{"$lt": page.LastID}
 			count, _ = coll.Find(c.Ctx, m).Count()
+			if hasMongoText(m) {
+				total.Set(count)
+				return findMongoText(c, coll, m, 0, page.Size, result)
+			}
 			query := coll.Find(c.Ctx, m).Sort(sortMongoFields(c.Sort)...).Limit(page.Size)
 			if projection := buildMongoProjection(c); projection != nil {
 				query = query.Select(projection)
@@ -629,6 +805,10 @@ func (s *mongoDBService) FindByPageMatch(c *Con, matchList []Match, page *load.P
 			bsonM := mapToBsonM(condition, prefixes...)
 			count, _ = coll.Find(c.Ctx, bsonM).Count()
 			skip := (page.Page - 1) * page.Size
+			if hasMongoText(bsonM) {
+				total.Set(count)
+				return findMongoText(c, coll, bsonM, skip, page.Size, result)
+			}
 			query := coll.Find(c.Ctx, bsonM).Sort(sortMongoFields(c.Sort)...).Skip(skip).Limit(page.Size)
 			if projection := buildMongoProjection(c); projection != nil {
 				query = query.Select(projection)
@@ -642,3 +822,432 @@ func (s *mongoDBService) FindByPageMatch(c *Con, matchList []Match, page *load.P
 		return nil
 	}
 }
+
+func mongoFieldPath(field string) string {
+	if hasDef(field) {
+		return field
+	}
+	return preData.ad(field)
+}
+
+func mongoAggAccumulator(f AggField) bson.M {
+	switch f.Agg {
+	case AggCount:
+		return bson.M{"$sum": 1}
+	case AggCountDistinct:
+		return bson.M{"$addToSet": "$" + mongoFieldPath(f.Field)}
+	default:
+		return bson.M{"$" + string(f.Agg): "$" + mongoFieldPath(f.Field)}
+	}
+}
+
+// findByCursor loads the page after (or before) the cursor row with a keyset condition on the sort fields and con.id
+func (s *mongoDBService) findByCursor(c *Con, coll *qmgo.Collection, condition bson.M, page *load.Page, total *load.Total, result interface{}) error {
+	cur, cErr := DecodePageCursor(page.Cursor, c.Sort)
+	if cErr != nil {
+		return cErr
+	}
+	values, err := cur.SortValues()
+	if err != nil {
+		return err
+	}
+	count, err := coll.Find(c.Ctx, condition).Count()
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(c.Sort)+1)
+	sortList := make([]string, 0, len(c.Sort)+1)
+	for _, v := range c.Sort {
+		path := mongoSortPath(v)
+		paths = append(paths, path)
+		if v.Asc != cur.Prev {
+			sortList = append(sortList, path)
+		} else {
+			sortList = append(sortList, "-"+path)
+		}
+	}
+	paths = append(paths, "con.id")
+	values = append(values, cur.ID)
+	if cur.Prev {
+		sortList = append(sortList, "con.id")
+	} else {
+		sortList = append(sortList, "-con.id")
+	}
+
+	terms := keysetTerms(c.Sort, cur.Prev)
+	or := make([]bson.M, 0, len(terms))
+	for _, term := range terms {
+		m := bson.M{}
+		for _, j := range term.Eq {
+			m[paths[j]] = values[j]
+		}
+		op := "$lt"
+		if term.Gt {
+			op = "$gt"
+		}
+		m[paths[term.Index]] = bson.M{op: values[term.Index]}
+		or = append(or, m)
+	}
+	filter := bson.M{"$and": []bson.M{condition, {"$or": or}}}
+	query := coll.Find(c.Ctx, filter).Sort(sortList...).Limit(page.Size)
+	if projection := buildMongoProjection(c); projection != nil {
+		query = query.Select(projection)
+	}
+	if err = query.All(result); err != nil {
+		return err
+	}
+	if cur.Prev {
+		reverseSlice(result)
+	}
+	total.Set(count)
+	return nil
+}
+
+func (s *mongoDBService) CursorValues(c *Con, row interface{}) ([]interface{}, error) {
+	doc, err := toBsonDoc(row)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, 0, len(c.Sort))
+	for _, v := range c.Sort {
+		values = append(values, bsonLookup(doc, mongoSortPath(v)))
+	}
+	return values, nil
+}
+
+func (s *mongoDBService) AggregateByMatch(c *Con, matchList []Match, agg *Aggregation, total *load.Total, result *[]*AggItem) error {
+	if err := agg.Validate(); err != nil {
+		return err
+	}
+	coll, e := getColl(c)
+	if e != nil {
+		return e
+	}
+
+	groupID := bson.M{}
+	for _, g := range agg.GroupFields {
+		groupID[g] = "$" + mongoFieldPath(g)
+	}
+	group := bson.M{"_id": groupID}
+	project := bson.M{"_id": 0}
+	for _, g := range agg.GroupFields {
+		project[g] = "$_id." + g
+	}
+	for _, f := range agg.AggFields {
+		group[f.Alias] = mongoAggAccumulator(f)
+		if f.Agg == AggCountDistinct {
+			project[f.Alias] = bson.M{"$size": "$" + f.Alias}
+		} else {
+			project[f.Alias] = 1
+		}
+	}
+
+	pipeline := []bson.M{
+		{"$match": mapToBsonM(matchMongoCond(matchList))},
+		{"$group": group},
+		{"$project": project},
+	}
+	if having := matchMongoCond(agg.Having); having != nil {
+		pipeline = append(pipeline, bson.M{"$match": having})
+	}
+
+	sort := bson.D{}
+	for _, v := range agg.Sort {
+		order := -1
+		if v.Asc {
+			order = 1
+		}
+		sort = append(sort, bson.E{Key: v.Field, Value: order})
+	}
+	for _, g := range agg.GroupFields {
+		if sorted := sort.Map(); sorted[g] == nil {
+			sort = append(sort, bson.E{Key: g, Value: 1})
+		}
+	}
+	if len(sort) > 0 {
+		pipeline = append(pipeline, bson.M{"$sort": sort})
+	}
+
+	items := []bson.M{{"$limit": 10000}}
+	if agg.Page != nil {
+		items = []bson.M{{"$skip": agg.Page.Offset()}, {"$limit": agg.Page.Size}}
+	}
+	pipeline = append(pipeline, bson.M{"$facet": bson.M{
+		"total": []bson.M{{"$count": "n"}},
+		"items": items,
+	}})
+
+	var facets []struct {
+		Total []struct {
+			N int64 `bson:"n"`
+		} `bson:"total"`
+		Items []bson.M `bson:"items"`
+	}
+	if mErr := coll.Aggregate(c.Ctx, pipeline).All(&facets); mErr != nil {
+		return mErr
+	}
+
+	list := make([]*AggItem, 0)
+	var count int64
+	if len(facets) > 0 {
+		if len(facets[0].Total) > 0 {
+			count = facets[0].Total[0].N
+		}
+		for _, row := range facets[0].Items {
+			list = append(list, agg.toAggItem(row))
+		}
+	}
+	*result = list
+	total.Set(count)
+	return nil
+}
+
+// bsonLookup reads a dotted path from a decoded document
+func bsonLookup(doc interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		switch d := doc.(type) {
+		case bson.M:
+			doc = d[key]
+		case bson.D:
+			doc = d.Map()[key]
+		default:
+			return nil
+		}
+	}
+	return doc
+}
+
+func toBsonDoc(data interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err = bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// addBulkFailures records the write errors of a bulk write, indexes maps the model index to the input index
+func addBulkFailures(mErr error, indexes []int, ids []int64, result *BulkResult) (int, error) {
+	if mErr == nil {
+		return 0, nil
+	}
+	var bwe mongo.BulkWriteException
+	if !checkErr.As(mErr, &bwe) {
+		return 0, mErr
+	}
+	for _, we := range bwe.WriteErrors {
+		result.addFailure(indexes[we.Index], ids[we.Index], we)
+	}
+	if bwe.WriteConcernError != nil {
+		return len(bwe.WriteErrors), bwe.WriteConcernError
+	}
+	return len(bwe.WriteErrors), nil
+}
+
+func (s *mongoDBService) SaveMany(c *Con, dataList []Identifiable, batchSize int, result *BulkResult) error {
+	coll, e := getColl(c)
+	if e != nil {
+		return e
+	}
+	for _, chunk := range bulkChunks(len(dataList), batchSize) {
+		batch := dataList[chunk[0]:chunk[1]]
+		docs := make([]interface{}, 0, len(batch))
+		indexes := make([]int, 0, len(batch))
+		ids := make([]int64, 0, len(batch))
+		for i, data := range batch {
+			docs = append(docs, data)
+			indexes = append(indexes, chunk[0]+i)
+			ids = append(ids, data.GetID().Int64())
+		}
+		_, mErr := coll.InsertMany(c.Ctx, docs, qoptions.InsertManyOptions{InsertManyOptions: options.InsertMany().SetOrdered(false)})
+		failed, mErr := addBulkFailures(mErr, indexes, ids, result)
+		if mErr != nil {
+			return mErr
+		}
+		result.Inserted += int64(len(batch) - failed)
+	}
+	return nil
+}
+
+func (s *mongoDBService) UpsertBy(c *Con, dataList []Identifiable, fields []string, batchSize int, result *BulkResult) error {
+	coll, e := getColl(c)
+	if e != nil {
+		return e
+	}
+	mColl, err := coll.CloneCollection()
+	if err != nil {
+		return err
+	}
+	for _, chunk := range bulkChunks(len(dataList), batchSize) {
+		batch := dataList[chunk[0]:chunk[1]]
+		models := make([]mongo.WriteModel, 0, len(batch))
+		indexes := make([]int, 0, len(batch))
+		ids := make([]int64, 0, len(batch))
+		filters := make([]bson.M, 0, len(batch))
+		now := time.Now()
+		for i, data := range batch {
+			doc, err := toBsonDoc(data)
+			if err != nil {
+				result.addFailure(chunk[0]+i, data.GetID().Int64(), err)
+				continue
+			}
+			filter := bson.M{}
+			for _, f := range fields {
+				filter[mongoFieldPath(f)] = bsonLookup(doc, mongoFieldPath(f))
+			}
+			update := bson.M{
+				"$set":         bson.M{"data": doc["data"], "options.updateAt": now},
+				"$setOnInsert": bson.M{"con.id": data.GetID().Int64(), "options.createAt": now},
+				"$inc":         bson.M{"options.version": 1},
+			}
+			models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+			indexes = append(indexes, chunk[0]+i)
+			ids = append(ids, data.GetID().Int64())
+			filters = append(filters, filter)
+		}
+		if len(models) == 0 {
+			continue
+		}
+		res, mErr := mColl.BulkWrite(c.Ctx, models, options.BulkWrite().SetOrdered(false))
+		if _, mErr = addBulkFailures(mErr, indexes, ids, result); mErr != nil {
+			return mErr
+		}
+
+		// rows that already existed keep their stored ID instead of the generated one
+		matched := make([]bson.M, 0, len(models))
+		for i := range models {
+			if res != nil && res.UpsertedIDs[int64(i)] != nil {
+				result.Upserted++
+				continue
+			}
+			if !result.Failed(indexes[i]) {
+				matched = append(matched, filters[i])
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		var stored []bson.M
+		if mErr = coll.Find(c.Ctx, bson.M{"$or": matched}).All(&stored); mErr != nil {
+			return mErr
+		}
+		storedIDs := make(map[string]int64, len(stored))
+		for _, doc := range stored {
+			values := make([]interface{}, 0, len(fields))
+			for _, f := range fields {
+				values = append(values, bsonLookup(doc, mongoFieldPath(f)))
+			}
+			storedIDs[bulkKey(values)] = cast.ToInt64(bsonLookup(doc, "con.id"))
+		}
+		for i := range models {
+			if (res != nil && res.UpsertedIDs[int64(i)] != nil) || result.Failed(indexes[i]) {
+				continue
+			}
+			values := make([]interface{}, 0, len(fields))
+			for _, f := range fields {
+				values = append(values, filters[i][mongoFieldPath(f)])
+			}
+			if id, ok := storedIDs[bulkKey(values)]; ok {
+				dataList[indexes[i]].SetID(id)
+			}
+			result.Updated++
+		}
+	}
+	return nil
+}
+
+// BulkWrite runs the operations in the given order. The driver regroups an unordered bulk write by operation kind,
+// so every run of consecutive operations of the same kind is written as its own unordered bulk write.
+func (s *mongoDBService) BulkWrite(c *Con, ops []BulkOp, result *BulkResult) error {
+	coll, e := getColl(c)
+	if e != nil {
+		return e
+	}
+	mColl, err := coll.CloneCollection()
+	if err != nil {
+		return err
+	}
+	var (
+		models  []mongo.WriteModel
+		indexes []int
+		ids     []int64
+		kind    string
+	)
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		res, mErr := mColl.BulkWrite(c.Ctx, models, options.BulkWrite().SetOrdered(false))
+		if _, mErr = addBulkFailures(mErr, indexes, ids, result); mErr != nil {
+			return mErr
+		}
+		if res != nil {
+			result.Inserted += res.InsertedCount
+			result.Updated += res.ModifiedCount
+			result.Deleted += res.DeletedCount
+		}
+		models, indexes, ids = nil, nil, nil
+		return nil
+	}
+	for i, op := range ops {
+		if result.Failed(i) {
+			continue
+		}
+		filter := mapToBsonM(matchMongoCond(op.Matches))
+		if op.ID > 0 {
+			filter["con.id"] = op.ID
+		}
+		var model mongo.WriteModel
+		switch op.Type {
+		case BulkInsert:
+			model = mongo.NewInsertOneModel().SetDocument(op.Data)
+		case BulkUpdate:
+			update := mongoUpdate(op.Update)
+			if op.ID > 0 {
+				model = mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update)
+			} else {
+				model = mongo.NewUpdateManyModel().SetFilter(filter).SetUpdate(update)
+			}
+		case BulkDelete:
+			if op.ID > 0 {
+				model = mongo.NewDeleteOneModel().SetFilter(filter)
+			} else {
+				model = mongo.NewDeleteManyModel().SetFilter(filter)
+			}
+		}
+		if k := fmt.Sprintf("%T", model); k != kind {
+			if err = flush(); err != nil {
+				return err
+			}
+			kind = k
+		}
+		models = append(models, model)
+		indexes = append(indexes, i)
+		ids = append(ids, op.id())
+	}
+	return flush()
+}
+
+func (s *mongoDBService) Tables(c *Con, prefix string) ([]string, error) {
+	coll, e := getColl(c)
+	if e != nil {
+		return nil, e
+	}
+	mColl, err := coll.CloneCollection()
+	if err != nil {
+		return nil, err
+	}
+	return mColl.Database().ListCollectionNames(c.Ctx, bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}})
+}
+
+func (s *mongoDBService) DropTable(c *Con, table string) error {
+	gDdb, e := ddbCon(c.MongoDB, c.DBName)
+	if e != nil {
+		return e
+	}
+	return gDdb.Collection(table).DropCollection(c.Ctx)
+}
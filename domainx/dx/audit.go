@@ -0,0 +1,126 @@
+package dx
+
+import (
+	"github.com/jom-io/gorig/apix/load"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"go.uber.org/zap"
+	"slices"
+)
+
+// Audited is implemented by tables whose writes are recorded in the audit trail,
+// register the audit table with domainx.AutoMigrateAudit
+type Audited interface {
+	DAudit() bool
+}
+
+func (d *dx[T]) isAudited() bool {
+	a, ok := any(new(T)).(Audited)
+	return ok && a.DAudit()
+}
+
+// auditBefore snapshots the records the write will touch, by ID or by matches
+func (d *dx[T]) auditBefore() map[int64]map[string]interface{} {
+	con := d.complex.Con
+	befores := make(map[int64]map[string]interface{})
+	if !d.IsZero() {
+		item := d.complex.Derive(new(T))
+		if err := domainx.GetByID(con, d.GetID().Int64(), item); err != nil || item.IsNil() {
+			return befores
+		}
+		befores[item.GetID().Int64()] = domainx.AuditSnapshot(con, item.Data)
+	} else if d.matches != nil && len(*d.matches) > 0 {
+		if err := d.eachMatched(func(item *domainx.Complex[T]) *errors.Error {
+			befores[item.GetID().Int64()] = domainx.AuditSnapshot(con, item.Data)
+			return nil
+		}); err != nil {
+			logger.Warn(d.ctx, "audit snapshot failed", zap.String("table", con.TableName()), zap.Error(err))
+		}
+	}
+	return befores
+}
+
+func (d *dx[T]) saveAudit(records []*domainx.AuditRecord) {
+	if err := domainx.SaveAudit(d.complex.Con, records); err != nil {
+		logger.Error(d.ctx, "audit write failed", zap.String("table", d.complex.TableName()), zap.Error(err))
+	}
+}
+
+// auditSaved records the insert or the update of Save, before is nil for an insert
+func (d *dx[T]) auditSaved(id int64, before map[string]interface{}) {
+	op := domainx.AuditUpdate
+	if before == nil {
+		op = domainx.AuditCreate
+	}
+	diff := domainx.AuditDiff(before, domainx.AuditSnapshot(d.complex.Con, d.complex.Data))
+	if len(diff) > 0 || op == domainx.AuditCreate {
+		d.saveAudit([]*domainx.AuditRecord{domainx.NewAuditRecord(d.ctx, d.complex.TableName(), id, op, diff)})
+	}
+}
+
+// auditWritten records an update (update is the applied map) or a delete (update is nil) of the records in befores
+func (d *dx[T]) auditWritten(op domainx.AuditOp, befores map[int64]map[string]interface{}, update map[string]interface{}) {
+	var reloaded map[int64]map[string]interface{}
+	if op == domainx.AuditUpdate && hasUpdateExpr(update) {
+		// the operators are evaluated by the database, the records are read again instead of applying the map
+		reloaded = d.auditReload(befores)
+	}
+	records := make([]*domainx.AuditRecord, 0, len(befores))
+	for id, before := range befores {
+		var after map[string]interface{}
+		if reloaded != nil {
+			var ok bool
+			if after, ok = reloaded[id]; !ok {
+				continue
+			}
+		} else if op == domainx.AuditUpdate {
+			after = domainx.AuditApply(before, update)
+		}
+		diff := domainx.AuditDiff(before, after)
+		if len(diff) == 0 && op == domainx.AuditUpdate {
+			continue
+		}
+		records = append(records, domainx.NewAuditRecord(d.ctx, d.complex.TableName(), id, op, diff))
+	}
+	d.saveAudit(records)
+}
+
+func hasUpdateExpr(update map[string]interface{}) bool {
+	for _, v := range update {
+		if _, ok := v.(domainx.UpdateExpr); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// auditReload snapshots the records of befores from the primary after the write
+func (d *dx[T]) auditReload(befores map[int64]map[string]interface{}) map[int64]map[string]interface{} {
+	con := *d.complex.Con
+	con.ReadRoute = domainx.RoutePrimary
+	ids := make([]int64, 0, len(befores))
+	for id := range befores {
+		ids = append(ids, id)
+	}
+	afters := make(map[int64]map[string]interface{}, len(ids))
+	for chunk := range slices.Chunk(ids, 1000) {
+		var rows []*domainx.Complex[T]
+		if err := domainx.FindByIDs(&con, chunk, &rows); err != nil {
+			logger.Warn(d.ctx, "audit snapshot failed", zap.String("table", con.TableName()), zap.Error(err))
+			return afters
+		}
+		for _, row := range rows {
+			afters[row.GetID().Int64()] = domainx.AuditSnapshot(&con, row.Data)
+		}
+	}
+	return afters
+}
+
+func (d *dx[T]) History(page, size int64) (*load.PageRespT[*domainx.Complex[domainx.AuditRecord]], *errors.Error) {
+	if d.IsZero() {
+		return nil, errors.Sys("id is zero")
+	}
+	con := d.complex.Con
+	return domainx.AuditHistory(d.ctx, con.GetConType(), con.DBName, con.TableName(), d.GetID().Int64(), load.BuildPage(d.ctx, page, size, 0))
+}
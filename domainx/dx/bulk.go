@@ -0,0 +1,118 @@
+package dx
+
+import (
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/utils/errors"
+)
+
+// BulkOp is one operation of DQuery.BulkWrite, build it with BulkInsert, BulkUpdate or BulkDelete
+type BulkOp[T any] struct {
+	Type    domainx.BulkOpType
+	Data    *T
+	ID      int64
+	Matches *domainx.Matches
+	Update  map[string]interface{}
+}
+
+func BulkInsert[T any](data *T) BulkOp[T] {
+	return BulkOp[T]{Type: domainx.BulkInsert, Data: data}
+}
+
+// BulkUpdate updates the record by id, or the records matched by matches when id is 0
+func BulkUpdate[T any](id int64, matches *domainx.Matches, data map[string]interface{}) BulkOp[T] {
+	return BulkOp[T]{Type: domainx.BulkUpdate, ID: id, Matches: matches, Update: data}
+}
+
+// BulkDelete deletes the record by id, or the records matched by matches when id is 0
+func BulkDelete[T any](id int64, matches *domainx.Matches) BulkOp[T] {
+	return BulkOp[T]{Type: domainx.BulkDelete, ID: id, Matches: matches}
+}
+
+func (d *dx[T]) derive(list []*T) []domainx.Identifiable {
+	dataList := make([]domainx.Identifiable, 0, len(list))
+	for _, t := range list {
+		dataList = append(dataList, d.complex.Derive(t))
+	}
+	return dataList
+}
+
+func (d *dx[T]) SaveMany(list []*T, batchSize ...int) (*domainx.BulkResult, *errors.Error) {
+	return d.saveList(list, func() (*domainx.BulkResult, *errors.Error) {
+		return domainx.SaveMany(d.complex.Con, d.derive(list), batchSize...)
+	})
+}
+
+func (d *dx[T]) UpsertBy(list []*T, fields ...string) (*domainx.BulkResult, *errors.Error) {
+	return d.saveList(list, func() (*domainx.BulkResult, *errors.Error) {
+		return domainx.UpsertBy(d.complex.Con, d.derive(list), fields)
+	})
+}
+
+// saveList runs a bulk save with the save hooks and the encryption of the records, AfterSave skips the failed rows
+func (d *dx[T]) saveList(list []*T, save func() (*domainx.BulkResult, *errors.Error)) (*domainx.BulkResult, *errors.Error) {
+	if err := d.beforeSave(list...); err != nil {
+		return nil, err
+	}
+	if err := d.encrypt(list...); err != nil {
+		return nil, err
+	}
+	result, err := save()
+	d.decrypt(list...)
+	domainx.AfterCommit(d.ctx, d.invalidate)
+	if err != nil {
+		return result, err
+	}
+	for i, t := range list {
+		if result.Failed(i) {
+			continue
+		}
+		if err = d.afterSave(t); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (d *dx[T]) BulkWrite(ops ...BulkOp[T]) (*domainx.BulkResult, *errors.Error) {
+	bulkOps := make([]domainx.BulkOp, 0, len(ops))
+	encrypted := make([]*T, 0, len(ops))
+	for _, op := range ops {
+		update, err := d.encryptUpdate(op.Update)
+		if err != nil {
+			d.decrypt(encrypted...)
+			return nil, err
+		}
+		bulkOp := domainx.BulkOp{Type: op.Type, ID: op.ID, Update: update}
+		if op.Data != nil {
+			if err = d.beforeSave(op.Data); err != nil {
+				d.decrypt(encrypted...)
+				return nil, err
+			}
+			encrypted = append(encrypted, op.Data)
+			if err = d.encrypt(op.Data); err != nil {
+				d.decrypt(encrypted...)
+				return nil, err
+			}
+			bulkOp.Data = d.complex.Derive(op.Data)
+		}
+		if op.Matches != nil {
+			bulkOp.Matches = *op.Matches
+		}
+		bulkOps = append(bulkOps, bulkOp)
+	}
+	result, err := domainx.BulkWrite(d.complex.Con, bulkOps)
+	d.decrypt(encrypted...)
+	domainx.AfterCommit(d.ctx, d.invalidate)
+	if err != nil {
+		return result, err
+	}
+	for i, op := range ops {
+		if op.Data == nil || result.Failed(i) {
+			continue
+		}
+		if err = d.afterSave(op.Data); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
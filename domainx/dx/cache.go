@@ -0,0 +1,239 @@
+package dx
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	checkErr "errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/jom-io/gorig/apix/load"
+	"github.com/jom-io/gorig/cache"
+	"github.com/jom-io/gorig/domainx"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Query results are cached under the generation of their table, a write through dx bumps the generation
+// so every cached query of the table misses afterwards. The generation lives in redis when ${ redis.addr }
+// is set, so writes of other instances invalidate too, otherwise in memory of this instance.
+var (
+	queryCache     *cache.Tool[string]
+	queryCacheOnce sync.Once
+	generations    sync.Map // scope -> *atomic.Int64 when redis is not configured
+)
+
+// cachedRow is the stored form of a record, the Con of a Complex holds the connections and is rebuilt on read
+type cachedRow[T any] struct {
+	ID      int64
+	Data    *T
+	Options domainx.Options
+}
+
+type cachedPage[T any] struct {
+	Page       int64
+	Size       int64
+	Total      int64
+	LastID     int64
+	NextCursor string
+	PrevCursor string
+	Rows       []cachedRow[T]
+}
+
+func queryCacheTool() *cache.Tool[string] {
+	queryCacheOnce.Do(func() {
+		caches := []cache.Cache[string]{cache.NewGoCache[string](time.Minute, time.Minute)}
+		if rc := generationRedis(); rc != nil {
+			caches = append(caches, rc)
+		}
+		queryCache = cache.NewCacheTool[string](context.Background(), caches, nil)
+	})
+	return queryCache
+}
+
+func generationRedis() *cache.RedisCache[string] {
+	if configure.GetString("redis.addr") == "" {
+		return nil
+	}
+	rc := cache.GetRedisInstance[string](context.Background())
+	if rc == nil || !rc.IsInitialized() {
+		return nil
+	}
+	return rc
+}
+
+func (d *dx[T]) Cached(ttl time.Duration) DQuery[T] {
+	d.cacheTTL = ttl
+	return d
+}
+
+// scope identifies the table of d among all databases
+func (d *dx[T]) scope() string {
+	con := d.complex.Con
+	return fmt.Sprintf("%s:%s:%s", con.GetConType(), con.DBName, con.TableName())
+}
+
+// generation returns the current generation of the table, false if it cannot be read and the cache must be bypassed
+func (d *dx[T]) generation() (int64, bool) {
+	scope := d.scope()
+	if rc := generationRedis(); rc != nil {
+		gen, err := rc.Client.Get(context.Background(), "dx:gen:"+scope).Int64()
+		if err != nil && !checkErr.Is(err, redis.Nil) {
+			logger.Warn(d.ctx, "dx cache generation read failed", zap.String("table", scope), zap.Error(err))
+			return 0, false
+		}
+		return gen, true
+	}
+	v, _ := generations.LoadOrStore(scope, new(atomic.Int64))
+	return v.(*atomic.Int64).Load(), true
+}
+
+// invalidate drops the cached queries of the table, called after every write through dx, after the commit in a Transaction
+func (d *dx[T]) invalidate() {
+	scope := d.scope()
+	if rc := generationRedis(); rc != nil {
+		if err := rc.Client.Incr(context.Background(), "dx:gen:"+scope).Err(); err != nil {
+			logger.Error(d.ctx, "dx cache invalidate failed", zap.String("table", scope), zap.Error(err))
+		}
+		return
+	}
+	v, _ := generations.LoadOrStore(scope, new(atomic.Int64))
+	v.(*atomic.Int64).Add(1)
+}
+
+// cacheKey hashes everything that shapes the result of the query: id, matches, sort, select, omit and op arguments
+func (d *dx[T]) cacheKey(gen int64, op string, args ...any) (string, bool) {
+	con := d.complex.Con
+	b, err := json.Marshal(struct {
+		Op      string
+		Tenant  string
+		ID      int64
+		Matches *domainx.Matches
+		Sort    domainx.Sorts
+		Select  []string
+		Omit    []string
+		Args    []any
+	}{op, con.ScopedTenantID(), con.ID, d.matches, con.Sort, con.SelectFields, con.OmitFields, args})
+	if err != nil {
+		logger.Warn(d.ctx, "dx cache key failed", zap.String("table", d.scope()), zap.Error(err))
+		return "", false
+	}
+	sum := sha1.Sum(b)
+	return fmt.Sprintf("dx:q:%s:%d:%s", d.scope(), gen, hex.EncodeToString(sum[:])), true
+}
+
+// cached returns the cached result of the query or loads and caches it, without Cached it only loads
+func cached[T any, R any](d *dx[T], op string, loader func() (R, *errors.Error), args ...any) (R, *errors.Error) {
+	con := d.complex.Con
+	// inside a transaction uncommitted rows must not be cached, and the generation only moves after the commit
+	if d.cacheTTL <= 0 || domainx.InTransaction(d.ctx, con.GetConType(), con.DBName) {
+		return loader()
+	}
+	gen, ok := d.generation()
+	if !ok {
+		return loader()
+	}
+	key, ok := d.cacheKey(gen, op, args...)
+	if !ok {
+		return loader()
+	}
+	tool := queryCacheTool()
+	if s, err := tool.Get(key, d.cacheTTL); err == nil {
+		var r R
+		if err = decodeCached(s, &r); err == nil {
+			return r, nil
+		}
+		logger.Warn(d.ctx, "dx cache decode failed", zap.String("key", key), zap.Error(err))
+	}
+	r, err := loader()
+	if err != nil {
+		return r, err
+	}
+	s, eErr := encodeCached(r)
+	if eErr != nil {
+		logger.Warn(d.ctx, "dx cache encode failed", zap.String("key", key), zap.Error(eErr))
+		return r, nil
+	}
+	if sErr := tool.Set(key, s, d.cacheTTL); sErr != nil {
+		logger.Warn(d.ctx, "dx cache set failed", zap.String("key", key), zap.Error(sErr))
+	}
+	return r, nil
+}
+
+// encodeCached uses gob, fields hidden from json are kept
+func encodeCached(v any) (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeCached(s string, v any) error {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+func toCachedRow[T any](c *domainx.Complex[T]) cachedRow[T] {
+	return cachedRow[T]{ID: c.GetID().Int64(), Data: c.Data, Options: c.Options}
+}
+
+func (d *dx[T]) fromCachedRow(row cachedRow[T]) *domainx.Complex[T] {
+	c := d.complex.Derive(row.Data)
+	c.SetID(row.ID)
+	c.Options = row.Options
+	return c
+}
+
+func (d *dx[T]) fromCachedRows(rows []cachedRow[T]) []*domainx.Complex[T] {
+	list := make([]*domainx.Complex[T], 0, len(rows))
+	for _, row := range rows {
+		list = append(list, d.fromCachedRow(row))
+	}
+	return list
+}
+
+func toCachedRows[T any](list []*domainx.Complex[T]) []cachedRow[T] {
+	rows := make([]cachedRow[T], 0, len(list))
+	for _, c := range list {
+		rows = append(rows, toCachedRow(c))
+	}
+	return rows
+}
+
+func toCachedPage[T any](resp *load.PageRespT[*domainx.Complex[T]]) cachedPage[T] {
+	p := cachedPage[T]{Page: resp.Page, Size: resp.Size, LastID: resp.LastID, NextCursor: resp.NextCursor, PrevCursor: resp.PrevCursor}
+	if resp.Total != nil {
+		p.Total = int64(*resp.Total)
+	}
+	if resp.Result != nil {
+		p.Rows = toCachedRows(*resp.Result)
+	}
+	return p
+}
+
+func (d *dx[T]) fromCachedPage(p cachedPage[T]) *load.PageRespT[*domainx.Complex[T]] {
+	total := load.Total(p.Total)
+	result := d.fromCachedRows(p.Rows)
+	return &load.PageRespT[*domainx.Complex[T]]{
+		Page:       p.Page,
+		Size:       p.Size,
+		Total:      &total,
+		LastID:     p.LastID,
+		NextCursor: p.NextCursor,
+		PrevCursor: p.PrevCursor,
+		Result:     &result,
+	}
+}
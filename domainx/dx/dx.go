@@ -5,6 +5,9 @@ import (
 	"github.com/jom-io/gorig/apix/load"
 	"github.com/jom-io/gorig/domainx"
 	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/geo"
+	"iter"
+	"time"
 )
 
 type (
@@ -12,6 +15,10 @@ type (
 		ctx     context.Context
 		complex *domainx.Complex[T]
 		matches *domainx.Matches
+		// cacheTTL > 0 caches the results of Get, Find, Count, Exists and Page, see Cached
+		cacheTTL time.Duration
+		// preloads are the relations attached to the results of Get, Find and Page, see Preload
+		preloads []string
 	}
 
 	DTable interface {
@@ -46,28 +53,71 @@ type (
 		HasAny(field string, value interface{}, ignore ...bool) DQuery[T]
 		HasAll(field string, value interface{}, ignore ...bool) DQuery[T]
 		NEmpty(field string) DQuery[T]
+		// Search matches query on the full-text index of fields, the results are sorted by relevance, see domainx.MText
+		Search(fields []string, query string) DQuery[T]
 		Near(latField, lngField string, lat, lng, distance float64) DQuery[T]
 		NearLoc(localField string, lat, lng, distance float64) DQuery[T]
+		// WithinPolygon, WithinBox and Intersects match a location field against a shape, see domainx.Matches.WithinPolygon
+		WithinPolygon(field string, polygon []geo.Point) DQuery[T]
+		WithinBox(field string, box geo.Box) DQuery[T]
+		Intersects(field string, shape ...geo.Point) DQuery[T]
 		AddMatch(m *domainx.Match) DQuery[T]
 		AddMatches(ms *domainx.Matches) DQuery[T]
 		Sort(field string, asc ...bool) DQuery[T]
 		Select(fields ...string) DQuery[T]
 		Omit(fields ...string) DQuery[T]
+		// WithVersion only writes Save/Update/Updates if the stored version still equals version, otherwise a conflict error is returned
+		WithVersion(version int64) DQuery[T]
+		// Cached caches the results of Get, Find, Count, Exists and Page for ttl,
+		// writes through dx to the same table invalidate them, reads inside a transaction bypass the cache
+		Cached(ttl time.Duration) DQuery[T]
+		// Preload attaches the related records of the relations declared by Related to the results of Get, Find and Page,
+		// read them with One and Many
+		Preload(names ...string) DQuery[T]
+		// Reencrypt rewrites the dx:"encrypt" fields of the matched records with the current key, after a key rotation
+		Reencrypt() (int64, *errors.Error)
+		// Unscoped skips the tenant scope of a Tenanted table, for admin jobs
+		Unscoped() DQuery[T]
+		// Primary reads from the primary, e.g. right after a write
+		Primary() DQuery[T]
+		// Replica reads from the named replica, or the default replicas without a name, e.g. for heavy reports
+		Replica(name ...string) DQuery[T]
 
 		Save(t ...*T) (id int64, err *errors.Error)
+		// SaveMany inserts the list in batches, failed rows are reported in BulkResult.Failures
+		SaveMany(list []*T, batchSize ...int) (*domainx.BulkResult, *errors.Error)
+		// UpsertBy inserts the list or updates the records matched by fields (INSERT ... ON DUPLICATE KEY UPDATE / mongo upsert)
+		UpsertBy(list []*T, fields ...string) (*domainx.BulkResult, *errors.Error)
+		BulkWrite(ops ...BulkOp[T]) (*domainx.BulkResult, *errors.Error)
+		// Emit writes an event of the record into the outbox, see Transaction and the domainx/outbox relay
+		Emit(topic string, content any) *errors.Error
 		checkMatches() *errors.Error
 		Update(field string, value any) *errors.Error
+		// Updates sets the fields of data, a value built with Inc, Push, AddToSet, Pull, Min, Max or Unset is applied atomically,
+		// e.g. Updates(dx.Inc("views", 1).Push("tags", "go"))
 		Updates(data map[string]interface{}) *errors.Error
+		// FindOneAndUpdate updates the record, or the first matched record in the sort order, and returns it as it was before
+		// the update or after it with returnNew, nil data if nothing matched. A SetOnInsert inserts the record if none matches.
+		FindOneAndUpdate(data map[string]interface{}, returnNew bool) (*domainx.Complex[T], *errors.Error)
 		Delete() *errors.Error
 		First() (*domainx.Complex[T], *errors.Error)
 		Get() (*domainx.Complex[T], *errors.Error)
 		Find() (domainx.ComplexList[T], *errors.Error)
 		FindEach(handle func(*domainx.Complex[T]) *errors.Error) *errors.Error
 		AllEach(handle func(*domainx.Complex[T]) *errors.Error) *errors.Error
+		// Iter streams the matched records on a database cursor, all records when no match is set,
+		// batchSize is the mongo cursor batch size and defaults to ${ domainx.iter.batch }, mysql ignores it
+		Iter(batchSize ...int) iter.Seq2[*domainx.Complex[T], error]
 		Count() (int64, *errors.Error)
 		Exists() (bool, *errors.Error)
 		Sum(field string) (float64, *errors.Error)
 		Page(page, size int64, lastID ...int64) (*load.PageRespT[*domainx.Complex[T]], *errors.Error)
+		// PageCursor loads the page at a nextCursor/prevCursor of a previous page, an empty cursor loads the first page
+		PageCursor(cursor string, size int64) (*load.PageRespT[*domainx.Complex[T]], *errors.Error)
+		// Aggregate groups the matched records, e.g. domainx.NewAggregation("status").Count("cnt").Sum("amount", "total")
+		Aggregate(agg *domainx.Aggregation) (*load.PageRespT[*domainx.AggItem], *errors.Error)
+		// History pages the audit records of the record by ID, newest first, see Audited
+		History(page, size int64) (*load.PageRespT[*domainx.Complex[domainx.AuditRecord]], *errors.Error)
 	}
 )
 
@@ -85,15 +135,36 @@ func On[T any, PT interface {
 	ptr := PT(&inst)
 
 	conType, dbName, TableName := ptr.DConfig()
+	dbName, prefix := tenantRoute(ctx, ptr, dbName)
+	c := domainx.CreateComplex(ctx, conType, dbName, TableName, &inst, prefix...)
+	if tenanted, ok := any(ptr).(Tenanted); ok && c.Con != nil {
+		c.Con.SetTenantField(tenanted.DTenant())
+	}
+	if partitioned, ok := any(ptr).(Partitioned); ok && c.Con != nil {
+		p := partitioned.DPartition()
+		c.Con.Partition = &p
+	}
 	return &dx[T]{
 		ctx:     ctx,
-		complex: domainx.CreateComplex(ctx, conType, dbName, TableName, &inst),
+		complex: c,
 		matches: domainx.NewMatches(),
 	}
 }
 
+// Transaction runs fn in a transaction of the database of T, pass the ctx of fn to On to join it
+func Transaction[T any, PT interface {
+	*T
+	DTable
+}](ctx context.Context, fn func(ctx context.Context) *errors.Error) *errors.Error {
+	conType, dbName, _ := PT(new(T)).DConfig()
+	return domainx.Transaction(ctx, conType, dbName, fn)
+}
+
 func (d *dx[T]) WithContext(ctx context.Context) DQuery[T] {
 	d.ctx = ctx
+	if ctx != nil && d.complex != nil && d.complex.Con != nil {
+		d.complex.Con.Ctx = ctx
+	}
 	return d
 }
 
@@ -123,7 +194,7 @@ func (d *dx[T]) WithID(id int64) DQuery[T] {
 }
 
 func (d *dx[T]) GenerateID() DQuery[T] {
-	d.complex.Con.GenerateID()
+	d.complex.Con.GenerateSetID()
 	return d
 }
 
@@ -136,12 +207,18 @@ func (d *dx[T]) IsZero() bool {
 }
 
 func (d *dx[T]) Eq(field string, value interface{}, ignore ...bool) DQuery[T] {
-	d.matches.Eq(field, value, ignore...)
+	field, value, ok := d.blind(field, value)
+	if ok {
+		d.matches.Eq(field, value, ignore...)
+	}
 	return d
 }
 
 func (d *dx[T]) Ne(field string, value interface{}, ignore ...bool) DQuery[T] {
-	d.matches.Ne(field, value, ignore...)
+	field, value, ok := d.blind(field, value)
+	if ok {
+		d.matches.Ne(field, value, ignore...)
+	}
 	return d
 }
 
@@ -171,12 +248,18 @@ func (d *dx[T]) Like(field string, value string, ignore ...bool) DQuery[T] {
 }
 
 func (d *dx[T]) In(field string, value interface{}, ignore ...bool) DQuery[T] {
-	d.matches.In(field, value, ignore...)
+	field, value, ok := d.blind(field, value)
+	if ok {
+		d.matches.In(field, value, ignore...)
+	}
 	return d
 }
 
 func (d *dx[T]) NotIn(field string, value interface{}, ignore ...bool) DQuery[T] {
-	d.matches.NotIn(field, value, ignore...)
+	field, value, ok := d.blind(field, value)
+	if ok {
+		d.matches.NotIn(field, value, ignore...)
+	}
 	return d
 }
 
@@ -195,6 +278,11 @@ func (d *dx[T]) HasAll(field string, value interface{}, ignore ...bool) DQuery[T
 	return d
 }
 
+func (d *dx[T]) Search(fields []string, query string) DQuery[T] {
+	d.matches.Text(query, fields...)
+	return d
+}
+
 func (d *dx[T]) NEmpty(field string) DQuery[T] {
 	d.matches.NEmpty(field)
 	return d
@@ -205,6 +293,21 @@ func (d *dx[T]) Near(latField, lngField string, lat, lng, distance float64) DQue
 	return d
 }
 
+func (d *dx[T]) WithinPolygon(field string, polygon []geo.Point) DQuery[T] {
+	d.matches.WithinPolygon(field, polygon)
+	return d
+}
+
+func (d *dx[T]) WithinBox(field string, box geo.Box) DQuery[T] {
+	d.matches.WithinBox(field, box)
+	return d
+}
+
+func (d *dx[T]) Intersects(field string, shape ...geo.Point) DQuery[T] {
+	d.matches.Intersects(field, shape...)
+	return d
+}
+
 func (d *dx[T]) NearLoc(localField string, lat, lng, distance float64) DQuery[T] {
 	d.matches.NearLoc(localField, lat, lng, distance)
 	return d
@@ -242,11 +345,54 @@ func (d *dx[T]) Omit(fields ...string) DQuery[T] {
 	return d
 }
 
+func (d *dx[T]) Primary() DQuery[T] {
+	if d.complex != nil && d.complex.Con != nil {
+		d.complex.Con.SetReadRoute(domainx.RoutePrimary)
+	}
+	return d
+}
+
+func (d *dx[T]) Replica(name ...string) DQuery[T] {
+	if d.complex != nil && d.complex.Con != nil {
+		route := domainx.RouteReplica
+		if len(name) > 0 && name[0] != "" {
+			route = name[0]
+		}
+		d.complex.Con.SetReadRoute(route)
+	}
+	return d
+}
+
+func (d *dx[T]) WithVersion(version int64) DQuery[T] {
+	if d.complex != nil && d.complex.Con != nil {
+		d.complex.Con.SetExpectVersion(version)
+		d.complex.SetVersion(version)
+	}
+	return d
+}
+
 func (d *dx[T]) Save(t ...*T) (id int64, err *errors.Error) {
 	if len(t) > 0 && any(t[0]) != nil {
 		d.complex.Data = t[0]
 	}
-	return domainx.Save(d.complex.Con, d.complex, 0)
+	if err := d.beforeSave(d.complex.Data); err != nil {
+		return 0, err
+	}
+	if err := d.encrypt(d.complex.Data); err != nil {
+		return 0, err
+	}
+	id, err = d.save(func() (int64, *errors.Error) {
+		return domainx.Save(d.complex.Con, d.complex, 0)
+	})
+	d.decrypt(d.complex.Data)
+	if err != nil {
+		return id, err
+	}
+	return id, d.afterSave(d.complex.Data)
+}
+
+func (d *dx[T]) Emit(topic string, content any) *errors.Error {
+	return domainx.Emit(d.complex.Con, topic, content)
 }
 
 func (d *dx[T]) checkMatches() *errors.Error {
@@ -266,39 +412,69 @@ func (d *dx[T]) Update(field string, value any) *errors.Error {
 	if value == nil {
 		return errors.Sys("value cannot be nil")
 	}
+	data, err := d.encryptUpdate(map[string]interface{}{field: value})
+	if err != nil {
+		return err
+	}
 	if !d.IsZero() {
-		return domainx.UpdatePart(d.complex.Con, d.GetID().Int64(), map[string]interface{}{field: value})
+		return d.write(domainx.AuditUpdate, data, func() *errors.Error {
+			return domainx.UpdatePart(d.complex.Con, d.GetID().Int64(), data)
+		})
 	}
 
 	if err := d.checkMatches(); err != nil {
 		return err
 	}
-	return domainx.UpdateByMatch(d.complex.Con, *d.matches, map[string]interface{}{field: value})
+	return d.write(domainx.AuditUpdate, data, func() *errors.Error {
+		return domainx.UpdateByMatch(d.complex.Con, *d.matches, data)
+	})
 }
 
 func (d *dx[T]) Updates(data map[string]interface{}) *errors.Error {
 	if len(data) == 0 {
 		return errors.Sys("data map cannot be empty")
 	}
+	data, err := d.encryptUpdate(data)
+	if err != nil {
+		return err
+	}
 	if !d.IsZero() {
-		return domainx.UpdatePart(d.complex.Con, d.GetID().Int64(), data)
+		return d.write(domainx.AuditUpdate, data, func() *errors.Error {
+			return domainx.UpdatePart(d.complex.Con, d.GetID().Int64(), data)
+		})
 	}
 
 	if err := d.checkMatches(); err != nil {
 		return err
 	}
-	return domainx.UpdateByMatch(d.complex.Con, *d.matches, data)
+	return d.write(domainx.AuditUpdate, data, func() *errors.Error {
+		return domainx.UpdateByMatch(d.complex.Con, *d.matches, data)
+	})
 }
 
 func (d *dx[T]) Delete() *errors.Error {
+	if d.IsZero() {
+		if err := d.checkMatches(); err != nil {
+			return err
+		}
+	}
+	rows, err := d.beforeDelete()
+	if err != nil {
+		return err
+	}
 	if !d.IsZero() {
-		return domainx.Delete(d.complex.Con, d)
+		err = d.write(domainx.AuditDelete, nil, func() *errors.Error {
+			return domainx.Delete(d.complex.Con, d)
+		})
+	} else {
+		err = d.write(domainx.AuditDelete, nil, func() *errors.Error {
+			return domainx.DeleteByMatch(d.complex.Con, *d.matches)
+		})
 	}
-
-	if err := d.checkMatches(); err != nil {
+	if err != nil {
 		return err
 	}
-	return domainx.DeleteByMatch(d.complex.Con, *d.matches)
+	return d.afterDelete(rows)
 }
 
 func (d *dx[T]) First() (*domainx.Complex[T], *errors.Error) {
@@ -306,6 +482,30 @@ func (d *dx[T]) First() (*domainx.Complex[T], *errors.Error) {
 }
 
 func (d *dx[T]) Get() (*domainx.Complex[T], *errors.Error) {
+	if d.cacheTTL <= 0 {
+		c, err := d.get()
+		if err != nil {
+			return nil, err
+		}
+		return d.loaded(c)
+	}
+	row, err := cached(d, "get", func() (cachedRow[T], *errors.Error) {
+		c, err := d.get()
+		if err != nil {
+			return cachedRow[T]{}, err
+		}
+		return toCachedRow(c), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.complex.Data = row.Data
+	d.complex.SetID(row.ID)
+	d.complex.Options = row.Options
+	return d.loaded(d.complex)
+}
+
+func (d *dx[T]) get() (*domainx.Complex[T], *errors.Error) {
 	if !d.IsZero() {
 		if err := domainx.GetByID(d.complex.Con, d.GetID().Int64(), d.complex); err != nil {
 			return nil, err
@@ -326,6 +526,24 @@ func (d *dx[T]) Find() (domainx.ComplexList[T], *errors.Error) {
 	if err := d.checkMatches(); err != nil {
 		return nil, err
 	}
+	if d.cacheTTL > 0 {
+		rows, err := cached(d, "find", func() ([]cachedRow[T], *errors.Error) {
+			result, err := d.find()
+			return toCachedRows(result), err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return d.loadedRows(d.fromCachedRows(rows))
+	}
+	result, err := d.find()
+	if err != nil {
+		return nil, err
+	}
+	return d.loadedRows(result)
+}
+
+func (d *dx[T]) find() ([]*domainx.Complex[T], *errors.Error) {
 	var result []*domainx.Complex[T]
 	if err := domainx.FindByMatch(d.complex.Con, *d.matches, &result); err != nil {
 		return nil, err
@@ -377,8 +595,32 @@ func (d *dx[T]) AllEach(handle func(*domainx.Complex[T]) *errors.Error) *errors.
 	return nil
 }
 
+func (d *dx[T]) Iter(batchSize ...int) iter.Seq2[*domainx.Complex[T], error] {
+	size := 0
+	if len(batchSize) > 0 {
+		size = batchSize[0]
+	}
+	if d.ctx != nil {
+		d.complex.Con.Ctx = d.ctx
+	}
+	return func(yield func(*domainx.Complex[T], error) bool) {
+		for row, err := range domainx.IterByMatch[domainx.Complex[T]](d.complex.Con, *d.matches, size) {
+			if err == nil && row != nil {
+				if dErr := d.decrypt(row.Data); dErr != nil {
+					err = dErr
+				}
+			}
+			if !yield(row, err) {
+				return
+			}
+		}
+	}
+}
+
 func (d *dx[T]) Count() (int64, *errors.Error) {
-	count, err := domainx.CountByMatch(d.complex.Con, *d.matches)
+	count, err := cached(d, "count", func() (int64, *errors.Error) {
+		return domainx.CountByMatch(d.complex.Con, *d.matches)
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -386,6 +628,10 @@ func (d *dx[T]) Count() (int64, *errors.Error) {
 }
 
 func (d *dx[T]) Exists() (bool, *errors.Error) {
+	return cached(d, "exists", d.exists)
+}
+
+func (d *dx[T]) exists() (bool, *errors.Error) {
 	if !d.IsZero() {
 		if err := domainx.GetByID(d.complex.Con, d.GetID().Int64(), d.complex); err != nil {
 			return false, err
@@ -418,10 +664,66 @@ func (d *dx[T]) Page(page, size int64, lastID ...int64) (*load.PageRespT[*domain
 	if len(lastID) > 0 {
 		lID = lastID[0]
 	}
-	pageLoad := load.BuildPage(d.ctx, page, size, lID)
-	resp := &load.PageRespT[*domainx.Complex[T]]{Result: &[]*domainx.Complex[T]{}}
-	if err := domainx.FindByPageMatchT(d.complex.Con, *d.matches, pageLoad, resp, resp.Result); err != nil {
+	return d.page(load.BuildPage(d.ctx, page, size, lID))
+}
+
+func (d *dx[T]) PageCursor(cursor string, size int64) (*load.PageRespT[*domainx.Complex[T]], *errors.Error) {
+	pageLoad := load.BuildPage(d.ctx, 1, size, 0)
+	pageLoad.Cursor = cursor
+	return d.page(pageLoad)
+}
+
+func (d *dx[T]) page(pageLoad *load.Page) (*load.PageRespT[*domainx.Complex[T]], *errors.Error) {
+	findPage := func() (*load.PageRespT[*domainx.Complex[T]], *errors.Error) {
+		resp := &load.PageRespT[*domainx.Complex[T]]{Result: &[]*domainx.Complex[T]{}}
+		if err := domainx.FindByPageMatchT(d.complex.Con, *d.matches, pageLoad, resp, resp.Result); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+	if d.cacheTTL <= 0 {
+		resp, err := findPage()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = d.loadedRows(*resp.Result); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+	p, err := cached(d, "page", func() (cachedPage[T], *errors.Error) {
+		resp, err := findPage()
+		if err != nil {
+			return cachedPage[T]{}, err
+		}
+		return toCachedPage(resp), nil
+	}, pageLoad)
+	if err != nil {
+		return nil, err
+	}
+	resp := d.fromCachedPage(p)
+	if resp.Result == nil {
+		return resp, nil
+	}
+	if _, err = d.loadedRows(*resp.Result); err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
+
+func (d *dx[T]) Aggregate(agg *domainx.Aggregation) (*load.PageRespT[*domainx.AggItem], *errors.Error) {
+	if agg == nil {
+		return nil, errors.Sys("aggregation cannot be nil")
+	}
+	items, total, err := domainx.AggregateByMatch(d.complex.Con, *d.matches, agg)
+	if err != nil {
+		return nil, err
+	}
+	pageLoad := &load.Page{Page: 1, Size: int64(len(items))}
+	if agg.Page != nil {
+		pageLoad = agg.Page
+	}
+	resp := &load.PageRespT[*domainx.AggItem]{}
+	resp.Build(pageLoad, (*load.Total)(&total), 0, &items)
+	return resp, nil
+}
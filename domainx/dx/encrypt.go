@@ -0,0 +1,73 @@
+package dx
+
+import (
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/utils/errors"
+	"reflect"
+)
+
+// dataType is the type of T, its dx:"encrypt" fields are encrypted on write and decrypted on read, see domainx.EncryptData
+func (d *dx[T]) dataType() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func (d *dx[T]) encrypt(list ...*T) *errors.Error {
+	for _, t := range list {
+		if err := domainx.EncryptData(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dx[T]) decrypt(list ...*T) *errors.Error {
+	for _, t := range list {
+		if err := domainx.DecryptData(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dx[T]) decryptRows(rows []*domainx.Complex[T]) *errors.Error {
+	for _, row := range rows {
+		if err := d.decrypt(row.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dx[T]) encryptUpdate(update map[string]interface{}) (map[string]interface{}, *errors.Error) {
+	return domainx.EncryptUpdate(d.complex.GetConType(), d.dataType(), update)
+}
+
+// blind queries an encrypted field by its blind index, an encrypted field without one fails the query
+func (d *dx[T]) blind(field string, value interface{}) (string, interface{}, bool) {
+	if d.complex == nil || d.complex.Con == nil {
+		return field, value, true
+	}
+	field, value, err := domainx.BlindMatch(d.complex.GetConType(), d.dataType(), field, value)
+	if err != nil {
+		d.matches.Fail(err)
+		return field, value, false
+	}
+	return field, value, true
+}
+
+func (d *dx[T]) Reencrypt() (int64, *errors.Error) {
+	var count int64
+	defer domainx.AfterCommit(d.ctx, d.invalidate)
+	err := d.AllEach(func(row *domainx.Complex[T]) *errors.Error {
+		update, err := domainx.EncryptedFields(d.complex.GetConType(), row.Data)
+		if err != nil || len(update) == 0 {
+			return err
+		}
+		if err = domainx.UpdatePart(d.complex.Con, row.GetID().Int64(), update); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,151 @@
+package dx
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/utils/errors"
+	"reflect"
+	"strings"
+)
+
+// BeforeSaver is implemented by tables checked or completed before Save, SaveMany, UpsertBy and the inserts of BulkWrite,
+// an error aborts the write
+type BeforeSaver interface {
+	BeforeSave(ctx context.Context) *errors.Error
+}
+
+// AfterSaver is implemented by tables notified after Save, SaveMany, UpsertBy and the inserts of BulkWrite.
+// The record is already written when its error is returned, run the write in a Transaction to roll it back.
+type AfterSaver interface {
+	AfterSave(ctx context.Context) *errors.Error
+}
+
+// BeforeDeleter is implemented by tables checked before Delete, called on every record to delete, an error aborts the delete
+type BeforeDeleter interface {
+	BeforeDelete(ctx context.Context) *errors.Error
+}
+
+// AfterDeleter is implemented by tables notified after Delete, called on every deleted record
+type AfterDeleter interface {
+	AfterDelete(ctx context.Context) *errors.Error
+}
+
+// Validator is implemented by tables validated before a save, after BeforeSave and the validate struct tags
+type Validator interface {
+	Validate() *errors.Error
+}
+
+var validate = newValidate()
+
+// newValidate checks the validate tags of go-playground/validator, errors name the fields by their json name
+func newValidate() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return f.Name
+		}
+		return name
+	})
+	return v
+}
+
+// validateTags checks the validate tags of t, the first failed rule is returned as a verify error
+func validateTags(t any) *errors.Error {
+	if rt := reflect.TypeOf(t); rt == nil || rt.Kind() != reflect.Ptr || rt.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	err := validate.Struct(t)
+	if err == nil {
+		return nil
+	}
+	if fieldErrs, ok := err.(validator.ValidationErrors); ok && len(fieldErrs) > 0 {
+		f := fieldErrs[0]
+		message := fmt.Sprintf("%s failed on %s", f.Field(), f.Tag())
+		if f.Param() != "" {
+			message += "=" + f.Param()
+		}
+		return errors.Verify(message, err)
+	}
+	return errors.Sys("validate failed", err)
+}
+
+// beforeSave runs BeforeSave, the validate tags and Validate on every record of a save
+func (d *dx[T]) beforeSave(list ...*T) *errors.Error {
+	for _, t := range list {
+		if h, ok := any(t).(BeforeSaver); ok {
+			if err := h.BeforeSave(d.ctx); err != nil {
+				return err
+			}
+		}
+		if err := validateTags(t); err != nil {
+			return err
+		}
+		if v, ok := any(t).(Validator); ok {
+			if err := v.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *dx[T]) afterSave(list ...*T) *errors.Error {
+	for _, t := range list {
+		if h, ok := any(t).(AfterSaver); ok {
+			if err := h.AfterSave(d.ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// beforeDelete loads every record to delete when T has a delete hook, without the find cap, and runs BeforeDelete on them,
+// the records are returned for afterDelete
+func (d *dx[T]) beforeDelete() ([]*domainx.Complex[T], *errors.Error) {
+	_, before := any(new(T)).(BeforeDeleter)
+	_, after := any(new(T)).(AfterDeleter)
+	if !before && !after {
+		return nil, nil
+	}
+	var rows []*domainx.Complex[T]
+	if !d.IsZero() {
+		row := d.complex.Derive(new(T))
+		if err := domainx.GetByID(d.complex.Con, d.GetID().Int64(), row); err != nil {
+			return nil, err
+		}
+		if !row.IsNil() {
+			rows = append(rows, row)
+		}
+	} else if err := d.eachMatched(func(row *domainx.Complex[T]) *errors.Error {
+		rows = append(rows, row)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := d.decryptRows(rows); err != nil {
+		return nil, err
+	}
+	if before {
+		for _, row := range rows {
+			if err := any(row.Data).(BeforeDeleter).BeforeDelete(d.ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rows, nil
+}
+
+func (d *dx[T]) afterDelete(rows []*domainx.Complex[T]) *errors.Error {
+	for _, row := range rows {
+		if h, ok := any(row.Data).(AfterDeleter); ok {
+			if err := h.AfterDelete(d.ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
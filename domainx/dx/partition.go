@@ -0,0 +1,9 @@
+package dx
+
+import "github.com/jom-io/gorig/domainx"
+
+// Partitioned is implemented by tables split into one table per day, month or year of a time field, see domainx.Partition.
+// Register the migration with domainx.AutoMigrate to create the upcoming tables and drop the expired ones.
+type Partitioned interface {
+	DPartition() domainx.Partition
+}
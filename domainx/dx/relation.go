@@ -0,0 +1,247 @@
+package dx
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/spf13/cast"
+)
+
+type (
+	// Related declares the relations of a DTable type, preload them with DQuery.Preload
+	Related interface {
+		DRelations() []Relation
+	}
+
+	// Relation is a relation of a DTable type, build it with BelongsTo, HasMany or ManyToMany
+	Relation struct {
+		Name string
+		load func(ctx context.Context, owners []owner) *errors.Error
+	}
+
+	// owner is a record whose relations are preloaded
+	owner struct {
+		id   int64
+		data any
+		set  func(name string, value any)
+	}
+)
+
+// BelongsTo relates the record by its foreignKey field to the R record with that ID, preloaded as *domainx.Complex[R]
+func BelongsTo[R any, PR interface {
+	*R
+	DTable
+}](name, foreignKey string) Relation {
+	return Relation{Name: name, load: func(ctx context.Context, owners []owner) *errors.Error {
+		keys := make([]int64, 0, len(owners))
+		seen := make(map[int64]bool, len(owners))
+		for _, o := range owners {
+			if v, ok := domainx.FieldValue(o.data, foreignKey); ok {
+				if id := cast.ToInt64(v); id != 0 && !seen[id] {
+					seen[id] = true
+					keys = append(keys, id)
+				}
+			}
+		}
+		rows, err := findByIDs[R, PR](ctx, keys)
+		if err != nil {
+			return err
+		}
+		byID := make(map[int64]*domainx.Complex[R], len(rows))
+		for _, row := range rows {
+			byID[row.GetID().Int64()] = row
+		}
+		for _, o := range owners {
+			v, _ := domainx.FieldValue(o.data, foreignKey)
+			o.set(name, byID[cast.ToInt64(v)])
+		}
+		return nil
+	}}
+}
+
+// HasMany relates the record to the R records whose foreignKey field holds its ID, preloaded as []*domainx.Complex[R]
+func HasMany[R any, PR interface {
+	*R
+	DTable
+}](name, foreignKey string) Relation {
+	return Relation{Name: name, load: func(ctx context.Context, owners []owner) *errors.Error {
+		rows, err := findByField[R, PR](ctx, foreignKey, ownerIDs(owners))
+		if err != nil {
+			return err
+		}
+		byOwner := make(map[int64][]*domainx.Complex[R], len(owners))
+		for _, row := range rows {
+			v, _ := domainx.FieldValue(row.Data, foreignKey)
+			id := cast.ToInt64(v)
+			byOwner[id] = append(byOwner[id], row)
+		}
+		for _, o := range owners {
+			o.set(name, byOwner[o.id])
+		}
+		return nil
+	}}
+}
+
+// ManyToMany relates the record to the R records through the join table J, whose localKey field holds the ID of the record
+// and relatedKey field the ID of the R record, preloaded as []*domainx.Complex[R]
+func ManyToMany[R any, PR interface {
+	*R
+	DTable
+}, J any, PJ interface {
+	*J
+	DTable
+}](name, localKey, relatedKey string) Relation {
+	return Relation{Name: name, load: func(ctx context.Context, owners []owner) *errors.Error {
+		joins, err := findByField[J, PJ](ctx, localKey, ownerIDs(owners))
+		if err != nil {
+			return err
+		}
+		var keys []int64
+		seen := make(map[int64]bool)
+		links := make(map[int64][]int64, len(owners))
+		for _, j := range joins {
+			local, _ := domainx.FieldValue(j.Data, localKey)
+			related, _ := domainx.FieldValue(j.Data, relatedKey)
+			id := cast.ToInt64(related)
+			links[cast.ToInt64(local)] = append(links[cast.ToInt64(local)], id)
+			if !seen[id] {
+				seen[id] = true
+				keys = append(keys, id)
+			}
+		}
+		rows, err := findByIDs[R, PR](ctx, keys)
+		if err != nil {
+			return err
+		}
+		byID := make(map[int64]*domainx.Complex[R], len(rows))
+		for _, row := range rows {
+			byID[row.GetID().Int64()] = row
+		}
+		for _, o := range owners {
+			var related []*domainx.Complex[R]
+			for _, id := range links[o.id] {
+				if row, ok := byID[id]; ok {
+					related = append(related, row)
+				}
+			}
+			o.set(name, related)
+		}
+		return nil
+	}}
+}
+
+func ownerIDs(owners []owner) []int64 {
+	ids := make([]int64, 0, len(owners))
+	for _, o := range owners {
+		ids = append(ids, o.id)
+	}
+	return ids
+}
+
+// relationBatch is the size of the IN list of a preload query
+const relationBatch = 500
+
+func findByIDs[R any, PR interface {
+	*R
+	DTable
+}](ctx context.Context, ids []int64) ([]*domainx.Complex[R], *errors.Error) {
+	var result []*domainx.Complex[R]
+	for start := 0; start < len(ids); start += relationBatch {
+		end := min(start+relationBatch, len(ids))
+		q := On[R, PR](ctx).(*dx[R])
+		var rows []*domainx.Complex[R]
+		if err := domainx.FindByIDs(q.GetCon(), ids[start:end], &rows); err != nil {
+			return nil, err
+		}
+		if err := q.decryptRows(rows); err != nil {
+			return nil, err
+		}
+		result = append(result, rows...)
+	}
+	return result, nil
+}
+
+func findByField[R any, PR interface {
+	*R
+	DTable
+}](ctx context.Context, field string, values []int64) ([]*domainx.Complex[R], *errors.Error) {
+	var result []*domainx.Complex[R]
+	for start := 0; start < len(values); start += relationBatch {
+		end := min(start+relationBatch, len(values))
+		rows, err := On[R, PR](ctx).In(field, values[start:end]).Find()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rows...)
+	}
+	return result, nil
+}
+
+// One returns the preloaded BelongsTo record of the relation name
+func One[R any, T any](row *domainx.Complex[T], name string) *domainx.Complex[R] {
+	v, _ := row.Related(name).(*domainx.Complex[R])
+	return v
+}
+
+// Many returns the preloaded HasMany or ManyToMany records of the relation name
+func Many[R any, T any](row *domainx.Complex[T], name string) []*domainx.Complex[R] {
+	v, _ := row.Related(name).([]*domainx.Complex[R])
+	return v
+}
+
+func (d *dx[T]) Preload(names ...string) DQuery[T] {
+	d.preloads = append(d.preloads, names...)
+	return d
+}
+
+// preload attaches the relations named by Preload to rows, one batched query per relation
+func (d *dx[T]) preload(rows []*domainx.Complex[T]) *errors.Error {
+	if len(d.preloads) == 0 || len(rows) == 0 {
+		return nil
+	}
+	related, ok := any(new(T)).(Related)
+	if !ok {
+		return errors.Sys(fmt.Sprintf("%s declares no relations", d.complex.TableName()))
+	}
+	relations := make(map[string]Relation)
+	for _, r := range related.DRelations() {
+		relations[r.Name] = r
+	}
+	owners := make([]owner, 0, len(rows))
+	for _, row := range rows {
+		if row.IsNil() || row.Data == nil {
+			continue
+		}
+		owners = append(owners, owner{id: row.GetID().Int64(), data: row.Data, set: row.SetRelated})
+	}
+	for _, name := range d.preloads {
+		r, found := relations[name]
+		if !found {
+			return errors.Sys(fmt.Sprintf("%s has no relation %s", d.complex.TableName(), name))
+		}
+		if err := r.load(d.ctx, owners); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loaded decrypts and preloads a result of Get
+func (d *dx[T]) loaded(c *domainx.Complex[T]) (*domainx.Complex[T], *errors.Error) {
+	if _, err := d.loadedRows([]*domainx.Complex[T]{c}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadedRows decrypts and preloads the results of Find and Page
+func (d *dx[T]) loadedRows(rows []*domainx.Complex[T]) ([]*domainx.Complex[T], *errors.Error) {
+	if err := d.decryptRows(rows); err != nil {
+		return nil, err
+	}
+	if err := d.preload(rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,44 @@
+package dx
+
+import (
+	"context"
+	"github.com/jom-io/gorig/utils/logger"
+)
+
+// Tenanted is implemented by tables shared by tenants, DTenant returns the field holding the tenant ID.
+// Queries are scoped to the tenant of the context (see httpx.Tenant and domainx.WithTenant) and Save sets the field.
+type Tenanted interface {
+	DTenant() string
+}
+
+// TenantRouted is implemented by tables stored per tenant, DTenantRoute returns the database and the table prefix of the tenant
+type TenantRouted interface {
+	DTenantRoute(tenantID string) (dbName string, tablePrefix string)
+}
+
+// tenantRoute returns the database and the table prefixes of the tenant of ctx when t is TenantRouted
+func tenantRoute(ctx context.Context, t any, dbName string) (string, []string) {
+	r, ok := t.(TenantRouted)
+	if !ok {
+		return dbName, nil
+	}
+	tenantID := logger.GetTenantID(ctx)
+	if tenantID == "" {
+		return dbName, nil
+	}
+	db, prefix := r.DTenantRoute(tenantID)
+	if db == "" {
+		db = dbName
+	}
+	if prefix == "" {
+		return db, nil
+	}
+	return db, []string{prefix}
+}
+
+func (d *dx[T]) Unscoped() DQuery[T] {
+	if d.complex != nil && d.complex.Con != nil {
+		d.complex.Con.SetUnscoped(true)
+	}
+	return d
+}
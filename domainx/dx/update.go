@@ -0,0 +1,110 @@
+package dx
+
+import (
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/utils/errors"
+)
+
+// Changes is an update map built with atomic operators, pass it to Updates or FindOneAndUpdate,
+// e.g. dx.Inc("views", 1).Push("tags", "go").Set("status", 2)
+type Changes map[string]interface{}
+
+// Inc adds n to field, see domainx.Inc
+func Inc(field string, n interface{}) Changes {
+	return Changes{}.Inc(field, n)
+}
+
+// Push appends values to the array field, see domainx.Push
+func Push(field string, values ...interface{}) Changes {
+	return Changes{}.Push(field, values...)
+}
+
+// AddToSet appends the values missing from the array field, see domainx.AddToSet
+func AddToSet(field string, values ...interface{}) Changes {
+	return Changes{}.AddToSet(field, values...)
+}
+
+// Pull removes values from the array field, see domainx.Pull
+func Pull(field string, values ...interface{}) Changes {
+	return Changes{}.Pull(field, values...)
+}
+
+// SetOnInsert sets field when FindOneAndUpdate inserts the record, mongo only, see domainx.SetOnInsert
+func SetOnInsert(field string, value interface{}) Changes {
+	return Changes{}.SetOnInsert(field, value)
+}
+
+// Min sets field to value if value is lower, see domainx.Min
+func Min(field string, value interface{}) Changes {
+	return Changes{}.Min(field, value)
+}
+
+// Max sets field to value if value is greater, see domainx.Max
+func Max(field string, value interface{}) Changes {
+	return Changes{}.Max(field, value)
+}
+
+// Unset removes field, see domainx.Unset
+func Unset(field string) Changes {
+	return Changes{}.Unset(field)
+}
+
+func (c Changes) Set(field string, value interface{}) Changes {
+	c[field] = value
+	return c
+}
+
+func (c Changes) Inc(field string, n interface{}) Changes {
+	return c.Set(field, domainx.Inc(n))
+}
+
+func (c Changes) Push(field string, values ...interface{}) Changes {
+	return c.Set(field, domainx.Push(values...))
+}
+
+func (c Changes) AddToSet(field string, values ...interface{}) Changes {
+	return c.Set(field, domainx.AddToSet(values...))
+}
+
+func (c Changes) Pull(field string, values ...interface{}) Changes {
+	return c.Set(field, domainx.Pull(values...))
+}
+
+func (c Changes) SetOnInsert(field string, value interface{}) Changes {
+	return c.Set(field, domainx.SetOnInsert(value))
+}
+
+func (c Changes) Min(field string, value interface{}) Changes {
+	return c.Set(field, domainx.Min(value))
+}
+
+func (c Changes) Max(field string, value interface{}) Changes {
+	return c.Set(field, domainx.Max(value))
+}
+
+func (c Changes) Unset(field string) Changes {
+	return c.Set(field, domainx.Unset())
+}
+
+func (d *dx[T]) FindOneAndUpdate(data map[string]interface{}, returnNew bool) (*domainx.Complex[T], *errors.Error) {
+	if len(data) == 0 {
+		return nil, errors.Sys("data map cannot be empty")
+	}
+	if d.IsZero() {
+		if err := d.checkMatches(); err != nil {
+			return nil, err
+		}
+	}
+	data, err := d.encryptUpdate(data)
+	if err != nil {
+		return nil, err
+	}
+	var found bool
+	if err = d.write(domainx.AuditUpdate, data, func() (fErr *errors.Error) {
+		found, fErr = domainx.FindOneAndUpdate(d.complex.Con, *d.matches, data, returnNew, d.complex)
+		return fErr
+	}); err != nil || !found {
+		return nil, err
+	}
+	return d.loaded(d.complex)
+}
@@ -0,0 +1,15 @@
+package dx
+
+import (
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/utils/errors"
+)
+
+// Watch calls handler for every change of the table of T, see domainx.Watch
+func Watch[T any, PT interface {
+	*T
+	DTable
+}](handler domainx.WatchHandler[T], opts ...domainx.WatchOption) *errors.Error {
+	conType, dbName, table := PT(new(T)).DConfig()
+	return domainx.Watch[T](conType, dbName, table, handler, opts...)
+}
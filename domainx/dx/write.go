@@ -0,0 +1,62 @@
+package dx
+
+import (
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/utils/errors"
+)
+
+// eachMatched streams every record matched by d to fn, unlike Find it is not capped
+func (d *dx[T]) eachMatched(fn func(row *domainx.Complex[T]) *errors.Error) *errors.Error {
+	for row, err := range domainx.IterByMatch[domainx.Complex[T]](d.complex.Con, *d.matches, 0) {
+		if err != nil {
+			if e, ok := err.(*errors.Error); ok {
+				return e
+			}
+			return errors.Sys(d.complex.TableName()+" iteration failed", err)
+		}
+		if e := fn(row); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// save runs Save with the audit trail and the query cache invalidation of the table
+func (d *dx[T]) save(save func() (int64, *errors.Error)) (int64, *errors.Error) {
+	audited := d.isAudited()
+	var before map[string]interface{}
+	if audited && !d.IsZero() {
+		before = d.auditBefore()[d.GetID().Int64()]
+	}
+	id, err := save()
+	if err != nil {
+		return id, err
+	}
+	domainx.AfterCommit(d.ctx, d.invalidate)
+	if audited {
+		d.auditSaved(id, before)
+	}
+	return id, nil
+}
+
+// write runs an update (update is the applied map) or a delete (update is nil) with the audit trail
+// and the query cache invalidation of the table
+func (d *dx[T]) write(op domainx.AuditOp, update map[string]interface{}, write func() *errors.Error) *errors.Error {
+	audited := d.isAudited()
+	var befores map[int64]map[string]interface{}
+	applied := make(map[string]interface{}, len(update))
+	if audited {
+		for k, v := range update {
+			applied[k] = v
+		}
+		befores = d.auditBefore()
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	domainx.AfterCommit(d.ctx, d.invalidate)
+	if audited {
+		d.auditWritten(op, befores, applied)
+	}
+	return nil
+}
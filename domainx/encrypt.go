@@ -0,0 +1,327 @@
+package domainx
+
+import (
+	"fmt"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/encrypt"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/spf13/cast"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// encPrefix marks an encrypted value, followed by the format, the key id and the ciphertext: enc:v2:<keyID>:<ciphertext>
+// is AES-GCM, the legacy enc:<keyID>:<ciphertext> is AES-CBC and is still read
+const (
+	encPrefix = "enc:"
+	encGCM    = "v2"
+)
+
+// encField is a string field tagged dx:"encrypt", optionally with a blind index field dx:"encrypt,blind=<Field>"
+type encField struct {
+	index []int
+	field reflect.StructField
+	blind *reflect.StructField
+}
+
+var encFieldCache sync.Map // reflect.Type -> []encField or *errors.Error
+
+// encFields returns the encrypted fields of the struct type t, its embedded structs included
+func encFields(t reflect.Type) ([]encField, *errors.Error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	if v, ok := encFieldCache.Load(t); ok {
+		if err, isErr := v.(*errors.Error); isErr {
+			return nil, err
+		}
+		return v.([]encField), nil
+	}
+	fields, err := scanEncFields(t, nil)
+	if err != nil {
+		encFieldCache.Store(t, err)
+		return nil, err
+	}
+	encFieldCache.Store(t, fields)
+	return fields, nil
+}
+
+func scanEncFields(t reflect.Type, parent []int) ([]encField, *errors.Error) {
+	var fields []encField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		index := append(append([]int(nil), parent...), i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(Con{}) {
+			embedded, err := scanEncFields(field.Type, index)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, embedded...)
+			continue
+		}
+		opts := strings.Split(field.Tag.Get("dx"), ",")
+		if opts[0] != "encrypt" {
+			continue
+		}
+		if field.Type.Kind() != reflect.String {
+			return nil, errors.Sys(fmt.Sprintf("%s.%s: only string fields can be encrypted", t.Name(), field.Name))
+		}
+		f := encField{index: index, field: field}
+		for _, opt := range opts[1:] {
+			name, ok := strings.CutPrefix(opt, "blind=")
+			if !ok {
+				continue
+			}
+			blind, found := t.FieldByName(name)
+			if !found || blind.Type.Kind() != reflect.String {
+				return nil, errors.Sys(fmt.Sprintf("%s.%s: blind index field %s must be a string field", t.Name(), field.Name, name))
+			}
+			blind.Index = append(append([]int(nil), parent...), blind.Index...)
+			f.blind = &blind
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// storageName is the name of field in the documents or columns of conType
+func storageName(conType ConType, field reflect.StructField) string {
+	if conType == Mongo {
+		if name, _, _ := strings.Cut(field.Tag.Get("bson"), ","); name != "" && name != "-" {
+			return name
+		}
+		return strings.ToLower(field.Name)
+	}
+	for _, part := range strings.Split(field.Tag.Get("gorm"), ";") {
+		if column, ok := strings.CutPrefix(part, "column:"); ok {
+			return column
+		}
+	}
+	return schema.NamingStrategy{}.ColumnName("", field.Name)
+}
+
+func (f encField) named(name string) bool {
+	return fieldNames(f.field)[name] || strings.ToLower(f.field.Name) == name
+}
+
+// EncryptValue encrypts plain with AES-GCM and the key ${ domainx.encrypt.current } of ${ domainx.encrypt.keys },
+// the keys are base64 AES keys by id, old keys stay configured to read the values they encrypted
+func EncryptValue(plain string) (string, *errors.Error) {
+	keyID := configure.GetString("domainx.encrypt.current")
+	key := configure.GetString("domainx.encrypt.keys." + keyID)
+	if keyID == "" || key == "" {
+		return "", errors.Sys("encryption key is not configured, set domainx.encrypt.current and domainx.encrypt.keys")
+	}
+	cipher, err := encrypt.EncryptGCM(plain, key)
+	if err != nil {
+		return "", errors.Sys("encrypt value failed", err)
+	}
+	return encPrefix + encGCM + ":" + keyID + ":" + cipher, nil
+}
+
+// DecryptValue decrypts a value of EncryptValue with the key it was encrypted with, a plain value is returned as is
+func DecryptValue(value string) (string, *errors.Error) {
+	rest, ok := strings.CutPrefix(value, encPrefix)
+	if !ok {
+		return value, nil
+	}
+	// the base64 ciphertext has no colon, three parts are the versioned format
+	parts := strings.Split(rest, ":")
+	var keyID, cipher string
+	decrypt := encrypt.Decrypt
+	switch {
+	case len(parts) == 2:
+		keyID, cipher = parts[0], parts[1]
+	case len(parts) == 3 && parts[0] == encGCM:
+		keyID, cipher, decrypt = parts[1], parts[2], encrypt.DecryptGCM
+	default:
+		return "", errors.Sys("encrypted value is malformed")
+	}
+	key := configure.GetString("domainx.encrypt.keys." + keyID)
+	if key == "" {
+		return "", errors.Sys(fmt.Sprintf("encryption key %s is not configured", keyID))
+	}
+	plain, err := decrypt(cipher, key)
+	if err != nil {
+		return "", errors.Sys("decrypt value failed", err)
+	}
+	return plain, nil
+}
+
+// BlindIndex is the deterministic HMAC of plain with ${ domainx.encrypt.blindKey }, stored to query encrypted fields by equality.
+// The blind key cannot be rotated without rewriting the blind indexes.
+func BlindIndex(plain string) (string, *errors.Error) {
+	key := configure.GetString("domainx.encrypt.blindKey")
+	if key == "" {
+		return "", errors.Sys("blind index key is not configured, set domainx.encrypt.blindKey")
+	}
+	index, err := encrypt.Hmac(plain, key)
+	if err != nil {
+		return "", errors.Sys("blind index failed", err)
+	}
+	return index, nil
+}
+
+func structValue(data any) (reflect.Value, bool) {
+	rv := reflect.ValueOf(data)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return reflect.Value{}, false
+		}
+		rv = rv.Elem()
+	}
+	return rv, rv.Kind() == reflect.Struct && rv.CanSet()
+}
+
+// EncryptData encrypts the dx:"encrypt" fields of data in place and sets their blind indexes, encrypted values are kept
+func EncryptData(data any) *errors.Error {
+	rv, ok := structValue(data)
+	if !ok {
+		return nil
+	}
+	fields, err := encFields(rv.Type())
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	for _, f := range fields {
+		fv := rv.FieldByIndex(f.index)
+		plain := fv.String()
+		if plain == "" || strings.HasPrefix(plain, encPrefix) {
+			continue
+		}
+		if f.blind != nil {
+			index, bErr := BlindIndex(plain)
+			if bErr != nil {
+				return bErr
+			}
+			rv.FieldByIndex(f.blind.Index).SetString(index)
+		}
+		cipher, eErr := EncryptValue(plain)
+		if eErr != nil {
+			return eErr
+		}
+		fv.SetString(cipher)
+	}
+	return nil
+}
+
+// DecryptData decrypts the dx:"encrypt" fields of data in place
+func DecryptData(data any) *errors.Error {
+	rv, ok := structValue(data)
+	if !ok {
+		return nil
+	}
+	fields, err := encFields(rv.Type())
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	for _, f := range fields {
+		fv := rv.FieldByIndex(f.index)
+		plain, dErr := DecryptValue(fv.String())
+		if dErr != nil {
+			return dErr
+		}
+		fv.SetString(plain)
+	}
+	return nil
+}
+
+// EncryptUpdate returns update with the values of the dx:"encrypt" fields of t encrypted and their blind indexes set
+func EncryptUpdate(conType ConType, t reflect.Type, update map[string]interface{}) (map[string]interface{}, *errors.Error) {
+	fields, err := encFields(t)
+	if err != nil || len(fields) == 0 || len(update) == 0 {
+		return update, err
+	}
+	result := make(map[string]interface{}, len(update))
+	for k, v := range update {
+		result[k] = v
+	}
+	for k, v := range update {
+		for _, f := range fields {
+			if !f.named(k) {
+				continue
+			}
+			plain := cast.ToString(v)
+			if plain == "" || strings.HasPrefix(plain, encPrefix) {
+				break
+			}
+			cipher, eErr := EncryptValue(plain)
+			if eErr != nil {
+				return nil, eErr
+			}
+			result[k] = cipher
+			if f.blind != nil {
+				index, bErr := BlindIndex(plain)
+				if bErr != nil {
+					return nil, bErr
+				}
+				result[storageName(conType, *f.blind)] = index
+			}
+			break
+		}
+	}
+	return result, nil
+}
+
+// BlindMatch rewrites an equality match on a dx:"encrypt" field of t to its blind index field, other fields are
+// returned as is. An encrypted field without blind index cannot be matched, its ciphertext differs on every write.
+func BlindMatch(conType ConType, t reflect.Type, field string, value interface{}) (string, interface{}, *errors.Error) {
+	fields, err := encFields(t)
+	if err != nil {
+		return field, value, err
+	}
+	for _, f := range fields {
+		if !f.named(field) {
+			continue
+		}
+		if f.blind == nil {
+			return field, value, errors.Verify(fmt.Sprintf("encrypted field %s has no blind index and cannot be matched", field))
+		}
+		switch v := value.(type) {
+		case []string, []interface{}:
+			list := cast.ToStringSlice(v)
+			indexes := make([]string, len(list))
+			for i, plain := range list {
+				if indexes[i], err = BlindIndex(plain); err != nil {
+					return field, value, err
+				}
+			}
+			return storageName(conType, *f.blind), indexes, nil
+		}
+		plain := cast.ToString(value)
+		if plain == "" {
+			return field, value, nil
+		}
+		index, err := BlindIndex(plain)
+		if err != nil {
+			return field, value, err
+		}
+		return storageName(conType, *f.blind), index, nil
+	}
+	return field, value, nil
+}
+
+// EncryptedFields returns the dx:"encrypt" fields of the plain data encrypted with the current key and their blind indexes,
+// by storage name, to rewrite them after a key rotation
+func EncryptedFields(conType ConType, data any) (map[string]interface{}, *errors.Error) {
+	rv, ok := structValue(data)
+	if !ok {
+		return nil, nil
+	}
+	fields, err := encFields(rv.Type())
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	update := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if plain := rv.FieldByIndex(f.index).String(); plain != "" {
+			update[storageName(conType, f.field)] = plain
+		}
+	}
+	return EncryptUpdate(conType, rv.Type(), update)
+}
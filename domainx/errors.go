@@ -0,0 +1,141 @@
+package domainx
+
+import (
+	"context"
+	checkErr "errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jom-io/gorig/utils/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+)
+
+// Typed errors returned by the domainx functions, compare with errors.Is or the IsX helpers.
+// Reads of a missing record still return an empty result, MustGetByID and updates matching nothing return ErrNotFound.
+var (
+	ErrNotFound     = errors.NotFound("record not found")
+	ErrDuplicateKey = errors.DuplicateKey("duplicate key")
+	ErrConflict     = errors.Conflict("record has been modified")
+	ErrTimeout      = errors.Timeout("database operation timed out")
+)
+
+// errNoMatch is returned by the db services when an update by match changes nothing
+var errNoMatch = checkErr.New("no records matched update condition")
+
+// DuplicateKeyError is the native error of an ErrDuplicateKey, Index is the violated unique index
+type DuplicateKeyError struct {
+	Index string
+	Err   error
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DuplicateKeyError) Unwrap() error {
+	return e.Err
+}
+
+func IsNotFound(err *errors.Error) bool {
+	return err.IsNotFound()
+}
+
+func IsDuplicateKey(err *errors.Error) bool {
+	return err.IsDuplicateKey()
+}
+
+func IsTimeout(err *errors.Error) bool {
+	return err.IsTimeout()
+}
+
+// DuplicateIndex returns the unique index violated by a duplicate key error, empty if unknown
+func DuplicateIndex(err *errors.Error) string {
+	var dup *DuplicateKeyError
+	if err.IsDuplicateKey() && checkErr.As(err.Err, &dup) {
+		return dup.Index
+	}
+	return ""
+}
+
+const (
+	mysqlDuplicateEntry   = 1062
+	mysqlLockWaitTimeout  = 1205
+	mysqlDeadlock         = 1213
+	mysqlExecutionTimeout = 3024
+	mongoWriteConflict    = 112
+)
+
+var (
+	mysqlDupIndex = regexp.MustCompile(`for key '([^']+)'`)
+	mongoDupIndex = regexp.MustCompile(`index: (\S+)`)
+)
+
+// typedErr maps a driver error to a typed error, nil if it has no type
+func (c *Con) typedErr(err error) *errors.Error {
+	table := c.TableName()
+	if checkErr.Is(err, ErrVersionConflict) {
+		return errors.Conflict(fmt.Sprintf("%s record has been modified, please reload and retry", table), err)
+	}
+	if checkErr.Is(err, errNoMatch) {
+		return errors.NotFound(fmt.Sprintf("%s %s", table, errNoMatch.Error()), err)
+	}
+	if noRecord(err) {
+		return errors.NotFound(fmt.Sprintf("%s record not found", table), err)
+	}
+	if checkErr.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err) {
+		return errors.Timeout(fmt.Sprintf("%s database operation timed out", table), err)
+	}
+	if index, ok := duplicateIndex(err); ok {
+		message := fmt.Sprintf("%s duplicate key", table)
+		if index != "" {
+			message += " on index " + index
+		}
+		return errors.DuplicateKey(message, &DuplicateKeyError{Index: index, Err: err})
+	}
+	var myErr *mysql.MySQLError
+	if checkErr.As(err, &myErr) {
+		switch myErr.Number {
+		case mysqlDeadlock:
+			return errors.Conflict(fmt.Sprintf("%s record is locked by a concurrent transaction, please retry", table), err)
+		case mysqlLockWaitTimeout, mysqlExecutionTimeout:
+			return errors.Timeout(fmt.Sprintf("%s database operation timed out", table), err)
+		}
+	}
+	var srvErr mongo.ServerError
+	if checkErr.As(err, &srvErr) && srvErr.HasErrorCode(mongoWriteConflict) {
+		return errors.Conflict(fmt.Sprintf("%s record is modified by a concurrent transaction, please retry", table), err)
+	}
+	return nil
+}
+
+// noRecord reports whether err is the driver error of a single record read or write finding no record,
+// qmgo.ErrNoSuchDocuments is mongo.ErrNoDocuments
+func noRecord(err error) bool {
+	return checkErr.Is(err, mongo.ErrNoDocuments) || checkErr.Is(err, gorm.ErrRecordNotFound)
+}
+
+// duplicateIndex reports whether err is a unique index violation and returns the index name when the driver gives it
+func duplicateIndex(err error) (string, bool) {
+	var myErr *mysql.MySQLError
+	if checkErr.As(err, &myErr) && myErr.Number == mysqlDuplicateEntry {
+		index := ""
+		if m := mysqlDupIndex.FindStringSubmatch(myErr.Message); m != nil {
+			// MySQL 8 qualifies the key with the table name
+			index = m[1][strings.LastIndex(m[1], ".")+1:]
+		}
+		return index, true
+	}
+	if checkErr.Is(err, gorm.ErrDuplicatedKey) {
+		return "", true
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		index := ""
+		if m := mongoDupIndex.FindStringSubmatch(err.Error()); m != nil {
+			index = m[1]
+		}
+		return index, true
+	}
+	return "", false
+}
@@ -0,0 +1,169 @@
+package domainx
+
+import (
+	"fmt"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+)
+
+// mysqlIndexSpec is the comparable shape of a mysql index, indexes are matched by Name
+type mysqlIndexSpec struct {
+	Name     string
+	Columns  string
+	Unique   bool
+	Fulltext bool
+}
+
+type mysqlIndexColumn struct {
+	seq    int
+	column string
+}
+
+func declaredMysqlSpec(index Index) mysqlIndexSpec {
+	columns := make([]string, 0, len(index.Fields))
+	for _, f := range index.Fields {
+		name, desc := indexField(f)
+		if desc && index.IdxType != Text {
+			name += " DESC"
+		}
+		columns = append(columns, name)
+	}
+	return mysqlIndexSpec{
+		Name:     index.IdxName,
+		Columns:  strings.Join(columns, ","),
+		Unique:   index.IdxType == Unique,
+		Fulltext: index.IdxType == Text,
+	}
+}
+
+func listMysqlIndexes(db *gorm.DB, tableName string) (map[string]mysqlIndexSpec, error) {
+	var rows []map[string]interface{}
+	if err := db.Raw("SHOW INDEX FROM `" + tableName + "`").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	specs := make(map[string]mysqlIndexSpec)
+	columns := make(map[string][]mysqlIndexColumn)
+	for _, row := range rows {
+		name := cast.ToString(row["Key_name"])
+		column := cast.ToString(row["Column_name"])
+		if cast.ToString(row["Collation"]) == "D" {
+			column += " DESC"
+		}
+		columns[name] = append(columns[name], mysqlIndexColumn{seq: cast.ToInt(cast.ToString(row["Seq_in_index"])), column: column})
+		specs[name] = mysqlIndexSpec{
+			Name:     name,
+			Unique:   cast.ToInt(cast.ToString(row["Non_unique"])) == 0,
+			Fulltext: cast.ToString(row["Index_type"]) == "FULLTEXT",
+		}
+	}
+	for name, list := range columns {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].seq < list[j].seq
+		})
+		names := make([]string, 0, len(list))
+		for _, c := range list {
+			names = append(names, c.column)
+		}
+		spec := specs[name]
+		spec.Columns = strings.Join(names, ",")
+		specs[name] = spec
+	}
+	return specs, nil
+}
+
+func createMysqlIndex(db *gorm.DB, tableName string, index Index) error {
+	kind := "INDEX"
+	switch index.IdxType {
+	case Unique:
+		kind = "UNIQUE INDEX"
+	case Text:
+		kind = "FULLTEXT INDEX"
+	}
+	columns := make([]string, 0, len(index.Fields))
+	for _, f := range index.Fields {
+		name, desc := indexField(f)
+		column := "`" + name + "`"
+		if desc && index.IdxType != Text {
+			column += " DESC"
+		}
+		columns = append(columns, column)
+	}
+	return db.Exec(fmt.Sprintf("CREATE %s `%s` ON `%s` (%s)", kind, index.IdxName, tableName, strings.Join(columns, ","))).Error
+}
+
+func dropMysqlIndex(db *gorm.DB, tableName, name string) error {
+	return db.Exec(fmt.Sprintf("DROP INDEX `%s` ON `%s`", name, tableName)).Error
+}
+
+// diffMysqlIndexes returns the declared indexes missing from the table and the drift of the existing ones built by CtIdx
+func diffMysqlIndexes(tableName string, existing map[string]mysqlIndexSpec, indexList []Index) ([]Index, []indexChange) {
+	var missing []Index
+	var changes []indexChange
+	declared := make(map[string]bool, len(indexList))
+	for _, index := range indexList {
+		if index.IdxType == TTL {
+			continue
+		}
+		declared[index.IdxName] = true
+		current, ok := existing[index.IdxName]
+		if !ok {
+			missing = append(missing, index)
+			continue
+		}
+		if current != declaredMysqlSpec(index) {
+			changes = append(changes, indexChange{drift: IndexDrift{Table: tableName, Name: index.IdxName, Reason: "changed"}, index: &index})
+		}
+	}
+	for name := range existing {
+		if !declared[name] && managedIndex(name) {
+			changes = append(changes, indexChange{drift: IndexDrift{Table: tableName, Name: name, Reason: "undeclared"}})
+		}
+	}
+	return missing, changes
+}
+
+// migrateMysqlIndexes creates the missing indexes and logs the drift of the existing ones built by CtIdx,
+// changed and undeclared indexes are dropped (and recreated) when ${ domainx.index.reconcile } is set
+func migrateMysqlIndexes(con *Con, tableName string, indexList []Index) error {
+	db := con.MysqlDB
+	existing, err := listMysqlIndexes(db, tableName)
+	if err != nil {
+		return err
+	}
+	reconcile := configure.GetBool("domainx.index.reconcile", false)
+	for _, index := range indexList {
+		if index.IdxType == TTL {
+			logger.Warn(con.Ctx, "ttl index is not supported by mysql, skipped", zap.String("table", tableName), zap.String("index", index.IdxName))
+			continue
+		}
+		if index.Sparse || len(index.Filter) > 0 {
+			logger.Warn(con.Ctx, "sparse and partial index options are not supported by mysql, ignored", zap.String("table", tableName), zap.String("index", index.IdxName))
+		}
+	}
+	missing, changes := diffMysqlIndexes(tableName, existing, indexList)
+	for _, index := range missing {
+		if err = createMysqlIndex(db, tableName, index); err != nil {
+			return err
+		}
+	}
+	for _, change := range changes {
+		logIndexDrift(con, change.drift, reconcile)
+		if !reconcile {
+			continue
+		}
+		if err = dropMysqlIndex(db, tableName, change.drift.Name); err != nil {
+			return err
+		}
+		if change.index != nil {
+			if err = createMysqlIndex(db, tableName, *change.index); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
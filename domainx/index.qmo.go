@@ -0,0 +1,250 @@
+package domainx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/spf13/cast"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+)
+
+// mongoIndexSpec is the comparable shape of a mongo index, indexes are matched by Keys
+type mongoIndexSpec struct {
+	Name   string
+	Keys   string
+	Unique bool
+	Sparse bool
+	TTL    int64 // expireAfterSeconds, -1 when the index does not expire
+	Filter string
+}
+
+type mongoIndexDoc struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	Sparse             bool   `bson:"sparse"`
+	ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+	Partial            bson.M `bson:"partialFilterExpression"`
+	Weights            bson.M `bson:"weights"`
+}
+
+func mongoIndexKeys(index Index) bson.D {
+	prefixed := index.Fields[0] != preCon.str() && index.Fields[0] != preOption.str()
+	keys := make(bson.D, 0, len(index.Fields))
+	for _, f := range index.Fields {
+		name, desc := indexField(f)
+		if prefixed {
+			name = preData.ad(name)
+		}
+		var value interface{} = 1
+		switch {
+		case index.IdxType == Text:
+			value = "text"
+		case index.IdxType == Spatial2D:
+			value = "2dsphere"
+		case desc:
+			value = -1
+		}
+		keys = append(keys, bson.E{Key: name, Value: value})
+	}
+	return keys
+}
+
+// mongoKeysString renders keys in order, text indexes by their sorted fields since mongo stores them as weights
+func mongoKeysString(keys bson.D, weights bson.M) string {
+	var text []string
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if k.Value == "text" || k.Key == "_fts" {
+			for field := range weights {
+				text = append(text, field)
+			}
+			if k.Value == "text" {
+				text = append(text, k.Key)
+			}
+			continue
+		}
+		if k.Key == "_ftsx" {
+			continue
+		}
+		value := cast.ToString(k.Value)
+		if _, err := cast.ToIntE(k.Value); err == nil {
+			value = cast.ToString(cast.ToInt(k.Value))
+		}
+		parts = append(parts, k.Key+":"+value)
+	}
+	if len(text) > 0 {
+		sort.Strings(text)
+		parts = append(parts, "text("+strings.Join(text, ",")+")")
+	}
+	return strings.Join(parts, ",")
+}
+
+func mongoFilterString(filter bson.M) string {
+	if len(filter) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(filter)
+	if err != nil {
+		return fmt.Sprint(filter)
+	}
+	return string(b)
+}
+
+func declaredMongoSpec(index Index) mongoIndexSpec {
+	spec := mongoIndexSpec{
+		Name:   index.IdxName,
+		Keys:   mongoKeysString(mongoIndexKeys(index), nil),
+		Unique: index.IdxType == Unique,
+		Sparse: index.Sparse,
+		TTL:    -1,
+	}
+	if index.IdxType == TTL {
+		spec.TTL = int64(index.TTL.Seconds())
+	}
+	if len(index.Filter) > 0 {
+		spec.Filter = mongoFilterString(mapToBsonM(matchMongoCond(index.Filter)))
+	}
+	return spec
+}
+
+func listMongoIndexes(ctx context.Context, coll *mongo.Collection) (map[string]mongoIndexSpec, error) {
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	specs := make(map[string]mongoIndexSpec)
+	for cursor.Next(ctx) {
+		var doc mongoIndexDoc
+		if err = cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		spec := mongoIndexSpec{
+			Name:   doc.Name,
+			Keys:   mongoKeysString(doc.Key, doc.Weights),
+			Unique: doc.Unique,
+			Sparse: doc.Sparse,
+			TTL:    -1,
+			Filter: mongoFilterString(doc.Partial),
+		}
+		if doc.ExpireAfterSeconds != nil {
+			spec.TTL = *doc.ExpireAfterSeconds
+		}
+		specs[spec.Keys] = spec
+	}
+	return specs, cursor.Err()
+}
+
+// managed reports whether the index covers document fields only, the _id index and foreign indexes are kept
+func (s mongoIndexSpec) managed() bool {
+	if s.Name == "_id_" {
+		return false
+	}
+	for _, part := range strings.Split(s.Keys, ",") {
+		if !hasDef(strings.TrimPrefix(part, "text(")) {
+			return false
+		}
+	}
+	return true
+}
+
+func createMongoIndex(ctx context.Context, coll *mongo.Collection, index Index) error {
+	opts := options.Index()
+	if index.IdxType == Unique {
+		opts.SetUnique(true)
+	}
+	if index.Sparse {
+		opts.SetSparse(true)
+	}
+	if index.IdxType == TTL {
+		opts.SetExpireAfterSeconds(int32(index.TTL.Seconds()))
+	}
+	if len(index.Filter) > 0 {
+		opts.SetPartialFilterExpression(mapToBsonM(matchMongoCond(index.Filter)))
+	}
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: mongoIndexKeys(index), Options: opts})
+	return err
+}
+
+// diffMongoIndexes returns the declared indexes missing from the collection and the drift of the existing ones
+func diffMongoIndexes(tableName string, existing map[string]mongoIndexSpec, indexList []Index) ([]Index, []indexChange) {
+	var missing []Index
+	var changes []indexChange
+	declared := make(map[string]bool, len(indexList))
+	for _, index := range indexList {
+		spec := declaredMongoSpec(index)
+		declared[spec.Keys] = true
+		current, ok := existing[spec.Keys]
+		if !ok {
+			missing = append(missing, index)
+			continue
+		}
+		spec.Name = current.Name
+		if current != spec {
+			changes = append(changes, indexChange{drift: IndexDrift{Table: tableName, Name: current.Name, Reason: "changed"}, index: &index})
+		}
+	}
+	for keys, current := range existing {
+		if !declared[keys] && current.managed() {
+			changes = append(changes, indexChange{drift: IndexDrift{Table: tableName, Name: current.Name, Reason: "undeclared"}})
+		}
+	}
+	return missing, changes
+}
+
+func mongoIndexColl(con *Con) (*mongo.Collection, error) {
+	coll, e := getColl(con)
+	if e != nil {
+		return nil, e
+	}
+	return coll.CloneCollection()
+}
+
+// migrateMongoIndexes creates the missing indexes and logs the drift of the existing ones,
+// changed and undeclared indexes are dropped (and recreated) when ${ domainx.index.reconcile } is set
+func migrateMongoIndexes(con *Con, tableName string, indexList []Index) error {
+	ctx := context.Background()
+	mColl, err := mongoIndexColl(con)
+	if err != nil {
+		return err
+	}
+	existing, err := listMongoIndexes(ctx, mColl)
+	if err != nil {
+		return err
+	}
+	reconcile := configure.GetBool("domainx.index.reconcile", false)
+	missing, changes := diffMongoIndexes(tableName, existing, indexList)
+	for _, index := range missing {
+		if err = createMongoIndex(ctx, mColl, index); err != nil {
+			return err
+		}
+	}
+	for _, change := range changes {
+		logIndexDrift(con, change.drift, reconcile)
+		if !reconcile {
+			continue
+		}
+		if _, err = mColl.Indexes().DropOne(ctx, change.drift.Name); err != nil {
+			return err
+		}
+		if change.index != nil {
+			if err = createMongoIndex(ctx, mColl, *change.index); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func logIndexDrift(con *Con, drift IndexDrift, reconcile bool) {
+	logger.Warn(con.Ctx, "index drift", zap.String("table", drift.Table), zap.String("index", drift.Name),
+		zap.String("reason", drift.Reason), zap.Bool("reconcile", reconcile))
+}
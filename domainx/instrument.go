@@ -0,0 +1,266 @@
+package domainx
+
+import (
+	"github.com/jom-io/gorig/apix/load"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/logger"
+	"go.uber.org/zap"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// instrumented wraps a DBService with the query metrics and the slow query log, see RegisterDBService.
+// Queries over ${ domainx.slowQuery } (500ms by default, 0 disables it) are logged as a warning with the trace ID,
+// ${ domainx.queryLog } logs every query with its normalized statement.
+type instrumented struct {
+	DBService
+	conType ConType
+}
+
+func instrument(conType ConType, s DBService) DBService {
+	if _, ok := s.(*instrumented); ok || s == nil {
+		return s
+	}
+	return &instrumented{DBService: s, conType: conType}
+}
+
+// observe records an operation on c started at start, rows < 0 means unknown
+func (s *instrumented) observe(c *Con, op string, start time.Time, rows int64, err error, matchList []Match) {
+	d := time.Since(start)
+	threshold := configure.GetDuration("domainx.slowQuery", 500*time.Millisecond)
+	slow := threshold > 0 && d >= threshold
+	failed := err != nil && !noRecord(err)
+	table := c.TableName()
+	observeQuery(queryKey{conType: s.conType, op: op, table: table}, d, rows, failed, slow)
+	if !slow && !configure.GetBool("domainx.queryLog", false) {
+		return
+	}
+	fields := []zap.Field{
+		zap.String("conType", s.conType.String()),
+		zap.String("table", table),
+		zap.String("statement", statement(c, op, matchList)),
+		zap.Duration("duration", d),
+		zap.Int64("rows", rows),
+	}
+	if failed {
+		fields = append(fields, zap.Error(err))
+	}
+	if slow {
+		logger.Warn(c.Ctx, "slow query", fields...)
+	} else {
+		logger.Info(c.Ctx, "query", fields...)
+	}
+}
+
+// statement renders the operation with the values of its matches replaced by ?
+func statement(c *Con, op string, matchList []Match) string {
+	var b strings.Builder
+	b.WriteString(op)
+	b.WriteString(" ")
+	b.WriteString(c.TableName())
+	for i, m := range matchList {
+		if i == 0 {
+			b.WriteString(" where ")
+		} else {
+			b.WriteString(" and ")
+		}
+		switch m.Type {
+		case Near:
+			near, _ := m.Value.(NearMatch)
+			b.WriteString("near(" + near.LatField + "," + near.LngField + ")")
+		case NearLoc:
+			near, _ := m.Value.(NearMatch)
+			b.WriteString("near(" + near.LatField + ")")
+		default:
+			b.WriteString(m.Field + " " + string(m.Type) + " ?")
+		}
+	}
+	if len(c.Sort) > 0 {
+		b.WriteString(" sort ")
+		for i, sort := range c.Sort {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			if !sort.Asc {
+				b.WriteString("-")
+			}
+			b.WriteString(sort.Field)
+		}
+	}
+	return b.String()
+}
+
+// resultRows is the length of a slice result, 1 for a single found record
+func resultRows(result interface{}, err error) int64 {
+	if err != nil {
+		return 0
+	}
+	v := reflect.ValueOf(result)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice {
+		return int64(v.Len())
+	}
+	return 1
+}
+
+func bulkRows(result *BulkResult) int64 {
+	if result == nil {
+		return 0
+	}
+	return result.Inserted + result.Updated + result.Upserted + result.Deleted
+}
+
+func (s *instrumented) GetByID(c *Con, id int64, result interface{}) error {
+	start := time.Now()
+	err := s.DBService.GetByID(c, id, result)
+	s.observe(c, "GetByID", start, resultRows(result, err), err, nil)
+	return err
+}
+
+func (s *instrumented) Save(c *Con, data Identifiable, newID int64, version ...int) (int64, error) {
+	start := time.Now()
+	id, err := s.DBService.Save(c, data, newID, version...)
+	s.observe(c, "Save", start, resultRows(data, err), err, nil)
+	return id, err
+}
+
+func (s *instrumented) UpdatePart(c *Con, id int64, data map[string]interface{}) (int64, error) {
+	start := time.Now()
+	rows, err := s.DBService.UpdatePart(c, id, data)
+	s.observe(c, "UpdatePart", start, rows, err, nil)
+	return rows, err
+}
+
+func (s *instrumented) UpdateByMatch(c *Con, matchList []Match, data map[string]interface{}) (int64, error) {
+	start := time.Now()
+	rows, err := s.DBService.UpdateByMatch(c, matchList, data)
+	s.observe(c, "UpdateByMatch", start, rows, err, matchList)
+	return rows, err
+}
+
+func (s *instrumented) Delete(c *Con, data Identifiable) (int64, error) {
+	start := time.Now()
+	rows, err := s.DBService.Delete(c, data)
+	s.observe(c, "Delete", start, rows, err, nil)
+	return rows, err
+}
+
+func (s *instrumented) DeleteByMatch(c *Con, matchList []Match) (int64, error) {
+	start := time.Now()
+	rows, err := s.DBService.DeleteByMatch(c, matchList)
+	s.observe(c, "DeleteByMatch", start, rows, err, matchList)
+	return rows, err
+}
+
+func (s *instrumented) FindByMatch(c *Con, matchList []Match, result interface{}, prefixes ...string) error {
+	start := time.Now()
+	err := s.DBService.FindByMatch(c, matchList, result, prefixes...)
+	s.observe(c, "FindByMatch", start, resultRows(result, err), err, matchList)
+	return err
+}
+
+func (s *instrumented) GetByMatch(c *Con, matchList []Match, result interface{}) error {
+	start := time.Now()
+	err := s.DBService.GetByMatch(c, matchList, result)
+	s.observe(c, "GetByMatch", start, resultRows(result, err), err, matchList)
+	return err
+}
+
+func (s *instrumented) FindOneAndUpdate(c *Con, matchList []Match, data map[string]interface{}, returnNew bool, result interface{}) error {
+	start := time.Now()
+	err := s.DBService.FindOneAndUpdate(c, matchList, data, returnNew, result)
+	s.observe(c, "FindOneAndUpdate", start, resultRows(result, err), err, matchList)
+	return err
+}
+
+func (s *instrumented) CountByMatch(c *Con, matchList []Match) (int64, error) {
+	start := time.Now()
+	count, err := s.DBService.CountByMatch(c, matchList)
+	s.observe(c, "CountByMatch", start, -1, err, matchList)
+	return count, err
+}
+
+func (s *instrumented) ExistsByMatch(c *Con, matchList []Match) (bool, error) {
+	start := time.Now()
+	exists, err := s.DBService.ExistsByMatch(c, matchList)
+	s.observe(c, "ExistsByMatch", start, -1, err, matchList)
+	return exists, err
+}
+
+func (s *instrumented) SumByMatch(c *Con, matchList []Match, field string) (float64, error) {
+	start := time.Now()
+	sum, err := s.DBService.SumByMatch(c, matchList, field)
+	s.observe(c, "SumByMatch", start, -1, err, matchList)
+	return sum, err
+}
+
+func (s *instrumented) FindByPageMatch(c *Con, matchList []Match, page *load.Page, total *load.Total, result interface{}, prefixes ...string) error {
+	start := time.Now()
+	err := s.DBService.FindByPageMatch(c, matchList, page, total, result, prefixes...)
+	s.observe(c, "FindByPageMatch", start, resultRows(result, err), err, matchList)
+	return err
+}
+
+func (s *instrumented) AggregateByMatch(c *Con, matchList []Match, agg *Aggregation, total *load.Total, result *[]*AggItem) error {
+	start := time.Now()
+	err := s.DBService.AggregateByMatch(c, matchList, agg, total, result)
+	s.observe(c, "AggregateByMatch", start, resultRows(result, err), err, matchList)
+	return err
+}
+
+// IterByMatch records the opening of the cursor, the rows are read later by the caller
+func (s *instrumented) IterByMatch(c *Con, matchList []Match, batchSize int, prefixes ...string) (RowCursor, error) {
+	start := time.Now()
+	cursor, err := s.DBService.IterByMatch(c, matchList, batchSize, prefixes...)
+	s.observe(c, "IterByMatch", start, -1, err, matchList)
+	return cursor, err
+}
+
+func (s *instrumented) SaveMany(c *Con, dataList []Identifiable, batchSize int, result *BulkResult) error {
+	start := time.Now()
+	err := s.DBService.SaveMany(c, dataList, batchSize, result)
+	s.observe(c, "SaveMany", start, bulkRows(result), err, nil)
+	return err
+}
+
+func (s *instrumented) UpsertBy(c *Con, dataList []Identifiable, fields []string, batchSize int, result *BulkResult) error {
+	start := time.Now()
+	err := s.DBService.UpsertBy(c, dataList, fields, batchSize, result)
+	s.observe(c, "UpsertBy", start, bulkRows(result), err, nil)
+	return err
+}
+
+func (s *instrumented) BulkWrite(c *Con, ops []BulkOp, result *BulkResult) error {
+	start := time.Now()
+	err := s.DBService.BulkWrite(c, ops, result)
+	s.observe(c, "BulkWrite", start, bulkRows(result), err, nil)
+	return err
+}
+
+// CursorValues reads the row in memory, it is recorded to keep the page cursor cost visible next to its query
+func (s *instrumented) CursorValues(c *Con, row interface{}) ([]interface{}, error) {
+	start := time.Now()
+	values, err := s.DBService.CursorValues(c, row)
+	s.observe(c, "CursorValues", start, -1, err, nil)
+	return values, err
+}
+
+func (s *instrumented) Tables(c *Con, prefix string) ([]string, error) {
+	start := time.Now()
+	tables, err := s.DBService.Tables(c, prefix)
+	s.observe(c, "Tables", start, int64(len(tables)), err, nil)
+	return tables, err
+}
+
+// DropTable is recorded under the dropped table rather than the table of c
+func (s *instrumented) DropTable(c *Con, table string) error {
+	start := time.Now()
+	err := s.DBService.DropTable(c, table)
+	dropped := *c
+	dropped.GTable = table
+	s.observe(&dropped, "DropTable", start, -1, err, nil)
+	return err
+}
@@ -0,0 +1,73 @@
+package domainx
+
+import (
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"iter"
+)
+
+// RowCursor reads the rows of a query from a server side cursor
+type RowCursor interface {
+	Next(result interface{}) bool
+	Err() error
+	Close() error
+}
+
+// DefIterBatchSize is the default number of rows fetched per round trip when iterating, ${ domainx.iter.batch }
+func DefIterBatchSize() int {
+	return configure.GetInt("domainx.iter.batch", 1000)
+}
+
+// IterByMatch streams the matched records on a database cursor, memory use does not grow with the result size.
+// The iteration stops with an error when the context of c is done.
+// batchSize is the mongo cursor batch size, mysql streams the rows of a single query and ignores it.
+func IterByMatch[T any](c *Con, matchList []Match, batchSize int, prefixes ...string) iter.Seq2[*T, error] {
+	return func(yield func(*T, error) bool) {
+		if c == nil {
+			yield(nil, errors.Sys("con not init"))
+			return
+		}
+		if c.Partition != nil {
+			yield(nil, c.partitionUnsupported("IterByMatch"))
+			return
+		}
+		if batchSize <= 0 {
+			batchSize = DefIterBatchSize()
+		}
+
+		matchList, sErr := c.scopeMatches(matchList)
+		if sErr != nil {
+			yield(nil, sErr)
+			return
+		}
+
+		dbService := GetDBService(c.GetConType())
+
+		cursor, gErr := dbService.IterByMatch(c, matchList, batchSize, prefixes...)
+		if gErr != nil {
+			if err := c.HandleWithErr(gErr); err != nil {
+				yield(nil, err)
+			}
+			return
+		}
+		defer cursor.Close()
+		for {
+			if ctxErr := c.Ctx.Err(); ctxErr != nil {
+				yield(nil, errors.Sys(c.TableName()+" iteration canceled", ctxErr))
+				return
+			}
+			row := new(T)
+			if !cursor.Next(row) {
+				break
+			}
+			if !yield(row, nil) {
+				return
+			}
+		}
+		if gErr = cursor.Err(); gErr != nil {
+			if err := c.HandleWithErr(gErr); err != nil {
+				yield(nil, err)
+			}
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package domainx
 
 import (
+	"fmt"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/geo"
 	"github.com/spf13/cast"
 	"regexp"
 	"strings"
@@ -25,6 +28,15 @@ const (
 	Near    MatchType = "near"
 	NearLoc MatchType = "nearloc"
 	MNEmpty MatchType = "not empty"
+	// MText is a full-text search on a mongo text index or a mysql FULLTEXT index, the results are sorted by relevance
+	MText MatchType = "text"
+	// MWithinPolygon, MWithinBox and MIntersects match a mongo GeoJSON field, a mysql spatial column
+	// or mysql "lat,lng" columns against a shape, see Matches.WithinPolygon
+	MWithinPolygon MatchType = "within polygon"
+	MWithinBox     MatchType = "within box"
+	MIntersects    MatchType = "intersects"
+	// MInvalid is a match rejected when it was built, its Value is the error returned by the query, see Matches.Fail
+	MInvalid MatchType = "invalid"
 )
 
 func Check(s string) bool {
@@ -177,6 +189,49 @@ func (m *Matches) HasAll(field string, value interface{}, ignore ...bool) *Match
 	return m.Add(field, value, MHasAll, ignore...)
 }
 
+// Text searches query in fields, a comma separated list for mysql, the fields of the text index are searched on mongo.
+// The query is in the boolean mode syntax of mysql or the $search syntax of mongo.
+func (m *Matches) Text(query string, fields ...string) *Matches {
+	return m.Add(strings.Join(fields, ","), query, MText)
+}
+
+// WithinPolygon matches the locations inside polygon. field is a GeoJSON field on mongo, a spatial column on mysql
+// or its "lat,lng" columns, which are prefiltered by the bounding box of the polygon.
+func (m *Matches) WithinPolygon(field string, polygon []geo.Point) *Matches {
+	if len(polygon) < 3 {
+		return m
+	}
+	return m.AddMatch(&Match{Field: field, Value: polygon, Type: MWithinPolygon})
+}
+
+// WithinBox matches the locations inside box, see WithinPolygon for field
+func (m *Matches) WithinBox(field string, box geo.Box) *Matches {
+	return m.AddMatch(&Match{Field: field, Value: box, Type: MWithinBox})
+}
+
+// Intersects matches the stored shapes that intersect the point or polygon shape, e.g. the delivery zones of an address.
+// The shape is one point or a polygon of at least 3 points, two points fail the query.
+func (m *Matches) Intersects(field string, shape ...geo.Point) *Matches {
+	if len(shape) == 0 {
+		return m
+	}
+	if len(shape) == 2 {
+		return m.Fail(errors.Verify(fmt.Sprintf("intersects %s needs one point or a polygon of at least 3 points", field)))
+	}
+	return m.AddMatch(&Match{Field: field, Value: shape, Type: MIntersects})
+}
+
+// GeoShape is the shape of a MWithinPolygon, MWithinBox or MIntersects match as points
+func (h *Match) GeoShape() []geo.Point {
+	switch v := h.Value.(type) {
+	case []geo.Point:
+		return v
+	case geo.Box:
+		return v.Polygon()
+	}
+	return nil
+}
+
 func (m *Matches) NEmpty(field string) *Matches {
 	return m.Add(field, "", MNEmpty, true)
 }
@@ -207,6 +262,24 @@ func (m *Matches) AddMatch(match *Match) *Matches {
 	return m
 }
 
+// Fail records err on the matches, the query run with them returns it instead of reading or writing
+func (m *Matches) Fail(err *errors.Error) *Matches {
+	return m.AddMatch(&Match{Value: err, Type: MInvalid})
+}
+
+// invalidMatch returns the error recorded by Fail
+func invalidMatch(matchList []Match) *errors.Error {
+	for _, match := range matchList {
+		if match.Type == MInvalid {
+			if err, ok := match.Value.(*errors.Error); ok && err != nil {
+				return err
+			}
+			return errors.Verify("invalid match")
+		}
+	}
+	return nil
+}
+
 func (m *Matches) AddMatches(matches *Matches) *Matches {
 	if matches == nil {
 		return m
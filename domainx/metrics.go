@@ -0,0 +1,141 @@
+package domainx
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queryBuckets are the upper bounds in milliseconds of the query duration histogram
+var queryBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+type queryKey struct {
+	conType ConType
+	op      string
+	table   string
+}
+
+// QueryMetric is the snapshot of the counters and the duration histogram of one DBService operation on a table
+type QueryMetric struct {
+	ConType ConType   `json:"conType"`
+	Op      string    `json:"op"`
+	Table   string    `json:"table"`
+	Count   int64     `json:"count"`
+	Errors  int64     `json:"errors"`
+	Slow    int64     `json:"slow"`
+	Rows    int64     `json:"rows"`
+	SumMs   float64   `json:"sumMs"`
+	Buckets []float64 `json:"buckets"` // upper bounds in milliseconds
+	Counts  []int64   `json:"counts"`  // cumulative count of each bucket, the last one is +Inf
+}
+
+type queryMetric struct {
+	mu     sync.Mutex
+	count  int64
+	errors int64
+	slow   int64
+	rows   int64
+	sumMs  float64
+	counts []int64
+}
+
+var queryMetrics sync.Map // queryKey -> *queryMetric
+
+func observeQuery(key queryKey, d time.Duration, rows int64, failed, slow bool) {
+	v, ok := queryMetrics.Load(key)
+	if !ok {
+		v, _ = queryMetrics.LoadOrStore(key, &queryMetric{counts: make([]int64, len(queryBuckets)+1)})
+	}
+	m := v.(*queryMetric)
+	ms := float64(d) / float64(time.Millisecond)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.count++
+	m.sumMs += ms
+	if rows > 0 {
+		m.rows += rows
+	}
+	if failed {
+		m.errors++
+	}
+	if slow {
+		m.slow++
+	}
+	i := sort.SearchFloat64s(queryBuckets, ms)
+	m.counts[i]++
+}
+
+// QueryMetrics returns the metrics of the DBService operations since start, sorted by db type, table and operation
+func QueryMetrics() []QueryMetric {
+	var list []QueryMetric
+	queryMetrics.Range(func(k, v any) bool {
+		key, m := k.(queryKey), v.(*queryMetric)
+		m.mu.Lock()
+		q := QueryMetric{ConType: key.conType, Op: key.op, Table: key.table, Count: m.count, Errors: m.errors,
+			Slow: m.slow, Rows: m.rows, SumMs: m.sumMs, Buckets: queryBuckets, Counts: make([]int64, len(m.counts))}
+		var total int64
+		for i, c := range m.counts {
+			total += c
+			q.Counts[i] = total
+		}
+		m.mu.Unlock()
+		list = append(list, q)
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.ConType != b.ConType {
+			return a.ConType < b.ConType
+		}
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		return a.Op < b.Op
+	})
+	return list
+}
+
+// ResetQueryMetrics clears the metrics, e.g. between the runs of a benchmark
+func ResetQueryMetrics() {
+	queryMetrics.Range(func(k, _ any) bool {
+		queryMetrics.Delete(k)
+		return true
+	})
+}
+
+// WriteQueryMetrics writes the metrics in the prometheus text format
+func WriteQueryMetrics(w io.Writer) error {
+	var b strings.Builder
+	list := QueryMetrics()
+	counter := func(name, help string, value func(QueryMetric) int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, q := range list {
+			fmt.Fprintf(&b, "%s{%s} %d\n", name, q.labels(), value(q))
+		}
+	}
+	counter("domainx_queries_total", "DBService operations.", func(q QueryMetric) int64 { return q.Count })
+	counter("domainx_query_errors_total", "Failed DBService operations.", func(q QueryMetric) int64 { return q.Errors })
+	counter("domainx_slow_queries_total", "DBService operations over the slow query threshold.", func(q QueryMetric) int64 { return q.Slow })
+	counter("domainx_query_rows_total", "Rows returned or affected by DBService operations.", func(q QueryMetric) int64 { return q.Rows })
+
+	name := "domainx_query_duration_ms"
+	fmt.Fprintf(&b, "# HELP %s Duration of DBService operations in milliseconds.\n# TYPE %s histogram\n", name, name)
+	for _, q := range list {
+		labels := q.labels()
+		for i, le := range q.Buckets {
+			fmt.Fprintf(&b, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, le, q.Counts[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, q.Counts[len(q.Counts)-1])
+		fmt.Fprintf(&b, "%s_sum{%s} %g\n", name, labels, q.SumMs)
+		fmt.Fprintf(&b, "%s_count{%s} %d\n", name, labels, q.Count)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (q QueryMetric) labels() string {
+	return fmt.Sprintf("con_type=%q,table=%q,op=%q", string(q.ConType), q.Table, q.Op)
+}
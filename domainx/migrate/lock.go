@@ -0,0 +1,152 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig/domainx"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+	"time"
+)
+
+const lockName = "migrate"
+
+// Lock is the lease of the instance migrating a database, claimed with its version
+type Lock struct {
+	Name  string    `gorm:"column:name;type:varchar(64)" bson:"name" json:"name"`
+	Owner string    `gorm:"column:owner;type:varchar(64)" bson:"owner" json:"owner"`
+	Until time.Time `gorm:"column:until" bson:"until" json:"until"`
+}
+
+func lockComplex(ctx context.Context, db database) *domainx.Complex[Lock] {
+	return domainx.CreateComplex[Lock](ctx, db.conType, db.dbName, HistoryTable()+"_lock", nil)
+}
+
+// acquire waits up to ${ domainx.migrate.lockWait } for the migration lock of the database.
+// The lock expires after ${ domainx.migrate.lockTTL } if its owner died while migrating, it is renewed every third of it until released.
+// The returned ctx is canceled when the lease is lost, the migrations must run under it.
+func acquire(ctx context.Context, db database) (context.Context, func(), *errors.Error) {
+	lock := lockComplex(ctx, db)
+	owner := xid.New().String()
+	ttl := configure.GetDuration("domainx.migrate.lockTTL", 10*time.Minute)
+	deadline := time.Now().Add(configure.GetDuration("domainx.migrate.lockWait", 2*time.Minute))
+	for {
+		ok, err := tryLock(lock, owner, ttl)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			leaseCtx, cancel := context.WithCancel(ctx)
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				renew(lock, owner, ttl, stop, cancel)
+			}()
+			return leaseCtx, func() {
+				close(stop)
+				<-done
+				cancel()
+				release(lock, owner)
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, errors.Sys(fmt.Sprintf("migration lock of %s is held by another instance", db.dbName))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, errors.Sys("migration lock wait canceled", ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func findLock(lock *domainx.Complex[Lock]) (*domainx.Complex[Lock], *errors.Error) {
+	var list []*domainx.Complex[Lock]
+	if err := domainx.FindByMatch(lock.Con, *domainx.NewMatches().Eq("name", lockName), &list); err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return list[0], nil
+}
+
+func tryLock(lock *domainx.Complex[Lock], owner string, ttl time.Duration) (bool, *errors.Error) {
+	now := time.Now()
+	row, err := findLock(lock)
+	if err != nil {
+		return false, err
+	}
+	if row == nil {
+		row = lock.Derive(&Lock{Name: lockName, Owner: owner, Until: now.Add(ttl)})
+		if _, sErr := domainx.Save(row.Con, row); sErr != nil {
+			// another instance created the lock first, the name is unique
+			if existing, fErr := findLock(lock); fErr != nil || existing == nil {
+				return false, sErr
+			}
+			return false, nil
+		}
+		return true, nil
+	}
+	if row.Data.Owner != "" && row.Data.Until.After(now) {
+		return false, nil
+	}
+	row.Con.SetExpectVersion(row.Version)
+	err = domainx.UpdatePart(row.Con, row.GetID().Int64(), map[string]interface{}{
+		"owner": owner,
+		"until": now.Add(ttl),
+	})
+	if err != nil {
+		if domainx.IsConflict(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// renew extends the lease of owner until stop is closed, lost cancels the migration when the lease cannot be renewed
+func renew(lock *domainx.Complex[Lock], owner string, ttl time.Duration, stop <-chan struct{}, lost context.CancelFunc) {
+	con := lock.Derive(new(Lock)).Con
+	ticker := time.NewTicker(max(ttl/3, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := renewLease(lock, con, owner, ttl); err != nil {
+			logger.Error(con.Ctx, "migration lock lost, canceling the migration", zap.String("table", lock.TableName()), zap.Error(err))
+			lost()
+			return
+		}
+	}
+}
+
+// renewLease extends the lease and checks that owner still holds it
+func renewLease(lock *domainx.Complex[Lock], con *domainx.Con, owner string, ttl time.Duration) *errors.Error {
+	matches := domainx.NewMatches().Eq("name", lockName).Eq("owner", owner)
+	if err := domainx.UpdateByMatch(con, *matches, map[string]interface{}{"until": time.Now().Add(ttl)}); err != nil {
+		return err
+	}
+	row, err := findLock(lock)
+	if err != nil {
+		return err
+	}
+	if row == nil || row.Data.Owner != owner {
+		return errors.Sys(fmt.Sprintf("migration lock of %s is held by another instance", lock.TableName()))
+	}
+	return nil
+}
+
+func release(lock *domainx.Complex[Lock], owner string) {
+	matches := domainx.NewMatches().Eq("name", lockName).Eq("owner", owner)
+	if err := domainx.UpdateByMatch(lock.Con, *matches, map[string]interface{}{"owner": "", "until": time.Now()}); err != nil {
+		logger.Error(lock.Con.Ctx, "migration lock release failed", zap.String("table", lock.TableName()), zap.Error(err))
+	}
+}
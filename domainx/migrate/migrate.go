@@ -0,0 +1,299 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig/domainx"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/sys"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Migration is one versioned change of a database, pending migrations are applied in ascending Version order.
+// Up and Down receive a con of the database, use con.MysqlDB or con.MongoDB for schema and data changes.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(ctx context.Context, con *domainx.Con) error
+	Down    func(ctx context.Context, con *domainx.Con) error
+}
+
+// History is the record of an applied migration
+type History struct {
+	Migration  int64     `gorm:"column:migration" bson:"migration" json:"migration"`
+	Name       string    `gorm:"column:name;type:varchar(128)" bson:"name" json:"name"`
+	AppliedAt  time.Time `gorm:"column:applied_at" bson:"applied_at" json:"appliedAt"`
+	DurationMs int64     `gorm:"column:duration_ms" bson:"duration_ms" json:"durationMs"`
+}
+
+// State is the state of a migration in a database, Missing marks an applied migration that is no longer registered
+type State struct {
+	ConType   domainx.ConType `json:"conType"`
+	DBName    string          `json:"dbName"`
+	Version   int64           `json:"version"`
+	Name      string          `json:"name"`
+	Applied   bool            `json:"applied"`
+	AppliedAt *time.Time      `json:"appliedAt,omitempty"`
+	Missing   bool            `json:"missing,omitempty"`
+}
+
+type database struct {
+	conType domainx.ConType
+	dbName  string
+}
+
+var (
+	mu         sync.Mutex
+	registered = make(map[database][]Migration)
+)
+
+// Register adds the migrations of a database, call it from an init function
+func Register(conType domainx.ConType, dbName string, migrations ...Migration) {
+	mu.Lock()
+	defer mu.Unlock()
+	db := database{conType: conType, dbName: dbName}
+	list := registered[db]
+	for _, m := range migrations {
+		if m.Version <= 0 || m.Up == nil {
+			sys.Exit(errors.Sys(fmt.Sprintf("migration %d %s of %s needs a positive version and an Up function", m.Version, m.Name, dbName)))
+		}
+		for _, r := range list {
+			if r.Version == m.Version {
+				sys.Exit(errors.Sys(fmt.Sprintf("migration version %d of %s is registered twice", m.Version, dbName)))
+			}
+		}
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	registered[db] = list
+}
+
+// HistoryTable is the table or collection of the applied migrations, ${ domainx.migrate.table }
+func HistoryTable() string {
+	return configure.GetString("domainx.migrate.table", "schema_migration")
+}
+
+func historyComplex(ctx context.Context, db database) *domainx.Complex[History] {
+	return domainx.CreateComplex[History](ctx, db.conType, db.dbName, HistoryTable(), nil)
+}
+
+func databases() []database {
+	mu.Lock()
+	defer mu.Unlock()
+	list := make([]database, 0, len(registered))
+	for db := range registered {
+		list = append(list, db)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].conType != list[j].conType {
+			return list[i].conType < list[j].conType
+		}
+		return list[i].dbName < list[j].dbName
+	})
+	return list
+}
+
+func migrations(db database) []Migration {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Migration(nil), registered[db]...)
+}
+
+// prepare creates the history and lock tables of the database
+func prepare(ctx context.Context, db database) (*domainx.Complex[History], *errors.Error) {
+	history := historyComplex(ctx, db)
+	if history.Con == nil {
+		return nil, errors.Sys(fmt.Sprintf("migration db %s not init", db.dbName))
+	}
+	dbService := domainx.GetDBService(db.conType)
+	if err := dbService.Migrate(history.Con, history.TableName(), history, []domainx.Index{domainx.CtIdx(domainx.Idx, "migration")}); err != nil {
+		return nil, errors.Sys(fmt.Sprintf("migration history table of %s failed", db.dbName), err)
+	}
+	lock := lockComplex(ctx, db)
+	if err := dbService.Migrate(lock.Con, lock.TableName(), lock, []domainx.Index{domainx.CtIdx(domainx.Unique, "name")}); err != nil {
+		return nil, errors.Sys(fmt.Sprintf("migration lock table of %s failed", db.dbName), err)
+	}
+	return history, nil
+}
+
+func applied(history *domainx.Complex[History]) (map[int64]*domainx.Complex[History], *errors.Error) {
+	var list []*domainx.Complex[History]
+	if err := domainx.FindByMatch(history.Con, *domainx.NewMatches(), &list); err != nil {
+		return nil, err
+	}
+	result := make(map[int64]*domainx.Complex[History], len(list))
+	for _, h := range list {
+		result[h.Data.Migration] = h
+	}
+	return result, nil
+}
+
+func statusOf(ctx context.Context, db database) ([]State, *errors.Error) {
+	history, err := prepare(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	done, err := applied(history)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]State, 0, len(done))
+	for _, m := range migrations(db) {
+		s := State{ConType: db.conType, DBName: db.dbName, Version: m.Version, Name: m.Name}
+		if h, ok := done[m.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = &h.Data.AppliedAt
+			delete(done, m.Version)
+		}
+		list = append(list, s)
+	}
+	for v, h := range done {
+		list = append(list, State{ConType: db.conType, DBName: db.dbName, Version: v, Name: h.Data.Name, Applied: true, AppliedAt: &h.Data.AppliedAt, Missing: true})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list, nil
+}
+
+// Status reports every registered or applied migration of all databases
+func Status(ctx context.Context) ([]State, *errors.Error) {
+	var result []State
+	for _, db := range databases() {
+		list, err := statusOf(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, list...)
+	}
+	return result, nil
+}
+
+// DryRun reports the migrations Up would apply, without applying them
+func DryRun(ctx context.Context) ([]State, *errors.Error) {
+	list, err := Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]State, 0, len(list))
+	for _, s := range list {
+		if !s.Applied {
+			sys.Info(" * Migration pending: ", s.ConType, " ", s.DBName, " ", s.Version, " ", s.Name)
+			pending = append(pending, s)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations of all databases under the migration lock of each database,
+// returns the applied migrations, also when a migration failed
+func Up(ctx context.Context) ([]State, *errors.Error) {
+	var result []State
+	for _, db := range databases() {
+		list, err := up(ctx, db)
+		result = append(result, list...)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func up(ctx context.Context, db database) ([]State, *errors.Error) {
+	history, err := prepare(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	ctx, release, err := acquire(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	done, err := applied(history)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []State
+	for _, m := range migrations(db) {
+		if _, ok := done[m.Version]; ok {
+			continue
+		}
+		if ctx.Err() != nil {
+			return result, errors.Sys(fmt.Sprintf("migration lock of %s lost", db.dbName), ctx.Err())
+		}
+		con := domainx.UseCon(ctx, db.conType, db.dbName, "")
+		if con == nil {
+			return result, errors.Sys(fmt.Sprintf("migration db %s not init", db.dbName))
+		}
+		sys.Info(" * Migrate up: ", db.conType, " ", db.dbName, " ", m.Version, " ", m.Name)
+		start := time.Now()
+		if mErr := m.Up(ctx, con); mErr != nil {
+			return result, errors.Sys(fmt.Sprintf("migration %d %s of %s failed", m.Version, m.Name, db.dbName), mErr)
+		}
+		record := history.Derive(&History{Migration: m.Version, Name: m.Name, AppliedAt: time.Now(), DurationMs: time.Since(start).Milliseconds()})
+		if _, sErr := domainx.Save(record.Con, record); sErr != nil {
+			return result, sErr
+		}
+		result = append(result, State{ConType: db.conType, DBName: db.dbName, Version: m.Version, Name: m.Name, Applied: true, AppliedAt: &record.Data.AppliedAt})
+	}
+	return result, nil
+}
+
+// Down rolls back the last steps applied migrations of a database, newest first, returns the rolled back migrations
+func Down(ctx context.Context, conType domainx.ConType, dbName string, steps int) ([]State, *errors.Error) {
+	db := database{conType: conType, dbName: dbName}
+	history, err := prepare(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	ctx, release, err := acquire(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	done, err := applied(history)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int64, 0, len(done))
+	for v := range done {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+	byVersion := make(map[int64]Migration)
+	for _, m := range migrations(db) {
+		byVersion[m.Version] = m
+	}
+
+	var result []State
+	for i := 0; i < steps && i < len(versions); i++ {
+		m, ok := byVersion[versions[i]]
+		if !ok || m.Down == nil {
+			return result, errors.Sys(fmt.Sprintf("migration %d of %s cannot be rolled back, no Down function", versions[i], dbName))
+		}
+		if ctx.Err() != nil {
+			return result, errors.Sys(fmt.Sprintf("migration lock of %s lost", dbName), ctx.Err())
+		}
+		con := domainx.UseCon(ctx, conType, dbName, "")
+		if con == nil {
+			return result, errors.Sys(fmt.Sprintf("migration db %s not init", dbName))
+		}
+		sys.Info(" * Migrate down: ", conType, " ", dbName, " ", m.Version, " ", m.Name)
+		if mErr := m.Down(ctx, con); mErr != nil {
+			return result, errors.Sys(fmt.Sprintf("migration %d %s of %s rollback failed", m.Version, m.Name, dbName), mErr)
+		}
+		if dErr := domainx.DeleteByMatch(history.Con, *domainx.NewMatches().Eq("migration", m.Version)); dErr != nil {
+			return result, dErr
+		}
+		result = append(result, State{ConType: conType, DBName: dbName, Version: m.Version, Name: m.Name})
+	}
+	return result, nil
+}
@@ -1,17 +1,124 @@
 package domainx
 
+import (
+	"context"
+	"strings"
+	"time"
+)
+
 type IdxType string
 
 const (
 	Unique    IdxType = "unique"
 	Idx       IdxType = "idx"
 	Spatial2D IdxType = "2d"
+	// TTL removes the documents once the time in the field is older than Index.TTL, mongo only
+	TTL IdxType = "ttl"
+	// Text is a mongo text index or a mysql FULLTEXT index
+	Text IdxType = "text"
 )
 
+var idxTypes = []IdxType{Unique, Idx, Spatial2D, TTL, Text}
+
 type Index struct {
 	IdxType IdxType
 	Fields  []string
 	IdxName string
+	TTL     time.Duration // expireAfter of a TTL index
+	Sparse  bool          // skips the documents without the fields, mongo only
+	Filter  []Match       // partial index condition, mongo only
+}
+
+// Desc marks a field of CtIdx as descending, e.g. CtIdx(Idx, "user_id", Desc("created_at"))
+func Desc(field string) string {
+	return "-" + field
+}
+
+func (i Index) WithTTL(ttl time.Duration) Index {
+	i.TTL = ttl
+	return i
+}
+
+func (i Index) WithSparse() Index {
+	i.Sparse = true
+	return i
+}
+
+// WithFilter makes a partial index of the records matching matches
+func (i Index) WithFilter(matches *Matches) Index {
+	if matches != nil {
+		i.Filter = *matches
+	}
+	return i
+}
+
+// indexField returns the field name and whether it is descending
+func indexField(field string) (string, bool) {
+	if strings.HasPrefix(field, "-") {
+		return field[1:], true
+	}
+	return field, false
+}
+
+// managedIndex reports whether an index name was built by CtIdx
+func managedIndex(name string) bool {
+	for _, t := range idxTypes {
+		if strings.HasPrefix(name, string(t)+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// IndexDrift is a difference between the declared indexes and the indexes of a table
+type IndexDrift struct {
+	Table  string
+	Name   string
+	Reason string // changed, undeclared or missing
+}
+
+// indexChange is a drift fixed by the reconcile, index is the declared index recreated after the drop, nil for an undeclared one
+type indexChange struct {
+	drift IndexDrift
+	index *Index
+}
+
+// CheckIndexes compares the declared indexes with the indexes of the table without changing them
+func CheckIndexes(con *Con, tableName string, indexList []Index) ([]IndexDrift, error) {
+	var missing []Index
+	var changes []indexChange
+	switch con.GetConType() {
+	case Mysql:
+		existing, err := listMysqlIndexes(con.MysqlDB, tableName)
+		if err != nil {
+			return nil, err
+		}
+		missing, changes = diffMysqlIndexes(tableName, existing, indexList)
+	case Mongo:
+		mColl, err := mongoIndexColl(con)
+		if err != nil {
+			return nil, err
+		}
+		ctx := con.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		existing, err := listMongoIndexes(ctx, mColl)
+		if err != nil {
+			return nil, err
+		}
+		missing, changes = diffMongoIndexes(tableName, existing, indexList)
+	default:
+		return nil, unknownDBType()
+	}
+	drifts := make([]IndexDrift, 0, len(missing)+len(changes))
+	for _, index := range missing {
+		drifts = append(drifts, IndexDrift{Table: tableName, Name: index.IdxName, Reason: "missing"})
+	}
+	for _, change := range changes {
+		drifts = append(drifts, change.drift)
+	}
+	return drifts, nil
 }
 
 var MigrationList []*Migration
@@ -0,0 +1,83 @@
+package domainx
+
+import (
+	"context"
+	"encoding/json"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"time"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending    OutboxStatus = "pending"
+	OutboxProcessing OutboxStatus = "processing"
+	OutboxDispatched OutboxStatus = "dispatched"
+	OutboxFailed     OutboxStatus = "failed"
+)
+
+// OutboxEvent is a domain event written with the entity and published later by the outbox relay
+type OutboxEvent struct {
+	Topic        string                 `gorm:"column:topic;type:varchar(128)" bson:"topic" json:"topic"`
+	Content      map[string]interface{} `gorm:"column:content;type:json;serializer:json" bson:"content" json:"content"`
+	Entity       string                 `gorm:"column:entity;type:varchar(128)" bson:"entity" json:"entity"`
+	EntityID     int64                  `gorm:"column:entity_id" bson:"entity_id" json:"entityID"`
+	TraceID      string                 `gorm:"column:trace_id;type:varchar(64)" bson:"trace_id" json:"traceID"`
+	Status       OutboxStatus           `gorm:"column:status;type:varchar(16)" bson:"status" json:"status"`
+	Attempts     int                    `gorm:"column:attempts" bson:"attempts" json:"attempts"`
+	NextAt       time.Time              `gorm:"column:next_at" bson:"next_at" json:"nextAt"`
+	LastError    string                 `gorm:"column:last_error;type:varchar(512)" bson:"last_error" json:"lastError"`
+	DispatchedAt *time.Time             `gorm:"column:dispatched_at" bson:"dispatched_at" json:"dispatchedAt"`
+}
+
+// OutboxTable is the table or collection of the outbox events, ${ domainx.outbox.table }
+func OutboxTable() string {
+	return configure.GetString("domainx.outbox.table", "outbox_event")
+}
+
+func OutboxComplex(ctx context.Context, conType ConType, dbName string) *Complex[OutboxEvent] {
+	return CreateComplex[OutboxEvent](ctx, conType, dbName, OutboxTable(), nil)
+}
+
+// AutoMigrateOutbox registers the migration of the outbox table in the database of the entities
+func AutoMigrateOutbox(conType ConType, dbName string) {
+	AutoMigrate(func() ConTable {
+		return OutboxComplex(context.Background(), conType, dbName)
+	}, CtIdx(Idx, "status", "next_at"))
+}
+
+// Emit writes an event of the entity of c into the outbox of its database.
+// Run it with the ctx of a Transaction to commit the event together with the entity.
+func Emit(c *Con, topic string, content interface{}) *errors.Error {
+	if c == nil {
+		return errors.Sys("con not init")
+	}
+	if topic == "" {
+		return errors.Sys("outbox topic cannot be empty")
+	}
+	b, err := json.Marshal(content)
+	if err != nil {
+		return errors.Sys("outbox content marshal failed", err)
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return errors.Sys("outbox content must be an object", err)
+	}
+	outbox := OutboxComplex(c.Ctx, c.GetConType(), c.DBName)
+	if outbox.Con == nil {
+		return errors.Sys("outbox con not init")
+	}
+	event := outbox.Derive(&OutboxEvent{
+		Topic:    topic,
+		Content:  m,
+		Entity:   c.TableName(),
+		EntityID: c.ID,
+		TraceID:  logger.GetTraceID(c.Ctx),
+		Status:   OutboxPending,
+		NextAt:   time.Now(),
+	})
+	_, sErr := Save(event.Con, event)
+	return sErr
+}
@@ -0,0 +1,210 @@
+package outbox
+
+import (
+	"context"
+	"github.com/jom-io/gorig/apix/load"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/mid/messagex"
+	"github.com/jom-io/gorig/serv"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/jom-io/gorig/utils/sys"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const ServiceCode = "outbox"
+
+// Relay publishes the pending outbox events of one database to a messagex broker.
+// An event is claimed with its version before publishing, so several instances can run the same relay.
+type Relay struct {
+	ConType     domainx.ConType
+	DBName      string
+	Broker      messagex.BrokerType
+	Interval    time.Duration // poll interval and base of the retry backoff
+	BatchSize   int64
+	MaxAttempts int           // an event is marked failed after MaxAttempts failed publishes
+	Lease       time.Duration // a claimed event is retried after Lease if the relay died while publishing
+}
+
+var (
+	relays []*Relay
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+)
+
+func init() {
+	if err := serv.RegisterService(
+		serv.Service{
+			Code:     ServiceCode,
+			Startup:  start,
+			Shutdown: stop,
+		},
+	); err != nil {
+		sys.Exit(err)
+	}
+}
+
+// AddRelay publishes the outbox of dbName to broker once the service is started, defaults are read from ${ domainx.outbox.* }
+func AddRelay(conType domainx.ConType, dbName string, broker messagex.BrokerType) *Relay {
+	r := &Relay{
+		ConType:     conType,
+		DBName:      dbName,
+		Broker:      broker,
+		Interval:    configure.GetDuration("domainx.outbox.interval", time.Second),
+		BatchSize:   int64(configure.GetInt("domainx.outbox.batch", 100)),
+		MaxAttempts: configure.GetInt("domainx.outbox.maxAttempts", 10),
+		Lease:       configure.GetDuration("domainx.outbox.lease", time.Minute),
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	relays = append(relays, r)
+	return r
+}
+
+func start(code, port string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+	for _, r := range relays {
+		wg.Add(1)
+		go func(r *Relay) {
+			defer wg.Done()
+			r.run(ctx)
+		}(r)
+	}
+	return nil
+}
+
+func stop(code string, ctx context.Context) error {
+	mu.Lock()
+	if cancel != nil {
+		cancel()
+	}
+	mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	sys.Info(" * Outbox relay shutdown")
+	return nil
+}
+
+func (r *Relay) run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Dispatch(ctx); err != nil {
+				logger.Error(ctx, "outbox dispatch failed", zap.String("db", r.DBName), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Dispatch publishes one batch of due events and returns the number of published events
+func (r *Relay) Dispatch(ctx context.Context) (int, *errors.Error) {
+	outbox := domainx.OutboxComplex(ctx, r.ConType, r.DBName)
+	if outbox.Con == nil {
+		return 0, errors.Sys("outbox con not init")
+	}
+	outbox.Sort.AddSort("next_at", true)
+	matches := domainx.NewMatches().
+		In("status", []domainx.OutboxStatus{domainx.OutboxPending, domainx.OutboxProcessing}).
+		Lte("next_at", time.Now())
+	resp := &load.PageRespT[*domainx.Complex[domainx.OutboxEvent]]{Result: &[]*domainx.Complex[domainx.OutboxEvent]{}}
+	if err := domainx.FindByPageMatchT(outbox.Con, *matches, &load.Page{Page: 1, Size: r.BatchSize}, resp, resp.Result); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, event := range *resp.Result {
+		if ctx.Err() != nil {
+			break
+		}
+		if !r.claim(outbox.Con, event) {
+			continue
+		}
+		if r.publish(ctx, outbox.Con, event) {
+			published++
+		}
+	}
+	return published, nil
+}
+
+// claim marks the event as processing, false if another relay claimed it first
+func (r *Relay) claim(c *domainx.Con, event *domainx.Complex[domainx.OutboxEvent]) bool {
+	c.SetExpectVersion(event.Version)
+	defer c.SetExpectVersion(0)
+	err := domainx.UpdatePart(c, event.GetID().Int64(), map[string]interface{}{
+		"status":  domainx.OutboxProcessing,
+		"next_at": time.Now().Add(r.Lease),
+	})
+	if err != nil && !domainx.IsConflict(err) {
+		logger.Error(c.Ctx, "outbox claim failed", zap.Int64("id", event.GetID().Int64()), zap.Error(err))
+	}
+	return err == nil
+}
+
+func (r *Relay) publish(ctx context.Context, c *domainx.Con, event *domainx.Complex[domainx.OutboxEvent]) bool {
+	id := event.GetID().Int64()
+	msg := &messagex.Message{
+		Ctx:     ctx,
+		ID:      cast.ToString(id),
+		GroupID: event.Data.TraceID,
+		Topic:   event.Data.Topic,
+		Content: event.Data.Content,
+	}
+	if pErr := messagex.Ins(r.Broker).Publish(ctx, event.Data.Topic, msg); pErr != nil {
+		attempts := event.Data.Attempts + 1
+		status := domainx.OutboxPending
+		if attempts >= r.MaxAttempts {
+			status = domainx.OutboxFailed
+		}
+		lastError := pErr.Error()
+		if len(lastError) > 512 {
+			lastError = lastError[:512]
+		}
+		if err := domainx.UpdatePart(c, id, map[string]interface{}{
+			"status":     status,
+			"attempts":   attempts,
+			"next_at":    time.Now().Add(r.backoff(attempts)),
+			"last_error": lastError,
+		}); err != nil {
+			logger.Error(ctx, "outbox retry update failed", zap.Int64("id", id), zap.Error(err))
+		}
+		return false
+	}
+	if err := domainx.UpdatePart(c, id, map[string]interface{}{
+		"status":        domainx.OutboxDispatched,
+		"dispatched_at": time.Now(),
+	}); err != nil {
+		logger.Error(ctx, "outbox dispatched update failed", zap.Int64("id", id), zap.Error(err))
+	}
+	return true
+}
+
+// backoff doubles the interval per attempt, capped at 10 minutes
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.Interval
+	for i := 1; i < attempts && d < 10*time.Minute; i++ {
+		d *= 2
+	}
+	if d > 10*time.Minute {
+		d = 10 * time.Minute
+	}
+	return d
+}
@@ -0,0 +1,604 @@
+package domainx
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig/apix/load"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/spf13/cast"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Period is the time span of one table of a Partition
+type Period string
+
+const (
+	Daily   Period = "daily"
+	Monthly Period = "monthly"
+	Yearly  Period = "yearly"
+)
+
+// Partition splits a table by the time in Field into one physical table per Period in the local time zone,
+// named <table>_<suffix>, e.g. order_events_20260102, order_events_202601 or order_events_2026.
+// Save routes a record by Field (now if unset), reads and writes fan out to the tables of the range matched on Field,
+// to all tables without a range. Aggregations, iterators, bulk writes, FindOneAndUpdate and cursor pages are not supported.
+type Partition struct {
+	Field  string
+	Period Period
+	// Ahead is the number of upcoming tables created in advance by the migration, 1 if 0
+	Ahead int
+	// Retain is the number of past tables kept besides the current one, older tables are dropped, 0 keeps them all
+	Retain int
+}
+
+func (p Partition) layout() string {
+	switch p.Period {
+	case Daily:
+		return "20060102"
+	case Monthly:
+		return "200601"
+	default:
+		return "2006"
+	}
+}
+
+// periodStart returns the start of the period of t
+func (p Partition) periodStart(t time.Time) time.Time {
+	y, m, d := t.In(time.Local).Date()
+	switch p.Period {
+	case Daily:
+		return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	case Monthly:
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.Local)
+	default:
+		return time.Date(y, 1, 1, 0, 0, 0, 0, time.Local)
+	}
+}
+
+func (p Partition) addPeriods(t time.Time, n int) time.Time {
+	switch p.Period {
+	case Daily:
+		return t.AddDate(0, 0, n)
+	case Monthly:
+		return t.AddDate(0, n, 0)
+	default:
+		return t.AddDate(n, 0, 0)
+	}
+}
+
+// Table returns the physical table of base holding the records of t
+func (p Partition) Table(base string, t time.Time) string {
+	return base + "_" + t.In(time.Local).Format(p.layout())
+}
+
+// tableTime returns the start of the period of a physical table of base
+func (p Partition) tableTime(base, table string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(table, base+"_")
+	if !ok || len(suffix) != len(p.layout()) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(p.layout(), suffix, time.Local)
+	return t, err == nil
+}
+
+// bounds returns the time range matched on Field, a zero bound is open
+func (p Partition) bounds(matchList []Match) (from, to time.Time) {
+	for _, m := range matchList {
+		if m.Field != p.Field {
+			continue
+		}
+		t, err := cast.ToTimeE(m.Value)
+		if err != nil {
+			continue
+		}
+		if (m.Type == MEq || m.Type == MGt || m.Type == MGte) && (from.IsZero() || t.After(from)) {
+			from = t
+		}
+		if (m.Type == MEq || m.Type == MLt || m.Type == MLte) && (to.IsZero() || t.Before(to)) {
+			to = t
+		}
+	}
+	return from, to
+}
+
+// nearest returns a copy of the tables of base ordered by the distance of their period to t, the newer table first on a tie
+func (p Partition) nearest(base string, tables []string, t time.Time) []string {
+	origin := p.periodStart(t)
+	distance := func(table string) time.Duration {
+		start, _ := p.tableTime(base, table)
+		d := start.Sub(origin)
+		if d < 0 {
+			d = -d
+		}
+		return d
+	}
+	sorted := slices.Clone(tables)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		if r := cmp.Compare(distance(a), distance(b)); r != 0 {
+			return r
+		}
+		return strings.Compare(b, a)
+	})
+	return sorted
+}
+
+// partitioned is a partitioned table registered by its migration, kept to create and drop its tables
+type partitioned struct {
+	con   *Con
+	value ConTable
+	index []Index
+}
+
+type partitionList struct {
+	tables []string
+	at     time.Time
+}
+
+var (
+	partitions     = make(map[string]*partitioned)
+	partitionMu    sync.Mutex
+	partitionCache sync.Map
+	partitionStop  context.CancelFunc
+)
+
+func (c *Con) partitionKey() string {
+	return fmt.Sprintf("%s:%s:%s", c.GetConType(), c.DBName, c.GTable)
+}
+
+// partitionTables lists the physical tables of c newest first, cached for ${ domainx.partition.cache }
+func (c *Con) partitionTables() ([]string, *errors.Error) {
+	key := c.partitionKey()
+	if v, ok := partitionCache.Load(key); ok && time.Since(v.(partitionList).at) < configure.GetDuration("domainx.partition.cache", time.Minute) {
+		return v.(partitionList).tables, nil
+	}
+	all, err := GetDBService(c.GetConType()).Tables(c, c.GTable+"_")
+	if err != nil {
+		return nil, c.HandleWithErr(err)
+	}
+	tables := make([]string, 0, len(all))
+	for _, table := range all {
+		if _, ok := c.Partition.tableTime(c.GTable, table); ok {
+			tables = append(tables, table)
+		}
+	}
+	// the suffixes have a fixed width, the name order is the time order
+	sort.Sort(sort.Reverse(sort.StringSlice(tables)))
+	partitionCache.Store(key, partitionList{tables: tables, at: time.Now()})
+	return tables, nil
+}
+
+// partitionsOf returns the tables of c in the range matched on the partition field, newest first
+func (c *Con) partitionsOf(matchList []Match) ([]string, *errors.Error) {
+	if err := invalidMatch(matchList); err != nil {
+		return nil, err
+	}
+	tables, err := c.partitionTables()
+	if err != nil {
+		return nil, err
+	}
+	from, to := c.Partition.bounds(matchList)
+	list := make([]string, 0, len(tables))
+	for _, table := range tables {
+		start, _ := c.Partition.tableTime(c.GTable, table)
+		if !from.IsZero() && start.Before(c.Partition.periodStart(from)) {
+			continue
+		}
+		if !to.IsZero() && start.After(to) {
+			continue
+		}
+		list = append(list, table)
+	}
+	return list, nil
+}
+
+// onPartition runs fn with c on the physical table
+func (c *Con) onPartition(table string, fn func() *errors.Error) *errors.Error {
+	base, p := c.GTable, c.Partition
+	c.GTable, c.Partition = table, nil
+	defer func() {
+		c.GTable, c.Partition = base, p
+	}()
+	return fn()
+}
+
+// partitionOf returns the table holding the record id, empty if none. Each probed table costs an exists query,
+// the tables are probed from the period the snowflake id was generated in outwards and the first hit stops the lookup.
+// At most ${ domainx.partition.lookup } tables are probed (12 by default, 0 probes all), a record whose partition
+// time is further from its creation is not found by id, match it on the partition field instead.
+func (c *Con) partitionOf(id int64) (string, *errors.Error) {
+	tables, err := c.partitionTables()
+	if err != nil {
+		return "", err
+	}
+	tables = c.Partition.nearest(c.GTable, tables, ID(id).Info().Time)
+	if limit := configure.GetInt("domainx.partition.lookup", 12); limit > 0 && len(tables) > limit {
+		tables = tables[:limit]
+	}
+	for _, table := range tables {
+		var exists bool
+		if err = c.onPartition(table, func() (e *errors.Error) {
+			exists, e = ExistsByMatch(c, []Match{c.idMatch(id)})
+			return e
+		}); err != nil {
+			return "", err
+		}
+		if exists {
+			return table, nil
+		}
+	}
+	return "", nil
+}
+
+// matchedPartitions returns the tables in range holding a record matched by matchList, newest first
+func (c *Con) matchedPartitions(matchList []Match) ([]string, *errors.Error) {
+	tables, err := c.partitionsOf(matchList)
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]string, 0, len(tables))
+	for _, table := range tables {
+		var exists bool
+		if err = c.onPartition(table, func() (e *errors.Error) {
+			exists, e = ExistsByMatch(c, matchList)
+			return e
+		}); err != nil {
+			return nil, err
+		}
+		if exists {
+			matched = append(matched, table)
+		}
+	}
+	return matched, nil
+}
+
+func (c *Con) partitionUnsupported(op string) *errors.Error {
+	return errors.Sys(fmt.Sprintf("%s is not supported on the partitioned table %s", op, c.TableName()))
+}
+
+// ensurePartition creates the table with the indexes of the migration of c if it is not listed yet
+func (c *Con) ensurePartition(table string, value ConTable) *errors.Error {
+	tables, err := c.partitionTables()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(tables, table) {
+		return nil
+	}
+	var index []Index
+	partitionMu.Lock()
+	if pt, ok := partitions[c.partitionKey()]; ok {
+		index = pt.index
+	}
+	partitionMu.Unlock()
+	defer partitionCache.Delete(c.partitionKey())
+	return c.onPartition(table, func() *errors.Error {
+		if mErr := GetDBService(c.GetConType()).Migrate(c, table, value, index); mErr != nil {
+			return errors.Sys(fmt.Sprintf("create partition %s failed: %s", table, mErr.Error()), mErr)
+		}
+		return nil
+	})
+}
+
+// savePartition saves data into the table of the time in its partition field, an existing record stays in its table
+func savePartition(c *Con, data Identifiable, version int, newIDs ...int64) (int64, *errors.Error) {
+	t := time.Now()
+	if v, ok := FieldValue(data, c.Partition.Field); ok {
+		if ft, err := cast.ToTimeE(v); err == nil && !ft.IsZero() {
+			t = ft
+		}
+	}
+	table := c.Partition.Table(c.GTable, t)
+	if !data.GetID().IsNil() {
+		found, err := c.partitionOf(data.GetID().Int64())
+		if err != nil {
+			return 0, err
+		}
+		if found != "" {
+			table = found
+		}
+	}
+	if value, ok := data.(ConTable); ok {
+		if err := c.ensurePartition(table, value); err != nil {
+			return 0, err
+		}
+	}
+	var id int64
+	err := c.onPartition(table, func() (e *errors.Error) {
+		id, e = save(c, data, version, newIDs...)
+		return e
+	})
+	return id, err
+}
+
+func getPartitionByID[T any](c *Con, id int64, result *T) *errors.Error {
+	table, err := c.partitionOf(id)
+	if err != nil || table == "" {
+		return err
+	}
+	return c.onPartition(table, func() *errors.Error {
+		return GetByID(c, id, result)
+	})
+}
+
+// getPartitionByMatch reads the first match of the newest table in range holding one, the sort applies in that table
+func getPartitionByMatch[T any](c *Con, matchList []Match, result *T) *errors.Error {
+	tables, err := c.matchedPartitions(matchList)
+	if err != nil || len(tables) == 0 {
+		return err
+	}
+	return c.onPartition(tables[0], func() *errors.Error {
+		return GetByMatch(c, matchList, result)
+	})
+}
+
+func findPartitions[T any](c *Con, matchList []Match, result *[]T, prefixes ...string) *errors.Error {
+	tables, err := c.partitionsOf(matchList)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		var rows []T
+		if err = c.onPartition(table, func() *errors.Error {
+			return FindByMatch(c, matchList, &rows, prefixes...)
+		}); err != nil {
+			return err
+		}
+		*result = append(*result, rows...)
+	}
+	sortPartitionRows(c, *result)
+	return nil
+}
+
+// findPagePartitions reads the first pages up to page of every table in range and cuts the page from the merged rows
+func findPagePartitions[T Identifiable](c *Con, matchList []Match, page *load.Page, result *[]T, prefixes ...string) (*load.Total, *errors.Error) {
+	if page.Cursor != "" {
+		return nil, c.partitionUnsupported("cursor paging")
+	}
+	tables, err := c.partitionsOf(matchList)
+	if err != nil {
+		return nil, err
+	}
+	fetch, offset := &load.Page{Page: 1, Size: page.Page * page.Size}, (page.Page-1)*page.Size
+	if page.LastID > 0 {
+		fetch, offset = &load.Page{Page: 1, Size: page.Size, LastID: page.LastID}, 0
+	}
+	var total int64
+	var rows []T
+	for _, table := range tables {
+		var part []T
+		resp := &load.PageResp{}
+		if err = c.onPartition(table, func() *errors.Error {
+			return FindByPageMatch(c, matchList, fetch, resp, &part, prefixes...)
+		}); err != nil {
+			return nil, err
+		}
+		total += resp.Total.Get()
+		rows = append(rows, part...)
+	}
+	sortPartitionRows(c, rows)
+	end := min(offset+page.Size, int64(len(rows)))
+	if offset < end {
+		*result = append(*result, rows[offset:end]...)
+	}
+	t := load.Total(total)
+	return &t, nil
+}
+
+func countPartitions(c *Con, matchList []Match) (int64, *errors.Error) {
+	tables, err := c.partitionsOf(matchList)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, table := range tables {
+		var count int64
+		if err = c.onPartition(table, func() (e *errors.Error) {
+			count, e = CountByMatch(c, matchList)
+			return e
+		}); err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+func sumPartitions(c *Con, matchList []Match, field string) (float64, *errors.Error) {
+	tables, err := c.partitionsOf(matchList)
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, table := range tables {
+		var sum float64
+		if err = c.onPartition(table, func() (e *errors.Error) {
+			sum, e = SumByMatch(c, matchList, field)
+			return e
+		}); err != nil {
+			return 0, err
+		}
+		total += sum
+	}
+	return total, nil
+}
+
+func updatePartition(c *Con, id int64, data map[string]interface{}) *errors.Error {
+	table, err := c.partitionOf(id)
+	if err != nil || table == "" {
+		return err
+	}
+	return c.onPartition(table, func() *errors.Error {
+		return UpdatePart(c, id, data)
+	})
+}
+
+// writePartitions runs write on every table in range holding a match, on the newest one if none does
+func writePartitions(c *Con, matchList []Match, write func() *errors.Error) *errors.Error {
+	tables, err := c.matchedPartitions(matchList)
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		if tables, err = c.partitionsOf(matchList); err != nil || len(tables) == 0 {
+			return err
+		}
+		tables = tables[:1]
+	}
+	for _, table := range tables {
+		if err = c.onPartition(table, write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deletePartition(c *Con, data Identifiable) *errors.Error {
+	table, err := c.partitionOf(data.GetID().Int64())
+	if err != nil || table == "" {
+		return err
+	}
+	return c.onPartition(table, func() *errors.Error {
+		return Delete(c, data)
+	})
+}
+
+// sortPartitionRows sorts the rows merged from several tables by the sort of c, the table order is kept without a sort
+func sortPartitionRows[T any](c *Con, rows []T) {
+	if len(c.Sort) == 0 || len(rows) < 2 {
+		return
+	}
+	dbService := GetDBService(c.GetConType())
+	values := make(map[int][]interface{}, len(rows))
+	index := make([]int, len(rows))
+	for i, row := range rows {
+		v, err := dbService.CursorValues(c, row)
+		if err != nil {
+			logger.Warn(c.Ctx, "partition rows are not sorted", zap.String("table", c.TableName()), zap.Error(err))
+			return
+		}
+		values[i] = v
+		index[i] = i
+	}
+	sort.SliceStable(index, func(a, b int) bool {
+		va, vb := values[index[a]], values[index[b]]
+		for i, s := range c.Sort {
+			if i >= len(va) || i >= len(vb) {
+				break
+			}
+			if r := compareValues(va[i], vb[i]); r != 0 {
+				return (r < 0) == s.Asc
+			}
+		}
+		return false
+	})
+	sorted := make([]T, len(rows))
+	for i, idx := range index {
+		sorted[i] = rows[idx]
+	}
+	copy(rows, sorted)
+}
+
+func compareValues(a, b interface{}) int {
+	if dt, ok := a.(primitive.DateTime); ok {
+		a = dt.Time()
+	}
+	if dt, ok := b.(primitive.DateTime); ok {
+		b = dt.Time()
+	}
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch av := a.(type) {
+	case time.Time:
+		return av.Compare(cast.ToTime(b))
+	case string:
+		return strings.Compare(av, cast.ToString(b))
+	}
+	af, aErr := cast.ToFloat64E(a)
+	bf, bErr := cast.ToFloat64E(b)
+	if aErr == nil && bErr == nil {
+		return cmp.Compare(af, bf)
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// migratePartition registers the partitioned table of a migration and creates its upcoming tables
+func migratePartition(con *Con, value ConTable, index []Index) *errors.Error {
+	pt := &partitioned{con: con, value: value, index: index}
+	partitionMu.Lock()
+	partitions[con.partitionKey()] = pt
+	partitionMu.Unlock()
+	return pt.maintain()
+}
+
+// maintain creates the current and the upcoming tables and drops the tables past the retention
+func (pt *partitioned) maintain() *errors.Error {
+	c, p := pt.con, pt.con.Partition
+	now := p.periodStart(time.Now())
+	ahead := p.Ahead
+	if ahead <= 0 {
+		ahead = 1
+	}
+	for i := 0; i <= ahead; i++ {
+		if err := c.ensurePartition(p.Table(c.GTable, p.addPeriods(now, i)), pt.value); err != nil {
+			return err
+		}
+	}
+	if p.Retain <= 0 {
+		return nil
+	}
+	tables, err := c.partitionTables()
+	if err != nil {
+		return err
+	}
+	cutoff := p.addPeriods(now, -p.Retain)
+	defer partitionCache.Delete(c.partitionKey())
+	for _, table := range tables {
+		if start, _ := p.tableTime(c.GTable, table); !start.Before(cutoff) {
+			continue
+		}
+		if dErr := GetDBService(c.GetConType()).DropTable(c, table); dErr != nil {
+			return errors.Sys(fmt.Sprintf("drop partition %s failed: %s", table, dErr.Error()), dErr)
+		}
+		logger.Info(c.Ctx, "partition dropped", zap.String("table", table))
+	}
+	return nil
+}
+
+// runPartitions maintains the registered partitioned tables every ${ domainx.partition.interval }
+func runPartitions(ctx context.Context) {
+	ticker := time.NewTicker(configure.GetDuration("domainx.partition.interval", time.Hour))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		partitionMu.Lock()
+		list := make([]*partitioned, 0, len(partitions))
+		for _, pt := range partitions {
+			list = append(list, pt)
+		}
+		partitionMu.Unlock()
+		for _, pt := range list {
+			if err := pt.maintain(); err != nil {
+				logger.Error(ctx, "partition maintenance failed", zap.String("table", pt.con.GTable), zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package domainx
+
+import (
+	"context"
+	"github.com/jom-io/gorig/global/consts"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	qoptions "github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// RoutePrimary sends the reads of a con to the primary
+	RoutePrimary = "primary"
+	// RouteReplica sends the reads of a con to the default replicas, any other route is the name of a replica,
+	// a mysql replica registered under Mysql.<db>.Replicas.<name> or the mongo members tagged ${ domainx.replicaTag }: <name>
+	RouteReplica = "replica"
+)
+
+// ReadPin pins the reads of a request to the primary for a while after a write, see WithReadYourWrites
+type ReadPin struct {
+	ttl   time.Duration
+	until atomic.Int64
+}
+
+// NewReadPin returns a pin holding reads on the primary for ttl after each write, ${ domainx.readYourWrites } seconds by default
+func NewReadPin(ttl ...time.Duration) *ReadPin {
+	p := &ReadPin{ttl: time.Duration(configure.GetInt("domainx.readYourWrites", 5)) * time.Second}
+	if len(ttl) > 0 && ttl[0] > 0 {
+		p.ttl = ttl[0]
+	}
+	return p
+}
+
+// WithReadYourWrites returns a ctx whose reads go to the primary for a while after a write made with it
+func WithReadYourWrites(ctx context.Context, ttl ...time.Duration) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if readPin(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, consts.ReadPinKey, NewReadPin(ttl...))
+}
+
+func readPin(ctx context.Context) *ReadPin {
+	if ctx == nil {
+		return nil
+	}
+	p, _ := ctx.Value(consts.ReadPinKey).(*ReadPin)
+	return p
+}
+
+func (p *ReadPin) pinned() bool {
+	return time.Now().UnixNano() < p.until.Load()
+}
+
+// SetReadRoute routes the reads of c, RoutePrimary, RouteReplica or a replica name, empty for the default routing
+func (c *Con) SetReadRoute(route string) {
+	c.ReadRoute = route
+}
+
+// written pins the reads of the context of c after a successful write
+func (c *Con) written() {
+	if p := readPin(c.Ctx); p != nil {
+		p.until.Store(time.Now().Add(p.ttl).UnixNano())
+	}
+}
+
+// readRoute is the route of c, a transaction keeps its own connection
+func (c *Con) readRoute() string {
+	if InTransaction(c.Ctx, c.GetConType(), c.DBName) {
+		return ""
+	}
+	if c.ReadRoute != "" {
+		return c.ReadRoute
+	}
+	if p := readPin(c.Ctx); p != nil && p.pinned() {
+		return RoutePrimary
+	}
+	return ""
+}
+
+// routeMysql applies the route of c to db, the replicas are registered by gormt.GetSqlDriver
+func (c *Con) routeMysql(db *gorm.DB) *gorm.DB {
+	switch route := c.readRoute(); route {
+	case "", RouteReplica:
+		return db
+	case RoutePrimary:
+		return db.Clauses(dbresolver.Write)
+	default:
+		return db.Clauses(dbresolver.Use(route))
+	}
+}
+
+// mongoReadPref returns the database options of the route of c, nil for the client default
+func (c *Con) mongoReadPref() []*qoptions.DatabaseOptions {
+	var pref *readpref.ReadPref
+	switch route := c.readRoute(); route {
+	case "":
+		return nil
+	case RoutePrimary:
+		pref = readpref.Primary()
+	case RouteReplica:
+		pref = readpref.SecondaryPreferred()
+	default:
+		pref = readpref.SecondaryPreferred(readpref.WithTags(configure.GetString("domainx.replicaTag", "name"), route))
+	}
+	return []*qoptions.DatabaseOptions{{DatabaseOptions: options.Database().SetReadPreference(pref)}}
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/jom-io/gorig/utils/errors"
 	"github.com/jom-io/gorig/utils/sys"
 	"sync"
-	"time"
 )
 
 var service = &serviceInfo{
@@ -16,26 +15,42 @@ var service = &serviceInfo{
 
 type serviceInfo struct {
 	dbService sync.Map
+	mu        sync.Mutex
+	started   bool
 }
 
+// Start connects the databases once, the bootstrap may start the service before the other services to run migrations
 func (s *serviceInfo) Start(code, port string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return nil
+	}
+	s.started = true
 	var err error
 	s.dbService.Range(func(key, value interface{}) bool {
 		err = value.(DBService).Start()
 		return true
 	})
-	go func() {
-		time.Sleep(1 * time.Second)
-		for _, m := range MigrationList {
-			if err = s.Migrate(m); err != nil {
-				sys.Exit(errors.Sys(fmt.Sprintf("Migration failed: %v", err.Error())))
-			}
+	// the tables are migrated before Start returns, so the versioned migrations run by the bootstrap find them
+	for _, m := range MigrationList {
+		if mErr := s.Migrate(m); mErr != nil {
+			sys.Exit(errors.Sys(fmt.Sprintf("AutoMigrate failed: %v", mErr.Error())))
 		}
-	}()
+	}
+	var ctx context.Context
+	ctx, partitionStop = context.WithCancel(context.Background())
+	go runPartitions(ctx)
 	return err
 }
 
 func (s *serviceInfo) End(code string, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = false
+	if partitionStop != nil {
+		partitionStop()
+	}
 	var err error
 	s.dbService.Range(func(key, value interface{}) bool {
 		err = value.(DBService).End()
@@ -61,16 +76,13 @@ func (s *serviceInfo) Migrate(m *Migration) error {
 	}
 	tableName := value.TableName()
 	sys.Info(" * AutoMigrate: ", con.GetConType()+" ", tableName)
-	var err error
-	defer func() {
-		if err != nil {
-			sys.Exit(errors.Sys(fmt.Sprintf("AutoMigrate failed: %v", err.Error())))
+	if con.Partition != nil {
+		if pErr := migratePartition(con, value, m.Index); pErr != nil {
+			return pErr
 		}
-	}()
-	go func() {
-		err = GetDBService(con.GetConType()).Migrate(con, tableName, value, m.Index)
-	}()
-	return nil
+		return nil
+	}
+	return GetDBService(con.GetConType()).Migrate(con, tableName, value, m.Index)
 }
 
 type DBService interface {
@@ -79,20 +91,36 @@ type DBService interface {
 	Migrate(con *Con, tableName string, value ConTable, indexList []Index) error
 	GetByID(c *Con, id int64, result interface{}) error
 	Save(c *Con, data Identifiable, newID int64, version ...int) (id int64, error error)
-	UpdatePart(c *Con, id int64, data map[string]interface{}) error
-	UpdateByMatch(c *Con, matchList []Match, data map[string]interface{}) error
-	Delete(c *Con, data Identifiable) error
-	DeleteByMatch(c *Con, matchList []Match) error
+	// UpdatePart, UpdateByMatch, Delete and DeleteByMatch return the number of records written
+	UpdatePart(c *Con, id int64, data map[string]interface{}) (int64, error)
+	UpdateByMatch(c *Con, matchList []Match, data map[string]interface{}) (int64, error)
+	Delete(c *Con, data Identifiable) (int64, error)
+	DeleteByMatch(c *Con, matchList []Match) (int64, error)
 	FindByMatch(c *Con, matchList []Match, result interface{}, prefixes ...string) error
 	GetByMatch(c *Con, matchList []Match, result interface{}) error
+	// FindOneAndUpdate atomically updates the first matched record and loads it as it was before or after the update
+	FindOneAndUpdate(c *Con, matchList []Match, data map[string]interface{}, returnNew bool, result interface{}) error
 	CountByMatch(c *Con, matchList []Match) (int64, error)
 	ExistsByMatch(c *Con, matchList []Match) (bool, error)
 	SumByMatch(c *Con, matchList []Match, field string) (float64, error)
 	FindByPageMatch(c *Con, matchList []Match, page *load.Page, total *load.Total, result interface{}, prefixes ...string) error
+	AggregateByMatch(c *Con, matchList []Match, agg *Aggregation, total *load.Total, result *[]*AggItem) error
+	// IterByMatch opens a server side cursor on the matched records, the caller must close it.
+	// batchSize is only used by the mongo cursor, the mysql rows are streamed by the driver.
+	IterByMatch(c *Con, matchList []Match, batchSize int, prefixes ...string) (RowCursor, error)
+	// CursorValues reads the values of the sort fields of c from a result row, used to build page cursors
+	CursorValues(c *Con, row interface{}) ([]interface{}, error)
+	SaveMany(c *Con, dataList []Identifiable, batchSize int, result *BulkResult) error
+	UpsertBy(c *Con, dataList []Identifiable, fields []string, batchSize int, result *BulkResult) error
+	BulkWrite(c *Con, ops []BulkOp, result *BulkResult) error
+	// Tables lists the tables of the database of c whose name starts with prefix
+	Tables(c *Con, prefix string) ([]string, error)
+	DropTable(c *Con, table string) error
 }
 
+// RegisterDBService registers the service of a db type, wrapped with the query metrics and the slow query log
 func RegisterDBService(conType ConType, s DBService) {
-	service.dbService.Store(conType, s)
+	service.dbService.Store(conType, instrument(conType, s))
 }
 
 func GetDBService(conType ConType) DBService {
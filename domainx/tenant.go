@@ -0,0 +1,238 @@
+package domainx
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig/global/consts"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/spf13/cast"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+// WithTenant returns a ctx scoped to tenantID, for jobs running outside of an http request
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, consts.TenantIDKey, tenantID)
+}
+
+func (c *Con) SetTenantField(field string) {
+	c.TenantField = field
+}
+
+func (c *Con) SetUnscoped(unscoped bool) {
+	c.Unscoped = unscoped
+}
+
+func (c *Con) tenantScoped() bool {
+	return c.TenantField != "" && !c.Unscoped
+}
+
+// ScopedTenantID returns the tenant ID the queries of c are scoped to, "" when c is not scoped
+func (c *Con) ScopedTenantID() string {
+	if c == nil || !c.tenantScoped() {
+		return ""
+	}
+	return logger.GetTenantID(c.Ctx)
+}
+
+// tenantID returns the tenant of Ctx, a scoped con without tenant fails instead of reading all tenants
+func (c *Con) tenantID() (string, *errors.Error) {
+	tenantID := logger.GetTenantID(c.Ctx)
+	if tenantID == "" {
+		return "", errors.Sys(fmt.Sprintf("%s is tenant scoped but the context has no tenant id", c.TableName()))
+	}
+	return tenantID, nil
+}
+
+func (c *Con) tenantMatch(tenantID string) Match {
+	field := c.TenantField
+	if c.GetConType() == Mongo {
+		field = preData.ad(field)
+	}
+	return Match{Field: field, Value: tenantID, Type: MEq}
+}
+
+func (c *Con) idMatch(id int64) Match {
+	if c.GetConType() == Mongo {
+		return Match{Field: "con.id", Value: id, Type: MEq}
+	}
+	return Match{Field: "id", Value: id, Type: MEq}
+}
+
+// scopeMatches appends the tenant match to a copy of matchList, matchList holding a match rejected by its builder fails
+func (c *Con) scopeMatches(matchList []Match) ([]Match, *errors.Error) {
+	if err := invalidMatch(matchList); err != nil {
+		return nil, err
+	}
+	if !c.tenantScoped() {
+		return matchList, nil
+	}
+	tenantID, err := c.tenantID()
+	if err != nil {
+		return nil, err
+	}
+	scoped := make([]Match, 0, len(matchList)+1)
+	scoped = append(scoped, matchList...)
+	return append(scoped, c.tenantMatch(tenantID)), nil
+}
+
+// tenantOwns reports whether the record id belongs to the tenant, always true when c is not scoped
+func (c *Con) tenantOwns(id int64) (bool, *errors.Error) {
+	if !c.tenantScoped() {
+		return true, nil
+	}
+	matchList, err := c.scopeMatches([]Match{c.idMatch(id)})
+	if err != nil {
+		return false, err
+	}
+	exists, gErr := GetDBService(c.GetConType()).ExistsByMatch(c, matchList)
+	if gErr != nil {
+		return false, c.HandleWithErr(gErr)
+	}
+	return exists, nil
+}
+
+// mustOwn returns a not found error when the record id is not one of the tenant
+func (c *Con) mustOwn(id int64) *errors.Error {
+	owns, err := c.tenantOwns(id)
+	if err != nil {
+		return err
+	}
+	if !owns {
+		return errors.NotFound(fmt.Sprintf("%s record id %d not found", c.TableName(), id))
+	}
+	return nil
+}
+
+// scopeData sets the tenant field of data, data already carrying another tenant is rejected
+func (c *Con) scopeData(data interface{}) *errors.Error {
+	if !c.tenantScoped() {
+		return nil
+	}
+	tenantID, err := c.tenantID()
+	if err != nil {
+		return err
+	}
+	field, ok := findField(reflect.ValueOf(data), c.TenantField)
+	if !ok {
+		return errors.Sys(fmt.Sprintf("%s has no tenant field %s", c.TableName(), c.TenantField))
+	}
+	if !field.IsZero() && cast.ToString(field.Interface()) != tenantID {
+		return errors.Verify(fmt.Sprintf("%s record belongs to another tenant", c.TableName()))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(tenantID)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		field.SetInt(cast.ToInt64(tenantID))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		field.SetUint(cast.ToUint64(tenantID))
+	default:
+		return errors.Sys(fmt.Sprintf("%s tenant field %s must be a string or an integer", c.TableName(), c.TenantField))
+	}
+	return nil
+}
+
+// scopeUpdate rejects an update of the tenant field to another tenant, by column, bson, data path or json name
+func (c *Con) scopeUpdate(data map[string]interface{}) *errors.Error {
+	if !c.tenantScoped() {
+		return nil
+	}
+	for k, v := range data {
+		key := strings.TrimPrefix(k, preData.ad())
+		if key != c.TenantField && (schema.NamingStrategy{}).ColumnName("", key) != c.TenantField {
+			continue
+		}
+		tenantID, err := c.tenantID()
+		if err != nil {
+			return err
+		}
+		if _, ok := v.(UpdateExpr); ok || cast.ToString(v) != tenantID {
+			return errors.Verify(fmt.Sprintf("%s record cannot move to another tenant", c.TableName()))
+		}
+	}
+	return nil
+}
+
+// FieldValue returns the value of the field named name (gorm column, bson name or snake case name) of data
+func FieldValue(data any, name string) (any, bool) {
+	fv, ok := findField(reflect.ValueOf(data), name)
+	if !ok {
+		return nil, false
+	}
+	return fv.Interface(), true
+}
+
+// findField looks up the field named name by gorm column, bson name or snake case name,
+// in data, its embedded structs and the Data of a Complex
+func findField(rv reflect.Value, name string) (reflect.Value, bool) {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return reflect.Value{}, false
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() || field.Type == reflect.TypeOf(&Con{}) {
+			continue
+		}
+		if field.Anonymous || field.Name == "Data" {
+			if fv, ok := findField(rv.Field(i), name); ok {
+				return fv, true
+			}
+			continue
+		}
+		if fieldNames(field)[name] {
+			return rv.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func fieldNames(field reflect.StructField) map[string]bool {
+	names := map[string]bool{schema.NamingStrategy{}.ColumnName("", field.Name): true}
+	if name, _, _ := strings.Cut(field.Tag.Get("bson"), ","); name != "" && name != "-" {
+		names[name] = true
+	}
+	for _, part := range strings.Split(field.Tag.Get("gorm"), ";") {
+		if column, ok := strings.CutPrefix(part, "column:"); ok {
+			names[column] = true
+		}
+	}
+	return names
+}
+
+// scopeSave sets the tenant of data, and rejects saving over a record id of another tenant
+func (c *Con) scopeSave(data Identifiable) *errors.Error {
+	if !c.tenantScoped() {
+		return nil
+	}
+	if err := c.scopeData(data); err != nil {
+		return err
+	}
+	if c.ID == 0 {
+		return nil
+	}
+	owns, err := c.tenantOwns(c.ID)
+	if err != nil || owns {
+		return err
+	}
+	exists, gErr := GetDBService(c.GetConType()).ExistsByMatch(c, []Match{c.idMatch(c.ID)})
+	if gErr != nil {
+		return c.HandleWithErr(gErr)
+	}
+	if exists {
+		return errors.NotFound(fmt.Sprintf("%s record id %d not found", c.TableName(), c.ID))
+	}
+	return nil
+}
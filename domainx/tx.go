@@ -0,0 +1,106 @@
+package domainx
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig/utils/errors"
+	"gorm.io/gorm"
+	"sync"
+)
+
+type txKey struct {
+	conType ConType
+	dbName  string
+}
+
+type afterCommitKey struct{}
+
+// afterCommit holds the callbacks of a transaction run after its commit
+type afterCommit struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+// AfterCommit runs fn after the commit of the transaction carried by ctx, at once without a transaction.
+// fn is dropped when the transaction rolls back.
+func AfterCommit(ctx context.Context, fn func()) {
+	if ctx != nil {
+		if ac, ok := ctx.Value(afterCommitKey{}).(*afterCommit); ok {
+			ac.mu.Lock()
+			ac.fns = append(ac.fns, fn)
+			ac.mu.Unlock()
+			return
+		}
+	}
+	fn()
+}
+
+// InTransaction reports whether ctx carries a transaction of dbName
+func InTransaction(ctx context.Context, conType ConType, dbName string) bool {
+	return ctx != nil && ctx.Value(txKey{conType: conType, dbName: dbName}) != nil
+}
+
+// Transaction runs fn in a transaction of dbName, the domainx/dx operations using the ctx passed to fn join it.
+// fn returning an error rolls the transaction back, a nested call joins the outer transaction.
+// Mongo transactions need a replica set, fn may be retried on transient errors.
+func Transaction(ctx context.Context, conType ConType, dbName string, fn func(ctx context.Context) *errors.Error) *errors.Error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if InTransaction(ctx, conType, dbName) {
+		return fn(ctx)
+	}
+	key := txKey{conType: conType, dbName: dbName}
+	var fnErr *errors.Error
+	var ac *afterCommit
+	run := func(txCtx context.Context) error {
+		// a retried mongo transaction starts over with no callbacks
+		ac = &afterCommit{}
+		if fnErr = fn(context.WithValue(txCtx, afterCommitKey{}, ac)); fnErr != nil {
+			return fnErr
+		}
+		return nil
+	}
+
+	var err error
+	switch conType {
+	case Mysql:
+		db := UseDbConn(dbName)
+		if db == nil {
+			return errors.Sys(fmt.Sprintf("%s db not init", dbName))
+		}
+		err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return run(context.WithValue(ctx, key, tx))
+		})
+	case Mongo:
+		client := UseMongoDbConn(dbName)
+		if client == nil {
+			return errors.Sys(fmt.Sprintf("%s db not init", dbName))
+		}
+		_, err = client.DoTransaction(ctx, func(sessCtx context.Context) (interface{}, error) {
+			return nil, run(context.WithValue(sessCtx, key, true))
+		})
+	default:
+		return unknownDBType()
+	}
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return errors.Sys(fmt.Sprintf("%s transaction failed: %s", dbName, err.Error()), err)
+	}
+	for _, f := range ac.fns {
+		f()
+	}
+	return nil
+}
+
+// mysqlDB returns the db of c bound to its context and read route, joining the transaction carried by the context
+func (c *Con) mysqlDB() *gorm.DB {
+	if c.Ctx != nil {
+		if tx, ok := c.Ctx.Value(txKey{conType: Mysql, dbName: c.DBName}).(*gorm.DB); ok && tx != nil {
+			return tx.WithContext(c.Ctx)
+		}
+	}
+	return c.routeMysql(c.MysqlDB.WithContext(c.Ctx))
+}
@@ -0,0 +1,164 @@
+package domainx
+
+import (
+	"github.com/jom-io/gorig/utils/errors"
+	"reflect"
+	"strings"
+)
+
+// UpdateOp is an atomic update operator, named after its mongo operator
+type UpdateOp string
+
+const (
+	OpInc         UpdateOp = "$inc"
+	OpPush        UpdateOp = "$push"
+	OpAddToSet    UpdateOp = "$addToSet"
+	OpPull        UpdateOp = "$pull"
+	OpSetOnInsert UpdateOp = "$setOnInsert"
+	OpMin         UpdateOp = "$min"
+	OpMax         UpdateOp = "$max"
+	OpUnset       UpdateOp = "$unset"
+)
+
+// UpdateExpr is an atomic update of a field, set it as the value of the field in the data of UpdatePart, UpdateByMatch,
+// a bulk update or FindOneAndUpdate, e.g. {"views": Inc(1)}. Other values are assigned.
+type UpdateExpr struct {
+	Op     UpdateOp
+	Values []interface{}
+}
+
+// Inc adds n to the field, a NULL column counts as 0 on mysql
+func Inc(n interface{}) UpdateExpr {
+	return UpdateExpr{Op: OpInc, Values: []interface{}{n}}
+}
+
+// Push appends the values to the array field, JSON_ARRAY_APPEND on mysql
+func Push(values ...interface{}) UpdateExpr {
+	return UpdateExpr{Op: OpPush, Values: values}
+}
+
+// AddToSet appends the values missing from the array field. On mysql each value nests the expression, keep them few.
+func AddToSet(values ...interface{}) UpdateExpr {
+	return UpdateExpr{Op: OpAddToSet, Values: values}
+}
+
+// Pull removes the values from the array field, on mysql only the first occurrence of each string value
+func Pull(values ...interface{}) UpdateExpr {
+	return UpdateExpr{Op: OpPull, Values: values}
+}
+
+// SetOnInsert sets the field when the update inserts the document, it turns the update into an upsert, mongo only
+func SetOnInsert(value interface{}) UpdateExpr {
+	return UpdateExpr{Op: OpSetOnInsert, Values: []interface{}{value}}
+}
+
+// Min sets the field to value if value is lower, LEAST on mysql
+func Min(value interface{}) UpdateExpr {
+	return UpdateExpr{Op: OpMin, Values: []interface{}{value}}
+}
+
+// Max sets the field to value if value is greater, GREATEST on mysql
+func Max(value interface{}) UpdateExpr {
+	return UpdateExpr{Op: OpMax, Values: []interface{}{value}}
+}
+
+// Unset removes the field, sets the column to NULL on mysql
+func Unset() UpdateExpr {
+	return UpdateExpr{Op: OpUnset}
+}
+
+func hasUpsert(data map[string]interface{}) bool {
+	for _, v := range data {
+		if e, ok := v.(UpdateExpr); ok && e.Op == OpSetOnInsert {
+			return true
+		}
+	}
+	return false
+}
+
+// FindOneAndUpdate updates the first record matched in the sort of c, only the record of c.ID if set, and loads it into result
+// as it was before the update or after it with returnNew, found is false if nothing matched.
+// A SetOnInsert in data inserts the record if none matches.
+func FindOneAndUpdate(c *Con, matchList []Match, data map[string]interface{}, returnNew bool, result interface{}) (found bool, err *errors.Error) {
+	if c == nil {
+		return false, errors.Sys("con not init")
+	}
+	if c.Partition != nil {
+		return false, c.partitionUnsupported("FindOneAndUpdate")
+	}
+	if len(data) == 0 {
+		return false, errors.Sys("data map cannot be empty")
+	}
+	if err := c.scopeUpdate(data); err != nil {
+		return false, err
+	}
+
+	if c.ID > 0 {
+		matchList = append([]Match{c.idMatch(c.ID)}, matchList...)
+	}
+	matchList, sErr := c.scopeMatches(matchList)
+	if sErr != nil {
+		return false, sErr
+	}
+
+	dbService := GetDBService(c.GetConType())
+
+	gErr := dbService.FindOneAndUpdate(c, matchList, data, returnNew, result)
+	if noRecord(gErr) {
+		return false, nil
+	}
+	if gErr != nil {
+		return false, c.HandleWithErr(gErr)
+	}
+	c.written()
+	return true, nil
+}
+
+// UpdateFields maps the json names of the fields of T to the names used in update maps on conType: the column on mysql,
+// the bson name on mongo. Anonymous structs are flattened, fields not stored on conType or hidden from json are left out.
+func UpdateFields[T any](conType ConType) map[string]string {
+	fields := make(map[string]string)
+	updateFields(reflect.TypeOf((*T)(nil)).Elem(), conType, fields)
+	return fields
+}
+
+func updateFields(t reflect.Type, conType ConType, fields map[string]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			updateFields(field.Type, conType, fields)
+			continue
+		}
+		if !field.IsExported() || !storedOn(conType, field) {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = storageName(conType, field)
+	}
+}
+
+// storedOn reports whether field is written by the driver of conType
+func storedOn(conType ConType, field reflect.StructField) bool {
+	if conType == Mongo {
+		return field.Tag.Get("bson") != "-"
+	}
+	for _, part := range strings.Split(field.Tag.Get("gorm"), ";") {
+		switch strings.TrimSpace(part) {
+		case "-", "-:all", "->", "<-:false":
+			return false
+		}
+	}
+	return true
+}
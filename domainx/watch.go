@@ -0,0 +1,435 @@
+package domainx
+
+import (
+	"context"
+	"encoding/base64"
+	checkErr "errors"
+	"fmt"
+	"github.com/jom-io/gorig/cache"
+	"github.com/jom-io/gorig/mid/messagex"
+	"github.com/jom-io/gorig/serv"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/jom-io/gorig/utils/sys"
+	"github.com/spf13/cast"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const WatchServiceCode = "WATCH"
+
+// WatchOp is the operation of a change event
+type WatchOp string
+
+const (
+	WatchInsert  WatchOp = "insert"
+	WatchUpdate  WatchOp = "update"
+	WatchReplace WatchOp = "replace"
+	WatchDelete  WatchOp = "delete"
+)
+
+// WatchEvent is a change of a record of a watched table
+type WatchEvent[T any] struct {
+	Op WatchOp
+	ID int64
+	// Row is the record after the change, nil for a delete or if the record was deleted before the lookup
+	Row *Complex[T]
+	// Before is the record before the change, only set with WatchPreImages
+	Before *Complex[T]
+	// DocID is the mongo _id of the document, the only key of a delete without pre-images
+	DocID interface{}
+	// Updated holds the changed fields of an update, keyed by their document path
+	Updated map[string]interface{}
+	// Removed holds the removed fields of an update
+	Removed []string
+}
+
+// WatchHandler handles a change event, the resume token is only saved once it returns nil
+type WatchHandler[T any] func(ctx context.Context, event *WatchEvent[T]) *errors.Error
+
+type WatchOption func(w *watcher)
+
+// Republish publishes every change event to topic of broker after the handler, with the op, id, updated and removed fields
+// and the record data as content
+func Republish(broker messagex.BrokerType, topic string) WatchOption {
+	return func(w *watcher) {
+		w.broker = broker
+		w.topic = topic
+	}
+}
+
+// WatchPreImages reads the document before the change, which gives the ID of deleted records.
+// It needs mongo 6.0 with changeStreamPreAndPostImages enabled on the collection.
+func WatchPreImages() WatchOption {
+	return func(w *watcher) {
+		w.preImages = true
+	}
+}
+
+// WatchName names the watcher, its resume token is stored under the name, required to watch a table more than once
+func WatchName(name string) WatchOption {
+	return func(w *watcher) {
+		w.name = name
+	}
+}
+
+// watcher follows the change stream of one collection, resuming after the token saved for its key
+type watcher struct {
+	dbName    string
+	table     string
+	name      string
+	preImages bool
+	broker    messagex.BrokerType
+	topic     string
+	con       func(ctx context.Context) *Con
+	handle    func(ctx context.Context, con *Con, raw bson.Raw) *errors.Error
+}
+
+var (
+	watchers    []*watcher
+	watchMu     sync.Mutex
+	watchCtx    context.Context
+	watchCancel context.CancelFunc
+	watchWg     sync.WaitGroup
+	tokens      cache.Cache[string]
+	tokensOnce  sync.Once
+)
+
+func init() {
+	if err := serv.RegisterService(
+		serv.Service{
+			Code:     WatchServiceCode,
+			Startup:  startWatch,
+			Shutdown: stopWatch,
+		},
+	); err != nil {
+		sys.Exit(err)
+	}
+}
+
+// Watch calls handler for every change of table in the mongo database dbName, including writes of other applications.
+// The watcher runs with the WATCH service and resumes after the last handled event on restart, the resume token is kept
+// in redis when ${ redis.addr } is set, otherwise in the local json cache.
+func Watch[T any](conType ConType, dbName, table string, handler WatchHandler[T], opts ...WatchOption) *errors.Error {
+	if conType != Mongo {
+		return errors.Sys(fmt.Sprintf("Watch: change streams are not supported by %s", conType))
+	}
+	if handler == nil {
+		return errors.Sys("Watch: handler is nil")
+	}
+	w := &watcher{dbName: dbName, table: table}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.con = func(ctx context.Context) *Con {
+		return CreateComplex[T](ctx, conType, dbName, table, nil).Con
+	}
+	w.handle = func(ctx context.Context, con *Con, raw bson.Raw) *errors.Error {
+		event, content, err := decodeWatchEvent[T](ctx, con, raw)
+		if err != nil || event == nil {
+			return err
+		}
+		if err = handler(ctx, event); err != nil {
+			return err
+		}
+		return w.republish(ctx, event.ID, content)
+	}
+
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	watchers = append(watchers, w)
+	if watchCtx != nil {
+		w.spawn(watchCtx)
+	}
+	return nil
+}
+
+func startWatch(code, port string) error {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	watchCtx, watchCancel = context.WithCancel(context.Background())
+	for _, w := range watchers {
+		w.spawn(watchCtx)
+	}
+	return nil
+}
+
+func stopWatch(code string, ctx context.Context) error {
+	watchMu.Lock()
+	if watchCancel != nil {
+		watchCancel()
+	}
+	watchCtx = nil
+	watchMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		watchWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	sys.Info(" * Watch service shutdown")
+	return nil
+}
+
+func (w *watcher) spawn(ctx context.Context) {
+	watchWg.Add(1)
+	go func() {
+		defer watchWg.Done()
+		w.run(ctx)
+	}()
+}
+
+func (w *watcher) key() string {
+	name := w.name
+	if name == "" {
+		name = w.table
+	}
+	return fmt.Sprintf("dx:watch:%s:%s", w.dbName, name)
+}
+
+// run reopens the change stream after a failure, a lost resume token is dropped and the stream restarts from now
+func (w *watcher) run(ctx context.Context) {
+	fields := []zap.Field{zap.String("db", w.dbName), zap.String("table", w.table)}
+	WatchRetry(ctx, func(ctx context.Context) error {
+		err := w.follow(ctx)
+		if err != nil && ctx.Err() == nil && !IsWatchTokenLost(err) {
+			logger.Error(ctx, "watch stream failed", append(fields, zap.Error(err))...)
+		}
+		return err
+	}, func(err error) {
+		logger.Warn(ctx, "watch resume token lost, the events since the last handled one are skipped", append(fields, zap.Error(err))...)
+		w.dropToken()
+	})
+}
+
+// watchTokenLost are the server codes of a resume token the stream cannot resume after: InvalidResumeToken,
+// ChangeStreamFatalError and ChangeStreamHistoryLost, the oplog no longer holds the event of the token
+var watchTokenLost = []int{260, 280, 286}
+
+// IsWatchTokenLost reports whether err means the change stream cannot resume after its token
+func IsWatchTokenLost(err error) bool {
+	var se mongo.ServerError
+	if !checkErr.As(err, &se) {
+		return false
+	}
+	for _, code := range watchTokenLost {
+		if se.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchRetry runs follow until ctx is done, follow runs again after a failure with a wait doubling from
+// ${ domainx.watch.retryWait } (1s) up to a minute. A lost token (see IsWatchTokenLost) is passed to reset,
+// which must drop it, and follow runs again at once, a second loss in a row waits like other failures.
+func WatchRetry(ctx context.Context, follow func(ctx context.Context) error, reset func(err error)) {
+	retryWait := configure.GetDuration("domainx.watch.retryWait", time.Second)
+	wait := retryWait
+	reopened := false
+	for ctx.Err() == nil {
+		err := follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if IsWatchTokenLost(err) && !reopened {
+			reset(err)
+			reopened, wait = true, retryWait
+			continue
+		}
+		reopened = false
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if wait < time.Minute {
+			wait = min(wait*2, time.Minute)
+		}
+	}
+}
+
+func (w *watcher) follow(ctx context.Context) error {
+	con := w.con(ctx)
+	if con == nil || con.MongoDB == nil {
+		return errors.Sys("watch con not init")
+	}
+	coll, e := getColl(con)
+	if e != nil {
+		return e
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if w.preImages {
+		opts.SetFullDocumentBeforeChange(options.WhenAvailable)
+	}
+	if token := w.loadToken(); token != nil {
+		// unlike resumeAfter, startAfter accepts the token of an invalidate event, which ends the stream after a drop or rename
+		opts.SetStartAfter(token)
+	}
+	mColl, err := coll.CloneCollection()
+	if err != nil {
+		return err
+	}
+	stream, err := mColl.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+	for stream.Next(ctx) {
+		if hErr := w.handle(ctx, con, stream.Current); hErr != nil {
+			// the token is not saved, the event is delivered again when the stream is reopened
+			return hErr
+		}
+		w.saveToken(stream.ResumeToken())
+	}
+	return stream.Err()
+}
+
+func watchTokens() cache.Cache[string] {
+	tokensOnce.Do(func() {
+		if configure.GetString("redis.addr") != "" {
+			if rc := cache.GetRedisInstance[string](context.Background()); rc != nil && rc.IsInitialized() {
+				tokens = rc
+				return
+			}
+		}
+		tokens = cache.New[string](cache.JSON, "dx_watch")
+	})
+	return tokens
+}
+
+func (w *watcher) loadToken() bson.Raw {
+	store := watchTokens()
+	if store == nil || !store.IsInitialized() {
+		return nil
+	}
+	value, err := store.Get(w.key())
+	if err != nil || value == "" {
+		return nil
+	}
+	token, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+	return token
+}
+
+func (w *watcher) saveToken(token bson.Raw) {
+	store := watchTokens()
+	if store == nil || !store.IsInitialized() || token == nil {
+		return
+	}
+	if err := store.Set(w.key(), base64.StdEncoding.EncodeToString(token), 0); err != nil {
+		logger.Error(nil, "save watch token failed", zap.String("key", w.key()), zap.Error(err))
+	}
+}
+
+func (w *watcher) dropToken() {
+	store := watchTokens()
+	if store == nil || !store.IsInitialized() {
+		return
+	}
+	if err := store.Del(w.key()); err != nil {
+		logger.Error(nil, "drop watch token failed", zap.String("key", w.key()), zap.Error(err))
+	}
+}
+
+func (w *watcher) republish(ctx context.Context, id int64, content map[string]interface{}) *errors.Error {
+	if w.topic == "" {
+		return nil
+	}
+	msg := &messagex.Message{
+		Ctx:     ctx,
+		ID:      cast.ToString(id),
+		Topic:   w.topic,
+		Content: content,
+	}
+	return messagex.Ins(w.broker).Publish(ctx, w.topic, msg)
+}
+
+// changeEvent is the part of a change stream event read by Watch
+type changeEvent struct {
+	OperationType            string   `bson:"operationType"`
+	FullDocument             bson.Raw `bson:"fullDocument"`
+	FullDocumentBeforeChange bson.Raw `bson:"fullDocumentBeforeChange"`
+	DocumentKey              struct {
+		ID interface{} `bson:"_id"`
+	} `bson:"documentKey"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// DecodeWatchEvent decodes a raw change stream event of a table of con, nil for operations other than WatchOp
+func DecodeWatchEvent[T any](ctx context.Context, con *Con, raw bson.Raw) (*WatchEvent[T], *errors.Error) {
+	event, _, err := decodeWatchEvent[T](ctx, con, raw)
+	return event, err
+}
+
+// decodeWatchEvent decodes a raw change event, nil for operations other than WatchOp such as drop or invalidate
+func decodeWatchEvent[T any](ctx context.Context, con *Con, raw bson.Raw) (*WatchEvent[T], map[string]interface{}, *errors.Error) {
+	var change changeEvent
+	if err := bson.Unmarshal(raw, &change); err != nil {
+		return nil, nil, errors.Sys("decode change event failed", err)
+	}
+	event := &WatchEvent[T]{Op: WatchOp(change.OperationType)}
+	switch event.Op {
+	case WatchInsert, WatchUpdate, WatchReplace, WatchDelete:
+	default:
+		return nil, nil, nil
+	}
+	event.DocID = change.DocumentKey.ID
+	content := map[string]interface{}{"op": string(event.Op)}
+	var err *errors.Error
+	if event.Row, err = decodeWatchRow[T](ctx, con, change.FullDocument); err != nil {
+		return nil, nil, err
+	}
+	if event.Before, err = decodeWatchRow[T](ctx, con, change.FullDocumentBeforeChange); err != nil {
+		return nil, nil, err
+	}
+	if event.Row != nil {
+		event.ID = event.Row.GetID().Int64()
+		content["data"] = event.Row.Data
+	} else if event.Before != nil {
+		event.ID = event.Before.GetID().Int64()
+	}
+	if event.Op == WatchUpdate {
+		event.Updated = change.UpdateDescription.UpdatedFields
+		event.Removed = change.UpdateDescription.RemovedFields
+		content["updated"] = event.Updated
+		content["removed"] = event.Removed
+	}
+	content["id"] = event.ID
+	return event, content, nil
+}
+
+// decodeWatchRow decodes and decrypts a document of a change event into a record on con, nil if the event has no such document
+func decodeWatchRow[T any](ctx context.Context, con *Con, doc bson.Raw) (*Complex[T], *errors.Error) {
+	if len(doc) == 0 {
+		return nil, nil
+	}
+	row := &Complex[T]{}
+	if err := bson.Unmarshal(doc, row); err != nil {
+		return nil, errors.Sys("decode change document failed", err)
+	}
+	if err := DecryptData(row.Data); err != nil {
+		return nil, err
+	}
+	rowCon := *con
+	rowCon.Ctx = ctx
+	rowCon.ID = 0
+	if row.Con != nil {
+		rowCon.ID = row.Con.ID
+	}
+	row.Con = &rowCon
+	return row, nil
+}
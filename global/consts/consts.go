@@ -76,9 +76,12 @@ const (
 const NotNull = "not_null"
 
 const (
-	TraceIDKey = "_trace_id_"
-	UserID     = "userId"
-	UserIDKey  = "_user_id"
-	TokenKey   = "token"
-	UserInfo   = "userInfo"
+	TraceIDKey  = "_trace_id_"
+	UserID      = "userId"
+	UserIDKey   = "_user_id"
+	TokenKey    = "token"
+	UserInfo    = "userInfo"
+	TenantID    = "tenantId"
+	TenantIDKey = "_tenant_id"
+	ReadPinKey  = "_read_pin"
 )
@@ -30,6 +30,8 @@ require (
 )
 
 require (
+	github.com/go-playground/validator/v10 v10.19.0
+	github.com/go-sql-driver/mysql v1.8.1
 	github.com/robfig/cron/v3 v3.0.0
 	github.com/stretchr/testify v1.10.0
 	modernc.org/sqlite v1.37.0
@@ -48,8 +50,6 @@ require (
 	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
-	github.com/go-playground/validator/v10 v10.19.0 // indirect
-	github.com/go-sql-driver/mysql v1.8.1 // indirect
 	github.com/goccy/go-json v0.10.2 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/google/go-cmp v0.7.0 // indirect
@@ -0,0 +1,16 @@
+package httpx
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/domainx"
+)
+
+// Metrics serves the domainx query metrics in the prometheus text format, e.g. groupRouter.GET("metrics", httpx.Metrics())
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := domainx.WriteQueryMetrics(c.Writer); err != nil {
+			_ = c.Error(err)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package httpx
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/global/consts"
+	"time"
+)
+
+// ReadYourWrites pins the reads of a request to the primary for ttl after each of its writes,
+// ${ domainx.readYourWrites } seconds by default. The pin is shared by the gin context and the request context.
+func ReadYourWrites(ttl ...time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pin := domainx.NewReadPin(ttl...)
+		c.Set(consts.ReadPinKey, pin)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), consts.ReadPinKey, pin))
+		c.Next()
+	}
+}
@@ -0,0 +1,23 @@
+package httpx
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/apix"
+	"github.com/jom-io/gorig/global/consts"
+	"github.com/spf13/cast"
+)
+
+// Tenant puts the tenant ID of the token user info into the request context, register it after the sign middleware.
+// The tenant ID is read from userInfo[key], key defaults to consts.TenantID.
+func Tenant(key ...string) gin.HandlerFunc {
+	infoKey := consts.TenantID
+	if len(key) > 0 && key[0] != "" {
+		infoKey = key[0]
+	}
+	return func(c *gin.Context) {
+		if tenantID := cast.ToString(apix.GetUserInfoValue(c, infoKey)); tenantID != "" {
+			apix.SetTenantID(c, tenantID)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,256 @@
+package httpx
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/apix"
+	"github.com/jom-io/gorig/apix/filter"
+	"github.com/jom-io/gorig/apix/load"
+	"github.com/jom-io/gorig/apix/response"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/domainx/dx"
+	"github.com/jom-io/gorig/global/consts"
+	"github.com/jom-io/gorig/utils/errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Action is a route of a Resource
+type Action string
+
+const (
+	ActionList   Action = "list"
+	ActionGet    Action = "get"
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// ResourceOptions configures the routes of a Resource, every hook is optional
+type ResourceOptions[T any] struct {
+	// Actions limits the registered routes, all five if empty
+	Actions []Action
+	// Filter is the whitelist of the filter and sort params of the list route, see filter.Parse
+	Filter filter.Schema
+	// Updatable lists the json names of the fields a partial update may set, all the stored fields of T if empty.
+	// The id, the tenant field and the con and options fields are never updatable.
+	Updatable []string
+	// Scope restricts the list route, e.g. to the records of the user
+	Scope func(c *gin.Context, q dx.DQuery[T]) dx.DQuery[T]
+	// Authorize is called with the loaded record before get, update and delete, with the record to save before create
+	// (it may set server side fields) and with nil before list, an error is returned as 403
+	Authorize func(c *gin.Context, action Action, row *domainx.Complex[T]) *errors.Error
+	// Mask is called on every returned record, e.g. to clear secret fields
+	Mask func(c *gin.Context, row *domainx.Complex[T])
+}
+
+// Resource registers the REST routes of the table of T on group:
+// GET path (paged, filtered and sorted list), GET path/:id, POST path, PATCH path/:id (partial update) and DELETE path/:id
+func Resource[T any, PT interface {
+	*T
+	dx.DTable
+}](group *gin.RouterGroup, path string, opts ResourceOptions[T]) {
+	r := &resource[T, PT]{opts: opts}
+	conType, _, _ := PT(new(T)).DConfig()
+	r.fields = domainx.UpdateFields[T](conType)
+	if tenanted, ok := any(PT(new(T))).(dx.Tenanted); ok {
+		r.tenant = tenanted.DTenant()
+	}
+	if r.enabled(ActionList) {
+		group.GET(path, r.list)
+	}
+	if r.enabled(ActionGet) {
+		group.GET(path+"/:id", r.get)
+	}
+	if r.enabled(ActionCreate) {
+		group.POST(path, r.create)
+	}
+	if r.enabled(ActionUpdate) {
+		group.PATCH(path+"/:id", r.update)
+	}
+	if r.enabled(ActionDelete) {
+		group.DELETE(path+"/:id", r.delete)
+	}
+}
+
+type resource[T any, PT interface {
+	*T
+	dx.DTable
+}] struct {
+	opts   ResourceOptions[T]
+	fields map[string]string // json name to update map name of the fields of T
+	tenant string
+}
+
+func (r *resource[T, PT]) enabled(action Action) bool {
+	return len(r.opts.Actions) == 0 || slices.Contains(r.opts.Actions, action)
+}
+
+func (r *resource[T, PT]) authorize(c *gin.Context, action Action, row *domainx.Complex[T]) bool {
+	if r.opts.Authorize == nil {
+		return true
+	}
+	if err := r.opts.Authorize(c, action, row); err != nil {
+		response.ReturnJson(c, http.StatusForbidden, http.StatusForbidden, err.Message, nil)
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+func (r *resource[T, PT]) mask(c *gin.Context, rows ...*domainx.Complex[T]) {
+	if r.opts.Mask == nil {
+		return
+	}
+	for _, row := range rows {
+		r.opts.Mask(c, row)
+	}
+}
+
+// load reads the record of the id param, false if the response was written
+func (r *resource[T, PT]) load(c *gin.Context, action Action) (*domainx.Complex[T], bool) {
+	id, pErr := strconv.ParseInt(c.Param("id"), 10, 64)
+	if pErr != nil || id <= 0 {
+		response.ErrorParam(c, &filter.FieldError{Field: "id", Message: "must be a positive integer"})
+		return nil, false
+	}
+	row, err := dx.On[T, PT](c).WithID(id).Get()
+	if err != nil {
+		apix.HandleError(c, consts.CurdSelectFailCode, nil, err)
+		return nil, false
+	}
+	if row == nil || row.IsNil() {
+		response.ReturnJson(c, http.StatusNotFound, http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
+		c.Abort()
+		return nil, false
+	}
+	return row, r.authorize(c, action, row)
+}
+
+func (r *resource[T, PT]) list(c *gin.Context) {
+	if !r.authorize(c, ActionList, nil) {
+		return
+	}
+	query, err := filter.Parse(c, r.opts.Filter)
+	if err != nil {
+		return
+	}
+	pageReq, err := apix.GetPageReq(c)
+	if err != nil {
+		if !c.IsAborted() {
+			response.ValidatorError(c, err)
+		}
+		return
+	}
+	q := filter.Apply(dx.On[T, PT](c), query)
+	if r.opts.Scope != nil {
+		q = r.opts.Scope(c, q)
+	}
+	var resp *load.PageRespT[*domainx.Complex[T]]
+	if pageReq.Cursor != "" {
+		resp, err = q.PageCursor(pageReq.Cursor, pageReq.Size)
+	} else {
+		resp, err = q.Page(pageReq.Page, pageReq.Size, pageReq.LastID)
+	}
+	if err != nil {
+		apix.HandleError(c, consts.CurdSelectFailCode, nil, err)
+		return
+	}
+	if resp.Result != nil {
+		r.mask(c, *resp.Result...)
+	}
+	response.Success(c, "", resp)
+}
+
+func (r *resource[T, PT]) get(c *gin.Context) {
+	row, ok := r.load(c, ActionGet)
+	if !ok {
+		return
+	}
+	r.mask(c, row)
+	response.Success(c, "", row)
+}
+
+func (r *resource[T, PT]) create(c *gin.Context) {
+	data := new(T)
+	if bErr := c.ShouldBindJSON(data); bErr != nil {
+		response.ValidatorError(c, bErr)
+		return
+	}
+	q := dx.On[T, PT](c, data)
+	if !r.authorize(c, ActionCreate, q.Complex()) {
+		return
+	}
+	if _, err := q.Save(); err != nil {
+		apix.HandleError(c, consts.CurdCreatFailCode, nil, err)
+		return
+	}
+	row := q.Complex()
+	r.mask(c, row)
+	response.Success(c, "", row)
+}
+
+func (r *resource[T, PT]) update(c *gin.Context) {
+	var data map[string]interface{}
+	if bErr := c.ShouldBindJSON(&data); bErr != nil {
+		response.ValidatorError(c, bErr)
+		return
+	}
+	if len(data) == 0 {
+		response.ErrorParam(c, &filter.FieldError{Field: "body", Message: "no field to update"})
+		return
+	}
+	updates := make(map[string]interface{}, len(data))
+	for field, value := range data {
+		name, ok := r.updatable(field)
+		if !ok {
+			response.ErrorParam(c, &filter.FieldError{Field: field, Message: "is not updatable"})
+			return
+		}
+		updates[name] = value
+	}
+	row, ok := r.load(c, ActionUpdate)
+	if !ok {
+		return
+	}
+	id := row.GetID().Int64()
+	if err := dx.On[T, PT](c).WithID(id).Updates(updates); err != nil {
+		apix.HandleError(c, consts.CurdUpdateFailCode, nil, err)
+		return
+	}
+	row, err := dx.On[T, PT](c).Primary().WithID(id).Get()
+	if err != nil {
+		apix.HandleError(c, consts.CurdSelectFailCode, nil, err)
+		return
+	}
+	r.mask(c, row)
+	response.Success(c, "", row)
+}
+
+// updatable returns the update map name of the json field of a partial update, false if the field may not be set
+func (r *resource[T, PT]) updatable(field string) (string, bool) {
+	if field == "id" || strings.HasPrefix(field, "$") || strings.HasPrefix(field, "con.") || strings.HasPrefix(field, "options.") {
+		return "", false
+	}
+	if len(r.opts.Updatable) > 0 && !slices.Contains(r.opts.Updatable, field) {
+		return "", false
+	}
+	name, ok := r.fields[field]
+	if !ok || name == "id" || (r.tenant != "" && (name == r.tenant || field == r.tenant)) {
+		return "", false
+	}
+	return name, true
+}
+
+func (r *resource[T, PT]) delete(c *gin.Context) {
+	row, ok := r.load(c, ActionDelete)
+	if !ok {
+		return
+	}
+	if err := dx.On[T, PT](c).WithID(row.GetID().Int64()).Delete(); err != nil {
+		apix.HandleError(c, consts.CurdDeleteFailCode, nil, err)
+		return
+	}
+	response.S(c)
+}
@@ -0,0 +1,31 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestComplexJSONID(t *testing.T) {
+	row := &domainx.Complex[TestModel]{Con: &domainx.Con{ID: 1311768467294899695}, Data: &TestModel{TestField1: "x"}}
+
+	b, err := json.Marshal(row)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"id":"1311768467294899695"`)
+	assert.Contains(t, string(b), `"testField1":"x"`)
+
+	viper.Set("domainx.id.string", false)
+	defer viper.Set("domainx.id.string", nil)
+	b, err = json.Marshal(row)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"id":1311768467294899695`)
+
+	for _, body := range []string{`{"id":"1311768467294899695","data":{"testField1":"y"}}`, `{"id":1311768467294899695,"data":{"testField1":"y"}}`} {
+		var decoded domainx.Complex[TestModel]
+		assert.Nil(t, json.Unmarshal([]byte(body), &decoded))
+		assert.Equal(t, int64(1311768467294899695), decoded.GetID().Int64())
+		assert.Equal(t, "y", decoded.Data.TestField1)
+	}
+}
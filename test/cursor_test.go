@@ -0,0 +1,48 @@
+package test
+
+import (
+	"github.com/jom-io/gorig/domainx"
+	"testing"
+	"time"
+)
+
+func TestPageCursor_RoundTrip(t *testing.T) {
+	sorts := domainx.Sorts{}
+	sorts.AddSort("created_at", false)
+	sorts.AddSort("score", true)
+	createdAt := time.Date(2024, 5, 1, 10, 30, 0, 123, time.UTC)
+
+	cursor := domainx.NewPageCursor(sorts, []interface{}{createdAt, int64(42)}, 1001, false)
+	if cursor == "" {
+		t.Fatal("Expected a cursor")
+	}
+	cur, err := domainx.DecodePageCursor(cursor, sorts)
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	if cur.ID != 1001 || cur.Prev {
+		t.Fatalf("Unexpected cursor position: %+v", cur)
+	}
+	values, vErr := cur.SortValues()
+	if vErr != nil {
+		t.Fatalf("Failed to read cursor values: %v", vErr)
+	}
+	if ts, ok := values[0].(time.Time); !ok || !ts.Equal(createdAt) {
+		t.Fatalf("Expected time value %v, got %v", createdAt, values[0])
+	}
+	if n, ok := values[1].(int64); !ok || n != 42 {
+		t.Fatalf("Expected int64 value 42, got %v", values[1])
+	}
+
+	other := domainx.Sorts{}
+	other.AddSort("created_at", true)
+	if _, err = domainx.DecodePageCursor(cursor, other); err == nil {
+		t.Fatal("Expected a cursor of another sort to be rejected")
+	}
+	if _, err = domainx.DecodePageCursor("not-a-cursor", sorts); err == nil {
+		t.Fatal("Expected an invalid cursor to be rejected")
+	}
+	if domainx.NewPageCursor(sorts, []interface{}{nil, 1}, 1, false) != "" {
+		t.Fatal("Expected no cursor for null sort values")
+	}
+}
@@ -4,7 +4,12 @@ import (
 	"context"
 	"github.com/jom-io/gorig/domainx"
 	"github.com/jom-io/gorig/domainx/dx"
+	"github.com/jom-io/gorig/domainx/migrate"
+	"github.com/jom-io/gorig/domainx/outbox"
+	"github.com/jom-io/gorig/mid/messagex"
 	"github.com/jom-io/gorig/serv"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/datatypes"
 	"strings"
@@ -32,6 +37,99 @@ func (t *TestModel) DConfig() (domainx.ConType, string, string) {
 	return domainx.Mongo, "main", "test_model"
 }
 
+type AuditModel struct {
+	Name  string `gorm:"column:name;type:varchar(64)" bson:"name" json:"name"`
+	Score int    `gorm:"column:score;type:int" bson:"score" json:"score"`
+}
+
+func (a *AuditModel) DConfig() (domainx.ConType, string, string) {
+	return domainx.Mongo, "main", "test_audit_model"
+}
+
+func (a *AuditModel) DAudit() bool {
+	return true
+}
+
+type TenantModel struct {
+	TenantID string `gorm:"column:tenant_id;type:varchar(64)" bson:"tenant_id" json:"tenantID"`
+	Name     string `gorm:"column:name;type:varchar(64)" bson:"name" json:"name"`
+}
+
+func (m *TenantModel) DConfig() (domainx.ConType, string, string) {
+	return domainx.Mongo, "main", "test_tenant_model"
+}
+
+func (m *TenantModel) DTenant() string {
+	return "tenant_id"
+}
+
+type HookModel struct {
+	Name   string `gorm:"column:name;type:varchar(64)" bson:"name" json:"name" validate:"required,max=16"`
+	Slug   string `gorm:"column:slug;type:varchar(64)" bson:"slug" json:"slug"`
+	Locked bool   `gorm:"column:locked;type:tinyint(1)" bson:"locked" json:"locked"`
+}
+
+func (m *HookModel) DConfig() (domainx.ConType, string, string) {
+	return domainx.Mongo, "main", "test_hook_model"
+}
+
+func (m *HookModel) BeforeSave(ctx context.Context) *errors.Error {
+	m.Slug = strings.ToLower(m.Name)
+	return nil
+}
+
+func (m *HookModel) Validate() *errors.Error {
+	if m.Slug == "admin" {
+		return errors.Verify("name is reserved")
+	}
+	return nil
+}
+
+func (m *HookModel) BeforeDelete(ctx context.Context) *errors.Error {
+	if m.Locked {
+		return errors.Verify("record is locked")
+	}
+	return nil
+}
+
+type PartitionModel struct {
+	Name string    `gorm:"column:name;type:varchar(64)" bson:"name" json:"name"`
+	At   time.Time `gorm:"column:at" bson:"at" json:"at"`
+}
+
+func (m *PartitionModel) DConfig() (domainx.ConType, string, string) {
+	return domainx.Mongo, "main", "test_partition_model"
+}
+
+func (m *PartitionModel) DPartition() domainx.Partition {
+	return domainx.Partition{Field: "at", Period: domainx.Monthly, Retain: 3}
+}
+
+type RelUser struct {
+	Name string `gorm:"column:name;type:varchar(64)" bson:"name" json:"name"`
+}
+
+func (m *RelUser) DConfig() (domainx.ConType, string, string) {
+	return domainx.Mongo, "main", "test_rel_user"
+}
+
+func (m *RelUser) DRelations() []dx.Relation {
+	return []dx.Relation{dx.HasMany[RelOrder]("orders", "user_id")}
+}
+
+type RelOrder struct {
+	UserID int64  `gorm:"column:user_id" bson:"user_id" json:"userID"`
+	No     string `gorm:"column:no;type:varchar(64)" bson:"no" json:"no"`
+}
+
+func (m *RelOrder) DConfig() (domainx.ConType, string, string) {
+	return domainx.Mongo, "main", "test_rel_order"
+}
+
+func (m *RelOrder) DRelations() []dx.Relation {
+	return []dx.Relation{dx.BelongsTo[RelUser]("user", "user_id")}
+}
+
 func setupTestModel() *TestModel {
 	testModel := &TestModel{
 		TestField1: "example",
@@ -56,6 +154,7 @@ func TestTestModel_CRUD(t *testing.T) {
 		return dx.On[TestModel](ctx).Complex()
 	}, domainx.CtIdx(domainx.Idx, "test_field1"),
 		domainx.CtIdx(domainx.Idx, "test_field6"),
+		domainx.CtIdx(domainx.Text, "test_field1"),
 	)
 
 	if codeErr := serv.StartCode(domainx.ServiceCode); codeErr != nil {
@@ -250,6 +349,57 @@ func TestTestModel_CRUD(t *testing.T) {
 		}
 	})
 
+	t.Run("VersionConflict", func(t *testing.T) {
+		versionID, err := dx.On[TestModel](ctx, setupTestModel()).Save()
+		if err != nil {
+			t.Fatalf("Failed to save model for version test: %v", err)
+		}
+		defer func() {
+			err = dx.On[TestModel](ctx).WithID(versionID).Delete()
+			if err != nil {
+				t.Fatalf("Failed to delete version test model: %v", err)
+			}
+		}()
+
+		loaded, err := dx.On[TestModel](ctx).WithID(versionID).Get()
+		if err != nil || loaded.IsNil() {
+			t.Fatalf("Failed to get model for version test: %v", err)
+		}
+		if loaded.Version != 1 {
+			t.Fatalf("Expected new record version 1, got %d", loaded.Version)
+		}
+
+		err = dx.On[TestModel](ctx).WithID(versionID).WithVersion(loaded.Version).Update("test_field2", 500)
+		if err != nil {
+			t.Fatalf("Failed to update with matching version: %v", err)
+		}
+
+		err = dx.On[TestModel](ctx).WithID(versionID).WithVersion(loaded.Version).Update("test_field2", 600)
+		if !domainx.IsConflict(err) {
+			t.Fatalf("Expected version conflict on stale update, got: %v", err)
+		}
+
+		loaded.Data.TestField1 = "stale"
+		if _, err = domainx.Save(loaded.Con, loaded); !domainx.IsConflict(err) {
+			t.Fatalf("Expected version conflict on stale save, got: %v", err)
+		}
+
+		current, err := dx.On[TestModel](ctx).WithID(versionID).Get()
+		if err != nil {
+			t.Fatalf("Failed to get model after version conflict: %v", err)
+		}
+		if current.Version != 2 || current.Data.TestField2 != 500 {
+			t.Fatalf("Expected version 2 with test_field2 500, got version %d: %+v", current.Version, current.Data)
+		}
+		current.Data.TestField1 = "fresh"
+		if _, err = domainx.Save(current.Con, current); err != nil {
+			t.Fatalf("Failed to save with current version: %v", err)
+		}
+		if current.Version != 3 {
+			t.Fatalf("Expected version 3 after save, got %d", current.Version)
+		}
+	})
+
 	t.Run("Get", func(t *testing.T) {
 		result, err := dx.On[TestModel](ctx).WithID(id).Get()
 		if err != nil {
@@ -310,6 +460,35 @@ func TestTestModel_CRUD(t *testing.T) {
 		t.Logf("Model count: %d", count)
 	})
 
+	t.Run("Iter", func(t *testing.T) {
+		count, err := dx.On[TestModel](ctx).Eq("test_field1", "example").Count()
+		if err != nil {
+			t.Fatalf("Failed to count models for iteration: %v", err)
+		}
+		var iterated int64
+		for item, iErr := range dx.On[TestModel](ctx).Eq("test_field1", "example").Iter(2) {
+			if iErr != nil {
+				t.Fatalf("Failed to iterate models: %v", iErr)
+			}
+			if item.Data == nil || item.Data.TestField1 != "example" {
+				t.Fatalf("Unexpected iterated model: %+v", item.Data)
+			}
+			iterated++
+		}
+		if iterated != count {
+			t.Fatalf("Expected %d iterated models, got %d", count, iterated)
+		}
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		for _, iErr := range dx.On[TestModel](canceled).Iter() {
+			if iErr == nil {
+				t.Fatal("Expected an error for a canceled context")
+			}
+			break
+		}
+	})
+
 	t.Run("Sum", func(t *testing.T) {
 		sum, err := dx.On[TestModel](ctx).Sum("test_field4")
 		if err != nil {
@@ -332,6 +511,65 @@ func TestTestModel_CRUD(t *testing.T) {
 		}
 	})
 
+	t.Run("PageCursor", func(t *testing.T) {
+		query := func() dx.DQuery[TestModel] {
+			return dx.On[TestModel](ctx).Sort("test_field2", true)
+		}
+		first, err := query().PageCursor("", 1)
+		if err != nil {
+			t.Fatalf("Failed to load first cursor page: %v", err)
+		}
+		if len(*first.Result) == 0 || first.NextCursor == "" {
+			t.Skip("Not enough records for cursor paging")
+		}
+		second, err := query().PageCursor(first.NextCursor, 1)
+		if err != nil {
+			t.Fatalf("Failed to load next cursor page: %v", err)
+		}
+		if len(*second.Result) != 1 || second.PrevCursor == "" {
+			t.Fatalf("Expected one record and a prev cursor, got %d records", len(*second.Result))
+		}
+		a, b := (*first.Result)[0], (*second.Result)[0]
+		if a.GetID() == b.GetID() || a.Data.TestField2 > b.Data.TestField2 {
+			t.Fatalf("Expected the next page to follow the sort, got %d then %d", a.Data.TestField2, b.Data.TestField2)
+		}
+		back, err := query().PageCursor(second.PrevCursor, 1)
+		if err != nil {
+			t.Fatalf("Failed to load prev cursor page: %v", err)
+		}
+		if len(*back.Result) != 1 || (*back.Result)[0].GetID() != a.GetID() {
+			t.Fatal("Expected the prev cursor to return the first page")
+		}
+		if _, err = dx.On[TestModel](ctx).Sort("test_field1", true).PageCursor(first.NextCursor, 1); err == nil {
+			t.Fatal("Expected an error for a cursor of another sort")
+		}
+	})
+
+	t.Run("Aggregate", func(t *testing.T) {
+		agg := domainx.NewAggregation("test_field1").
+			Count("cnt").
+			Sum("test_field4", "total").
+			Max("test_field2", "max_field2").
+			CountDistinct("test_field3", "distinct_field3").
+			HavingBy("cnt", domainx.MGte, 1).
+			OrderBy("total", false).
+			Paging(1, 10)
+		pageResp, err := dx.On[TestModel](ctx).Aggregate(agg)
+		if err != nil {
+			t.Fatalf("Failed to aggregate models: %v", err)
+		}
+		if pageResp == nil || pageResp.Result == nil || len(*pageResp.Result) == 0 {
+			t.Fatal("Expected non-empty aggregate groups")
+		}
+		for _, item := range *pageResp.Result {
+			if item.Value["cnt"] < 1 {
+				t.Fatalf("Expected group count >= 1, got: %+v", item)
+			}
+			t.Logf("Aggregate group: %+v, value: %+v", item.Group, item.Value)
+		}
+		t.Logf("Aggregate total groups: %d", pageResp.Total.Get())
+	})
+
 	t.Run("Has", func(t *testing.T) {
 		filed := "test_field5"
 		testModel := setupTestModel()
@@ -428,6 +666,541 @@ func TestTestModel_CRUD(t *testing.T) {
 		assert.Equal(t, 0, result.Data.TestField2)
 	})
 
+	t.Run("Search", func(t *testing.T) {
+		results, err := dx.On[TestModel](ctx).Search([]string{"test_field1"}, "example").Find()
+		if err != nil {
+			t.Fatalf("Failed to search models: %v", err)
+		}
+		if len(results) == 0 {
+			t.Fatal("Expected non-empty results from Search")
+		}
+		page, err := dx.On[TestModel](ctx).Search([]string{"test_field1"}, "example").Page(1, 5)
+		if err != nil {
+			t.Fatalf("Failed to page searched models: %v", err)
+		}
+		assert.NotEmpty(t, *page.Result)
+		none, err := dx.On[TestModel](ctx).Search([]string{"test_field1"}, "nonexistent").Find()
+		if err != nil {
+			t.Fatalf("Failed to search models: %v", err)
+		}
+		assert.Empty(t, none)
+	})
+
+	t.Run("AtomicUpdate", func(t *testing.T) {
+		model := setupTestModel()
+		model.TestField2 = 1
+		model.TestField6 = []string{"A"}
+		newID, err := dx.On[TestModel](ctx, model).Save()
+		if err != nil {
+			t.Fatalf("Failed to save model: %v", err)
+		}
+		changes := dx.Inc("test_field2", 2)
+		if conType, _, _ := model.DConfig(); conType == domainx.Mongo {
+			changes.AddToSet("test_field6", "A", "B")
+		}
+		if err = dx.On[TestModel](ctx).WithID(newID).Updates(changes); err != nil {
+			t.Fatalf("Failed to update model atomically: %v", err)
+		}
+		before, err := dx.On[TestModel](ctx).WithID(newID).FindOneAndUpdate(dx.Inc("test_field2", 1), false)
+		if err != nil {
+			t.Fatalf("Failed to find and update model: %v", err)
+		}
+		assert.NotNil(t, before)
+		assert.Equal(t, 3, before.Data.TestField2)
+		after, err := dx.On[TestModel](ctx).WithID(newID).FindOneAndUpdate(dx.Max("test_field2", 10), true)
+		if err != nil {
+			t.Fatalf("Failed to find and update model: %v", err)
+		}
+		assert.NotNil(t, after)
+		assert.Equal(t, 10, after.Data.TestField2)
+		if conType, _, _ := model.DConfig(); conType == domainx.Mongo {
+			assert.Equal(t, []string{"A", "B"}, after.Data.TestField6)
+		}
+		none, err := dx.On[TestModel](ctx).Eq("test_field1", "nonexistent").FindOneAndUpdate(dx.Inc("test_field2", 1), true)
+		if err != nil {
+			t.Fatalf("Failed to find and update model: %v", err)
+		}
+		assert.Nil(t, none)
+	})
+
+	t.Run("SaveManyAndBulkWrite", func(t *testing.T) {
+		list := make([]*TestModel, 0, 5)
+		for i := 0; i < 5; i++ {
+			model := setupTestModel()
+			model.TestField1 = "bulk"
+			model.TestField2 = i
+			list = append(list, model)
+		}
+		saved, err := dx.On[TestModel](ctx).SaveMany(list, 2)
+		if err != nil {
+			t.Fatalf("Failed to save many models: %v", err)
+		}
+		if saved.HasFailures() || saved.Inserted != 5 || len(saved.IDs) != 5 {
+			t.Fatalf("Expected 5 inserted rows, got: %+v", saved)
+		}
+
+		result, err := dx.On[TestModel](ctx).BulkWrite(
+			dx.BulkInsert(setupTestModel()),
+			dx.BulkUpdate[TestModel](saved.IDs[0], nil, map[string]interface{}{"test_field2": 500}),
+			dx.BulkDelete[TestModel](0, domainx.NewMatches().Eq("test_field1", "bulk").Ne("test_field2", 500)),
+			dx.BulkDelete[TestModel](0, nil),
+		)
+		if err != nil {
+			t.Fatalf("Failed to bulk write: %v", err)
+		}
+		if len(result.Failures) != 1 || result.Failures[0].Index != 3 {
+			t.Fatalf("Expected only the delete without condition to fail, got: %+v", result.Failures)
+		}
+		if result.Inserted != 1 || result.Deleted != 4 {
+			t.Fatalf("Expected 1 inserted and 4 deleted rows, got: %+v", result)
+		}
+
+		getResult, err := dx.On[TestModel](ctx).WithID(saved.IDs[0]).Get()
+		if err != nil {
+			t.Fatalf("Failed to get model after bulk update: %v", err)
+		}
+		if getResult.Data.TestField2 != 500 {
+			t.Fatalf("Expected TestField2 to be 500, got: %d", getResult.Data.TestField2)
+		}
+		if err = dx.On[TestModel](ctx).Eq("test_field1", "bulk").Delete(); err != nil {
+			t.Fatalf("Failed to clean bulk models: %v", err)
+		}
+	})
+
+	t.Run("Hooks", func(t *testing.T) {
+		_, err := dx.On[HookModel](ctx, &HookModel{}).Save()
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Message, "name failed on required")
+		_, err = dx.On[HookModel](ctx, &HookModel{Name: "Admin"}).Save()
+		assert.NotNil(t, err)
+		assert.Equal(t, "name is reserved", err.Message)
+
+		hookID, err := dx.On[HookModel](ctx, &HookModel{Name: "Locked", Locked: true}).Save()
+		if err != nil {
+			t.Fatalf("Failed to save hook model: %v", err)
+		}
+		row, err := dx.On[HookModel](ctx).WithID(hookID).Get()
+		if err != nil {
+			t.Fatalf("Failed to get hook model: %v", err)
+		}
+		assert.Equal(t, "locked", row.Data.Slug)
+		err = dx.On[HookModel](ctx).WithID(hookID).Delete()
+		assert.NotNil(t, err)
+		assert.Equal(t, "record is locked", err.Message)
+		if err = dx.On[HookModel](ctx).WithID(hookID).Update("locked", false); err != nil {
+			t.Fatalf("Failed to unlock hook model: %v", err)
+		}
+		if err = dx.On[HookModel](ctx).WithID(hookID).Delete(); err != nil {
+			t.Fatalf("Failed to delete hook model: %v", err)
+		}
+	})
+
+	t.Run("Partition", func(t *testing.T) {
+		now := time.Now()
+		last := now.AddDate(0, -1, 0)
+		for _, m := range []*PartitionModel{{Name: "now", At: now}, {Name: "last", At: last}} {
+			if _, err := dx.On[PartitionModel](ctx, m).Save(); err != nil {
+				t.Fatalf("Failed to save partitioned model: %v", err)
+			}
+		}
+		rows, err := dx.On[PartitionModel](ctx).Gte("at", last.Add(-time.Hour)).Sort("at").Find()
+		if err != nil {
+			t.Fatalf("Failed to find partitioned models: %v", err)
+		}
+		if assert.Len(t, rows, 2) {
+			assert.Equal(t, "now", rows[0].Data.Name)
+			assert.Equal(t, "last", rows[1].Data.Name)
+		}
+		count, err := dx.On[PartitionModel](ctx).Gte("at", now.Add(-time.Hour)).Count()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), count)
+		pageResp, err := dx.On[PartitionModel](ctx).Sort("at").Page(2, 1, 0)
+		if err != nil {
+			t.Fatalf("Failed to page partitioned models: %v", err)
+		}
+		assert.Equal(t, int64(2), pageResp.Total.Get())
+		if assert.Len(t, *pageResp.Result, 1) {
+			assert.Equal(t, "last", (*pageResp.Result)[0].Data.Name)
+		}
+		if err = dx.On[PartitionModel](ctx).In("name", []string{"now", "last"}).Delete(); err != nil {
+			t.Fatalf("Failed to delete partitioned models: %v", err)
+		}
+	})
+
+	t.Run("Audit", func(t *testing.T) {
+		auditID, err := dx.On[AuditModel](ctx, &AuditModel{Name: "audit", Score: 1}).Save()
+		if err != nil {
+			t.Fatalf("Failed to save audited model: %v", err)
+		}
+		if err = dx.On[AuditModel](ctx).WithID(auditID).Update("score", 2); err != nil {
+			t.Fatalf("Failed to update audited model: %v", err)
+		}
+		if err = dx.On[AuditModel](ctx).WithID(auditID).Update("score", domainx.Inc(1)); err != nil {
+			t.Fatalf("Failed to increment audited model: %v", err)
+		}
+		if err = dx.On[AuditModel](ctx).WithID(auditID).Delete(); err != nil {
+			t.Fatalf("Failed to delete audited model: %v", err)
+		}
+
+		history, err := dx.On[AuditModel](ctx).WithID(auditID).History(1, 10)
+		if err != nil {
+			t.Fatalf("Failed to load audit history: %v", err)
+		}
+		records := *history.Result
+		if len(records) != 4 {
+			t.Fatalf("Expected 4 audit records, got %d", len(records))
+		}
+		ops := []domainx.AuditOp{domainx.AuditDelete, domainx.AuditUpdate, domainx.AuditUpdate, domainx.AuditCreate}
+		for i, r := range records {
+			if r.Data.Op != ops[i] || r.Data.EntityID != auditID {
+				t.Fatalf("Unexpected audit record %d: %+v", i, r.Data)
+			}
+		}
+		// the increment is audited with the value read back, not the operator
+		for i, values := range [][2]int{{2, 3}, {1, 2}} {
+			change, ok := records[i+1].Data.Diff["score"]
+			if !ok || len(records[i+1].Data.Diff) != 1 {
+				t.Fatalf("Expected only score in the update diff, got %+v", records[i+1].Data.Diff)
+			}
+			assert.EqualValues(t, values[0], change.Before)
+			assert.EqualValues(t, values[1], change.After)
+		}
+
+		audit := domainx.CreateComplex[domainx.AuditRecord](ctx, domainx.Mongo, "main", domainx.AuditTable(), nil)
+		_ = domainx.DeleteByMatch(audit.Con, *domainx.NewMatches().Eq("entity_id", auditID))
+	})
+
+	t.Run("Outbox", func(t *testing.T) {
+		topic := "test.model.saved"
+		received := make(chan *messagex.Message, 1)
+		subID, mErr := messagex.RegisterTopic(topic, func(msg *messagex.Message) *errors.Error {
+			received <- msg
+			return nil
+		})
+		assert.Nil(t, mErr)
+		defer messagex.UnSubscribe(topic, subID)
+
+		model := setupTestModel()
+		outboxID, err := dx.On[TestModel](ctx, model).Save()
+		if err != nil {
+			t.Fatalf("Failed to save model for outbox test: %v", err)
+		}
+		defer func() {
+			_ = dx.On[TestModel](ctx).WithID(outboxID).Delete()
+		}()
+		if err = dx.On[TestModel](ctx).WithID(outboxID).Emit(topic, model); err != nil {
+			t.Fatalf("Failed to emit outbox event: %v", err)
+		}
+
+		relay := outbox.AddRelay(domainx.Mongo, "main", messagex.Local)
+		if _, err = relay.Dispatch(ctx); err != nil {
+			t.Fatalf("Failed to dispatch outbox: %v", err)
+		}
+		select {
+		case msg := <-received:
+			assert.Equal(t, "example", msg.Content["testField1"])
+		case <-time.After(3 * time.Second):
+			t.Fatal("Expected the outbox event to be published")
+		}
+
+		events := domainx.OutboxComplex(ctx, domainx.Mongo, "main")
+		var list []*domainx.Complex[domainx.OutboxEvent]
+		if err = domainx.FindByMatch(events.Con, *domainx.NewMatches().Eq("entity_id", outboxID), &list); err != nil {
+			t.Fatalf("Failed to find outbox events: %v", err)
+		}
+		if len(list) != 1 || list[0].Data.Status != domainx.OutboxDispatched {
+			t.Fatalf("Expected one dispatched outbox event, got %d", len(list))
+		}
+		_ = domainx.DeleteByMatch(events.Con, *domainx.NewMatches().Eq("entity_id", outboxID))
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		cacheID, err := dx.On[TestModel](ctx, setupTestModel()).Save()
+		if err != nil {
+			t.Fatalf("Failed to save model for cache test: %v", err)
+		}
+		defer func() {
+			_ = dx.On[TestModel](ctx).WithID(cacheID).Delete()
+		}()
+		if _, err = dx.On[TestModel](ctx).WithID(cacheID).Cached(time.Minute).Get(); err != nil {
+			t.Fatalf("Failed to get cached model: %v", err)
+		}
+
+		// a write outside dx is not seen until the cache is invalidated
+		raw := domainx.CreateComplex[TestModel](ctx, domainx.Mongo, "main", "test_model", nil)
+		if err = domainx.UpdatePart(raw.Con, cacheID, map[string]interface{}{"test_field2": 7}); err != nil {
+			t.Fatalf("Failed to update model outside dx: %v", err)
+		}
+		result, err := dx.On[TestModel](ctx).WithID(cacheID).Cached(time.Minute).Get()
+		if err != nil {
+			t.Fatalf("Failed to get cached model: %v", err)
+		}
+		assert.Equal(t, cacheID, result.GetID().Int64())
+		assert.Equal(t, 42, result.Data.TestField2)
+
+		if err = dx.On[TestModel](ctx).WithID(cacheID).Update("test_field2", 8); err != nil {
+			t.Fatalf("Failed to update model: %v", err)
+		}
+		result, err = dx.On[TestModel](ctx).WithID(cacheID).Cached(time.Minute).Get()
+		if err != nil {
+			t.Fatalf("Failed to get cached model after update: %v", err)
+		}
+		assert.Equal(t, 8, result.Data.TestField2)
+
+		list, err := dx.On[TestModel](ctx).Eq("test_field2", 8).Cached(time.Minute).Find()
+		if err != nil {
+			t.Fatalf("Failed to find cached models: %v", err)
+		}
+		count, err := dx.On[TestModel](ctx).Eq("test_field2", 8).Cached(time.Minute).Count()
+		if err != nil {
+			t.Fatalf("Failed to count cached models: %v", err)
+		}
+		assert.Equal(t, int64(len(list)), count)
+
+		// a write in a transaction invalidates after the commit, a read before it caches the committed row
+		err = dx.Transaction[TestModel](ctx, func(txCtx context.Context) *errors.Error {
+			if err := dx.On[TestModel](txCtx).WithID(cacheID).Update("test_field2", 9); err != nil {
+				return err
+			}
+			result, err := dx.On[TestModel](ctx).WithID(cacheID).Cached(time.Minute).Get()
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, 8, result.Data.TestField2)
+			result, err = dx.On[TestModel](txCtx).WithID(cacheID).Cached(time.Minute).Get()
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, 9, result.Data.TestField2)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to update model in transaction: %v", err)
+		}
+		result, err = dx.On[TestModel](ctx).WithID(cacheID).Cached(time.Minute).Get()
+		if err != nil {
+			t.Fatalf("Failed to get cached model after commit: %v", err)
+		}
+		assert.Equal(t, 9, result.Data.TestField2)
+
+		// a read inside a rolled back transaction must not leave the uncommitted row in the cache
+		err = dx.Transaction[TestModel](ctx, func(txCtx context.Context) *errors.Error {
+			if err := dx.On[TestModel](txCtx).WithID(cacheID).Update("test_field2", 10); err != nil {
+				return err
+			}
+			result, err := dx.On[TestModel](txCtx).WithID(cacheID).Cached(time.Minute).Get()
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, 10, result.Data.TestField2)
+			return errors.Verify("rollback")
+		})
+		assert.NotNil(t, err)
+		result, err = dx.On[TestModel](ctx).WithID(cacheID).Cached(time.Minute).Get()
+		if err != nil {
+			t.Fatalf("Failed to get cached model after rollback: %v", err)
+		}
+		assert.Equal(t, 9, result.Data.TestField2)
+	})
+
+	t.Run("Tenant", func(t *testing.T) {
+		ctxA := domainx.WithTenant(ctx, "tenant-a")
+		ctxB := domainx.WithTenant(ctx, "tenant-b")
+		tenantID, err := dx.On[TenantModel](ctxA, &TenantModel{Name: "scoped"}).Save()
+		if err != nil {
+			t.Fatalf("Failed to save tenant model: %v", err)
+		}
+		defer func() {
+			_ = dx.On[TenantModel](ctx).Unscoped().Eq("name", "scoped").Delete()
+		}()
+
+		saved, err := dx.On[TenantModel](ctxA).WithID(tenantID).Get()
+		if err != nil {
+			t.Fatalf("Failed to get tenant model: %v", err)
+		}
+		assert.Equal(t, "tenant-a", saved.Data.TenantID)
+
+		count, err := dx.On[TenantModel](ctxB).Eq("name", "scoped").Count()
+		if err != nil {
+			t.Fatalf("Failed to count tenant models: %v", err)
+		}
+		assert.Equal(t, int64(0), count)
+		other, err := dx.On[TenantModel](ctxB).WithID(tenantID).Get()
+		if err != nil {
+			t.Fatalf("Failed to get tenant model of another tenant: %v", err)
+		}
+		assert.Empty(t, other.Data.Name)
+		err = dx.On[TenantModel](ctxB).WithID(tenantID).Update("name", "leaked")
+		assert.True(t, domainx.IsNotFound(err), "expected a not found error, got: %v", err)
+		err = dx.On[TenantModel](ctxB).WithID(tenantID).Delete()
+		assert.True(t, domainx.IsNotFound(err), "expected a not found error, got: %v", err)
+
+		count, err = dx.On[TenantModel](ctx).Unscoped().Eq("name", "scoped").Count()
+		if err != nil {
+			t.Fatalf("Failed to count unscoped tenant models: %v", err)
+		}
+		assert.Equal(t, int64(1), count)
+		if _, err = dx.On[TenantModel](ctx).Eq("name", "scoped").Count(); err == nil {
+			t.Fatal("Expected a scoped query without tenant to fail")
+		}
+	})
+
+	t.Run("Route", func(t *testing.T) {
+		pinCtx := domainx.WithReadYourWrites(ctx, time.Minute)
+		routeID, err := dx.On[TestModel](pinCtx, setupTestModel()).Save()
+		if err != nil {
+			t.Fatalf("Failed to save model for route test: %v", err)
+		}
+		defer func() {
+			_ = dx.On[TestModel](ctx).WithID(routeID).Delete()
+		}()
+		// reads of the pinned context and Primary reads see the write at once
+		result, err := dx.On[TestModel](pinCtx).WithID(routeID).Get()
+		if err != nil {
+			t.Fatalf("Failed to get model on pinned context: %v", err)
+		}
+		assert.Equal(t, routeID, result.GetID().Int64())
+		result, err = dx.On[TestModel](ctx).WithID(routeID).Primary().Get()
+		if err != nil {
+			t.Fatalf("Failed to get model on primary: %v", err)
+		}
+		assert.Equal(t, routeID, result.GetID().Int64())
+		if _, err = dx.On[TestModel](ctx).Replica().Count(); err != nil {
+			t.Fatalf("Failed to count on replica: %v", err)
+		}
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		if _, err := dx.On[TestModel](ctx).Eq("test_field2", 42).Find(); err != nil {
+			t.Fatalf("Failed to find models: %v", err)
+		}
+		found := false
+		for _, m := range domainx.QueryMetrics() {
+			if m.Table == "test_model" && m.Op == "FindByMatch" {
+				found = m.Count > 0 && m.Counts[len(m.Counts)-1] == m.Count
+			}
+		}
+		assert.True(t, found, "FindByMatch on test_model should be counted")
+		var b strings.Builder
+		if err := domainx.WriteQueryMetrics(&b); err != nil {
+			t.Fatalf("Failed to write metrics: %v", err)
+		}
+		assert.Contains(t, b.String(), `domainx_queries_total{con_type="mongo",table="test_model",op="FindByMatch"}`)
+	})
+
+	t.Run("Preload", func(t *testing.T) {
+		userID, err := dx.On[RelUser](ctx, &RelUser{Name: "preload"}).Save()
+		if err != nil {
+			t.Fatalf("Failed to save user: %v", err)
+		}
+		defer func() {
+			_ = dx.On[RelUser](ctx).WithID(userID).Delete()
+			_ = dx.On[RelOrder](ctx).Eq("user_id", userID).Delete()
+		}()
+		for _, no := range []string{"a", "b"} {
+			if _, err = dx.On[RelOrder](ctx, &RelOrder{UserID: userID, No: no}).Save(); err != nil {
+				t.Fatalf("Failed to save order: %v", err)
+			}
+		}
+		users, err := dx.On[RelUser](ctx).Eq("name", "preload").Preload("orders").Find()
+		if err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+		assert.Len(t, users, 1)
+		assert.Len(t, dx.Many[RelOrder](users[0], "orders"), 2)
+
+		orders, err := dx.On[RelOrder](ctx).Eq("user_id", userID).Preload("user").Find()
+		if err != nil {
+			t.Fatalf("Failed to find orders: %v", err)
+		}
+		for _, order := range orders {
+			assert.Equal(t, "preload", dx.One[RelUser](order, "user").Data.Name)
+		}
+	})
+
+	t.Run("Migrate", func(t *testing.T) {
+		var ups, downs int
+		migrate.Register(domainx.Mongo, "main", migrate.Migration{
+			Version: 1,
+			Name:    "test_field7_backfill",
+			Up: func(ctx context.Context, con *domainx.Con) error {
+				ups++
+				return nil
+			},
+			Down: func(ctx context.Context, con *domainx.Con) error {
+				downs++
+				return nil
+			},
+		})
+		pending, err := migrate.DryRun(ctx)
+		if err != nil {
+			t.Fatalf("Failed to dry run migrations: %v", err)
+		}
+		assert.Len(t, pending, 1)
+		assert.Equal(t, 0, ups)
+
+		applied, err := migrate.Up(ctx)
+		if err != nil {
+			t.Fatalf("Failed to apply migrations: %v", err)
+		}
+		assert.Len(t, applied, 1)
+		if applied, err = migrate.Up(ctx); err != nil || len(applied) != 0 {
+			t.Fatalf("Expected no migration to apply twice, got %d: %v", len(applied), err)
+		}
+		assert.Equal(t, 1, ups)
+
+		states, err := migrate.Status(ctx)
+		if err != nil {
+			t.Fatalf("Failed to load migration status: %v", err)
+		}
+		if len(states) != 1 || !states[0].Applied {
+			t.Fatalf("Expected the migration to be applied, got %+v", states)
+		}
+
+		rolledBack, err := migrate.Down(ctx, domainx.Mongo, "main", 1)
+		if err != nil {
+			t.Fatalf("Failed to roll back migration: %v", err)
+		}
+		assert.Len(t, rolledBack, 1)
+		assert.Equal(t, 1, downs)
+	})
+
+	t.Run("IndexDrift", func(t *testing.T) {
+		table := domainx.CreateComplex[TestModel](ctx, domainx.Mongo, "main", "test_index_drift", nil)
+		dbService := domainx.GetDBService(domainx.Mongo)
+		defer func() {
+			_ = dbService.DropTable(table.Con, "test_index_drift")
+		}()
+		byName := domainx.CtIdx(domainx.Idx, "test_field1")
+		if err := dbService.Migrate(table.Con, "test_index_drift", table, []domainx.Index{byName}); err != nil {
+			t.Fatalf("Failed to migrate indexes: %v", err)
+		}
+
+		drifts, err := domainx.CheckIndexes(table.Con, "test_index_drift", []domainx.Index{byName})
+		assert.Nil(t, err)
+		assert.Empty(t, drifts)
+		uniqueName := domainx.CtIdx(domainx.Unique, "test_field1")
+		byScore := domainx.CtIdx(domainx.Idx, "test_field2")
+		drifts, err = domainx.CheckIndexes(table.Con, "test_index_drift", []domainx.Index{uniqueName, byScore})
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []domainx.IndexDrift{
+			{Table: "test_index_drift", Name: "data.test_field1_1", Reason: "changed"},
+			{Table: "test_index_drift", Name: "idx-test_field2", Reason: "missing"},
+		}, drifts)
+		drifts, err = domainx.CheckIndexes(table.Con, "test_index_drift", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, []domainx.IndexDrift{{Table: "test_index_drift", Name: "data.test_field1_1", Reason: "undeclared"}}, drifts)
+
+		viper.Set("domainx.index.reconcile", true)
+		defer viper.Set("domainx.index.reconcile", nil)
+		if err = dbService.Migrate(table.Con, "test_index_drift", table, []domainx.Index{uniqueName}); err != nil {
+			t.Fatalf("Failed to reconcile indexes: %v", err)
+		}
+		drifts, err = domainx.CheckIndexes(table.Con, "test_index_drift", []domainx.Index{uniqueName})
+		assert.Nil(t, err)
+		assert.Empty(t, drifts)
+	})
+
 	t.Run("DeleteByID", func(t *testing.T) {
 		err := dx.On[TestModel](ctx).WithID(id).Delete()
 		if err != nil {
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/utils/encrypt"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type EncryptModel struct {
+	Name       string `bson:"name" json:"name"`
+	Phone      string `bson:"phone" json:"phone" dx:"encrypt,blind=PhoneBlind"`
+	PhoneBlind string `bson:"phone_blind" json:"-"`
+	IDCard     string `bson:"id_card" json:"idCard" dx:"encrypt"`
+}
+
+func TestEncrypt_FieldsAndRotation(t *testing.T) {
+	viper.Set("domainx.encrypt.keys.k1", encrypt.GenerateKey())
+	viper.Set("domainx.encrypt.keys.k2", encrypt.GenerateKey())
+	viper.Set("domainx.encrypt.blindKey", encrypt.GenerateKey())
+	viper.Set("domainx.encrypt.current", "k1")
+	defer viper.Set("domainx.encrypt.current", nil)
+
+	m := &EncryptModel{Name: "n", Phone: "13800000000", IDCard: "110101199001011234"}
+	if err := domainx.EncryptData(m); err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	assert.Equal(t, "n", m.Name)
+	assert.True(t, strings.HasPrefix(m.Phone, "enc:v2:k1:"))
+	assert.True(t, strings.HasPrefix(m.IDCard, "enc:v2:k1:"))
+	blind, _ := domainx.BlindIndex("13800000000")
+	assert.Equal(t, blind, m.PhoneBlind)
+
+	// an equality match on the phone is rewritten to its blind index
+	field, value, bErr := domainx.BlindMatch(domainx.Mongo, reflect.TypeOf(EncryptModel{}), "phone", "13800000000")
+	assert.Nil(t, bErr)
+	assert.Equal(t, "phone_blind", field)
+	assert.Equal(t, blind, value)
+	field, _, bErr = domainx.BlindMatch(domainx.Mongo, reflect.TypeOf(EncryptModel{}), "name", "n")
+	assert.Nil(t, bErr)
+	assert.Equal(t, "name", field)
+	// the ciphertext of a field without blind index cannot be matched
+	_, _, bErr = domainx.BlindMatch(domainx.Mongo, reflect.TypeOf(EncryptModel{}), "id_card", "x")
+	assert.NotNil(t, bErr)
+	matches := domainx.NewMatches().Fail(bErr)
+	_, bErr = domainx.CountByMatch(&domainx.Con{ConType: domainx.Mongo, GTable: "encrypt_model"}, *matches)
+	assert.NotNil(t, bErr)
+
+	// the values of an old key are still read after a rotation, and rewritten with the current key
+	viper.Set("domainx.encrypt.current", "k2")
+	if err := domainx.DecryptData(m); err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	assert.Equal(t, "13800000000", m.Phone)
+	assert.Equal(t, "110101199001011234", m.IDCard)
+	update, err := domainx.EncryptedFields(domainx.Mongo, m)
+	if err != nil {
+		t.Fatalf("Failed to re-encrypt: %v", err)
+	}
+	assert.True(t, strings.HasPrefix(update["phone"].(string), "enc:v2:k2:"))
+	assert.True(t, strings.HasPrefix(update["id_card"].(string), "enc:v2:k2:"))
+	assert.Equal(t, blind, update["phone_blind"])
+
+	// values of the legacy CBC format are still read, a tampered GCM value fails
+	legacy, _ := encrypt.Encrypt("13800000000", viper.GetString("domainx.encrypt.keys.k1"))
+	plain, err := domainx.DecryptValue("enc:k1:" + legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, "13800000000", plain)
+	sealed, err := domainx.EncryptValue("13800000000")
+	assert.Nil(t, err)
+	tampered := []byte(sealed)
+	tampered[len(tampered)-3] ^= 1
+	_, err = domainx.DecryptValue(string(tampered))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jom-io/gorig/apix"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/qiniu/qmgo"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTypedErrors(t *testing.T) {
+	c := &domainx.Con{GTable: "orders"}
+
+	dup := c.HandleWithErr(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a1' for key 'orders.uk_no'"})
+	assert.True(t, domainx.IsDuplicateKey(dup))
+	assert.Equal(t, "uk_no", domainx.DuplicateIndex(dup))
+	assert.ErrorIs(t, dup, domainx.ErrDuplicateKey)
+
+	assert.True(t, domainx.IsConflict(c.HandleWithErr(domainx.ErrVersionConflict)))
+	assert.True(t, domainx.IsConflict(c.HandleWithErr(&mysql.MySQLError{Number: 1213})))
+	assert.True(t, domainx.IsTimeout(c.HandleWithErr(fmt.Errorf("find: %w", context.DeadlineExceeded))))
+	assert.True(t, domainx.IsTimeout(c.HandleWithErr(&mysql.MySQLError{Number: 1205})))
+	assert.ErrorIs(t, errors.NotFound("orders record id 1 not found"), domainx.ErrNotFound)
+	assert.True(t, domainx.IsNotFound(c.HandleWithErr(mongo.ErrNoDocuments)))
+	assert.True(t, domainx.IsNotFound(c.HandleWithErr(fmt.Errorf("update: %w", qmgo.ErrNoSuchDocuments))))
+	assert.True(t, domainx.IsNotFound(c.HandleWithErr(gorm.ErrRecordNotFound)))
+	assert.NotErrorIs(t, errors.Verify("bad input"), domainx.ErrNotFound)
+
+	sysErr := c.HandleWithErr(fmt.Errorf("connection refused"))
+	assert.True(t, sysErr.IsSystem())
+	assert.False(t, domainx.IsTimeout(sysErr))
+}
+
+// fakeDB is the base of the in memory DBServices of the tests, the other operations are left unimplemented
+type fakeDB struct {
+	domainx.DBService
+}
+
+func (fakeDB) Start() error { return nil }
+func (fakeDB) End() error   { return nil }
+
+// registerFakeDB registers s for the test, the service registered before is restored after it.
+// Without one s stays registered, so the fakes start and stop with the domainx service of later tests.
+func registerFakeDB(t *testing.T, conType domainx.ConType, s domainx.DBService) {
+	prev := domainx.GetDBService(conType)
+	domainx.RegisterDBService(conType, s)
+	t.Cleanup(func() {
+		if prev != nil {
+			domainx.RegisterDBService(conType, prev)
+		}
+	})
+}
+
+// noDocsDB finds no document, like the mongo driver
+type noDocsDB struct {
+	fakeDB
+}
+
+func (noDocsDB) GetByID(c *domainx.Con, id int64, result interface{}) error {
+	return qmgo.ErrNoSuchDocuments
+}
+
+func (noDocsDB) GetByMatch(c *domainx.Con, matchList []domainx.Match, result interface{}) error {
+	return mongo.ErrNoDocuments
+}
+
+func (noDocsDB) UpdatePart(c *domainx.Con, id int64, data map[string]interface{}) (int64, error) {
+	return 0, qmgo.ErrNoSuchDocuments
+}
+
+func TestNoDocuments(t *testing.T) {
+	registerFakeDB(t, "nodocs", noDocsDB{})
+	c := &domainx.Con{Ctx: context.Background(), ConType: "nodocs", GTable: "orders"}
+
+	var row domainx.Complex[TestModel]
+	assert.Nil(t, domainx.GetByID(c, 1, &row))
+	assert.Nil(t, domainx.GetByMatch(c, []domainx.Match{{Field: "name", Value: "a", Type: domainx.MEq}}, &row))
+	assert.True(t, domainx.IsNotFound(domainx.UpdatePart(c, 1, map[string]interface{}{"name": "b"})))
+}
+
+func TestHandleTypedError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/:kind", func(c *gin.Context) {
+		switch c.Param("kind") {
+		case "missing":
+			apix.HandleError(c, 400, nil, errors.NotFound("record not found"))
+		case "duplicate":
+			apix.HandleError(c, 400, nil, errors.DuplicateKey("duplicate key"))
+		default:
+			apix.HandleError(c, 400, nil, errors.Verify("bad input"))
+		}
+	})
+
+	for kind, status := range map[string]int{"missing": http.StatusNotFound, "duplicate": http.StatusConflict, "invalid": http.StatusBadRequest} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/"+kind, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, kind)
+	}
+}
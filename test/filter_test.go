@@ -0,0 +1,61 @@
+package test
+
+import (
+	"github.com/jom-io/gorig/apix/filter"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+var filterSchema = filter.Schema{
+	Fields: map[string]filter.Field{
+		"status":     {Type: filter.Int},
+		"age":        {Type: filter.Int, Sortable: true},
+		"tags":       {Type: filter.Strings},
+		"name":       {Type: filter.String, Sortable: true},
+		"created_at": {Type: filter.Time, Sortable: true},
+	},
+	Select: []string{"id", "name"},
+}
+
+func TestFilter_ParseValues(t *testing.T) {
+	values, _ := url.ParseQuery("status=1&age[gte]=18&tags[has_any]=a,b&sort=-created_at,name&fields=id,name&page=2")
+	q, err := filter.ParseValues(values, filterSchema)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	assert.ElementsMatch(t, domainx.Matches{
+		{Field: "age", Value: int64(18), Type: domainx.MGte},
+		{Field: "status", Value: int64(1), Type: domainx.MEq},
+		{Field: "tags", Value: []string{"a", "b"}, Type: domainx.MHasAny},
+	}, q.Matches)
+	assert.Len(t, q.Sorts, 2)
+	assert.Equal(t, "created_at", q.Sorts[0].Field)
+	assert.False(t, q.Sorts[0].Asc)
+	assert.Equal(t, "name", q.Sorts[1].Field)
+	assert.True(t, q.Sorts[1].Asc)
+	assert.Equal(t, []string{"id", "name"}, q.Fields)
+}
+
+func TestFilter_Whitelist(t *testing.T) {
+	cases := map[string]string{
+		"secret[eq]=1":    "secret",
+		"age[like]=1":     "age",
+		"age[gte]=abc":    "age",
+		"sort=status":     "sort",
+		"fields=password": "fields",
+	}
+	for query, field := range cases {
+		values, _ := url.ParseQuery(query)
+		_, err := filter.ParseValues(values, filterSchema)
+		if assert.NotNil(t, err, query) {
+			assert.Equal(t, field, err.Field, query)
+		}
+	}
+	// plain params outside the whitelist belong to the endpoint and are ignored
+	values, _ := url.ParseQuery("keyword=x")
+	q, err := filter.ParseValues(values, filterSchema)
+	assert.Nil(t, err)
+	assert.Empty(t, q.Matches)
+}
@@ -0,0 +1,41 @@
+package test
+
+import (
+	"github.com/jom-io/gorig/utils/geo"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGeo_Geohash(t *testing.T) {
+	assert.Equal(t, "u4pruydqqvj", geo.Encode(57.64911, 10.40744, 11))
+
+	p, err := geo.Decode("u4pruydqqvj")
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	assert.InDelta(t, 57.64911, p.Lat, 1e-5)
+	assert.InDelta(t, 10.40744, p.Lng, 1e-5)
+	_, err = geo.Decode("u4pa")
+	assert.Error(t, err)
+
+	north, _ := geo.Neighbor("dqcjq", geo.North)
+	assert.Equal(t, "dqcjw", north)
+	east, _ := geo.Neighbor("dqcjq", geo.East)
+	assert.Equal(t, "dqcjr", east)
+	neighbors, _ := geo.Neighbors("dqcjq")
+	assert.Len(t, neighbors, 8)
+	assert.NotContains(t, neighbors, "dqcjq")
+
+	// the cells of the antimeridian wrap around
+	west, _ := geo.Neighbor(geo.Encode(0, -179.99, 5), geo.West)
+	assert.Equal(t, geo.Encode(0, 179.99, 5), west)
+}
+
+func TestGeo_InPolygon(t *testing.T) {
+	zone := []geo.Point{{Lat: 0, Lng: 0}, {Lat: 0, Lng: 10}, {Lat: 10, Lng: 10}, {Lat: 10, Lng: 0}}
+	assert.True(t, geo.InPolygon(geo.Point{Lat: 5, Lng: 5}, zone))
+	assert.False(t, geo.InPolygon(geo.Point{Lat: 5, Lng: 15}, zone))
+	assert.Equal(t, geo.Box{Min: geo.Point{}, Max: geo.Point{Lat: 10, Lng: 10}}, geo.Bounds(zone))
+	assert.Equal(t, "POLYGON((0 0,10 0,10 10,0 10,0 0))", geo.WKT(zone))
+	assert.Equal(t, "POINT(2.5 1)", geo.WKT([]geo.Point{{Lat: 1, Lng: 2.5}}))
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/global/consts"
 	"github.com/jom-io/gorig/httpx"
 	"net/http"
 	"net/http/httptest"
@@ -32,3 +33,29 @@ func TestRecoveryMiddleware(t *testing.T) {
 		t.Errorf("expected response to contain error message")
 	}
 }
+
+func TestReadYourWritesMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(httpx.ReadYourWrites())
+	router.GET("/pin", func(c *gin.Context) {
+		pin, ok := c.Get(consts.ReadPinKey)
+		if !ok || pin == nil {
+			t.Errorf("expected a read pin on the gin context")
+		}
+		if ctxPin := c.Request.Context().Value(consts.ReadPinKey); ctxPin != pin {
+			t.Errorf("expected the request context to carry the gin context pin, got %v", ctxPin)
+		}
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/pin", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"context"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/utils/geo"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+	"testing"
+	"time"
+)
+
+// dryMysqlCon builds the statements without a server
+func dryMysqlCon(t *testing.T) *domainx.Con {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "user:pass@tcp(127.0.0.1:3306)/dry", SkipInitializeWithVersion: true}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &domainx.Con{Ctx: context.Background(), ConType: domainx.Mysql, MysqlDB: db, GTable: "test_match"}
+}
+
+// sqlCapture keeps the statements traced by gorm
+type sqlCapture struct {
+	gormLogger.Interface
+	statements []string
+}
+
+func (l *sqlCapture) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	l.statements = append(l.statements, sql)
+}
+
+func TestMysqlUpdate(t *testing.T) {
+	con := dryMysqlCon(t)
+	capture := &sqlCapture{Interface: gormLogger.Discard}
+	con.MysqlDB = con.MysqlDB.Session(&gorm.Session{Logger: capture, SkipDefaultTransaction: true})
+
+	// the data of the caller is not rewritten with sql expressions
+	data := map[string]interface{}{"views": domainx.Inc(1), "tags": domainx.Pull("a_b%", `c\d`)}
+	assert.Nil(t, domainx.UpdatePart(con, 1, data))
+	assert.Equal(t, map[string]interface{}{"views": domainx.Inc(1), "tags": domainx.Pull("a_b%", `c\d`)}, data)
+	if assert.Len(t, capture.statements, 1) {
+		sql := capture.statements[0]
+		assert.Contains(t, sql, "COALESCE(views, 0) + 1")
+		assert.Contains(t, sql, `a\_b\%`)
+		assert.Contains(t, sql, `c\\d`)
+	}
+}
+
+func TestMysqlTextMatch(t *testing.T) {
+	con := dryMysqlCon(t)
+	for _, field := range []string{"username", "asset_name", "title,body", "`title`"} {
+		_, err := domainx.CountByMatch(con, []domainx.Match{{Field: field, Value: "go", Type: domainx.MText}})
+		assert.Nil(t, err, field)
+	}
+	for _, field := range []string{"", "title body", "title;drop", "title,", "(title)"} {
+		_, err := domainx.CountByMatch(con, []domainx.Match{{Field: field, Value: "go", Type: domainx.MText}})
+		assert.NotNil(t, err, field)
+	}
+}
+
+func TestMysqlGeoMatch(t *testing.T) {
+	con := dryMysqlCon(t)
+	box := geo.Box{Min: geo.Point{Lat: 1, Lng: 1}, Max: geo.Point{Lat: 2, Lng: 2}}
+	for _, field := range []string{"area", "user_lat,user_lng"} {
+		_, err := domainx.CountByMatch(con, []domainx.Match{{Field: field, Value: box, Type: domainx.MWithinBox}})
+		assert.Nil(t, err, field)
+	}
+	for _, field := range []string{"lat,lng,alt", "lat;drop", ""} {
+		_, err := domainx.CountByMatch(con, []domainx.Match{{Field: field, Value: box, Type: domainx.MWithinBox}})
+		assert.NotNil(t, err, field)
+	}
+	_, err := domainx.CountByMatch(con, []domainx.Match{{Field: "area", Value: []geo.Point{}, Type: domainx.MWithinPolygon}})
+	assert.NotNil(t, err)
+
+	// a segment is neither a point nor a polygon
+	line := []geo.Point{{Lat: 1, Lng: 1}, {Lat: 2, Lng: 2}}
+	_, err = domainx.CountByMatch(con, *domainx.NewMatches().Intersects("area", line...))
+	assert.NotNil(t, err)
+	_, err = domainx.CountByMatch(con, []domainx.Match{{Field: "area", Value: line, Type: domainx.MIntersects}})
+	assert.NotNil(t, err)
+	_, err = domainx.CountByMatch(con, *domainx.NewMatches().Intersects("area", line[0]))
+	assert.Nil(t, err)
+	_, err = domainx.CountByMatch(con, *domainx.NewMatches().Intersects("area", append(line, geo.Point{Lat: 1, Lng: 2})...))
+	assert.Nil(t, err)
+}
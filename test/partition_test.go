@@ -0,0 +1,191 @@
+package test
+
+import (
+	"github.com/jom-io/gorig/apix/load"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/global/consts"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartitionTable(t *testing.T) {
+	at := time.Date(2026, 1, 2, 15, 4, 5, 0, time.Local)
+	assert.Equal(t, "events_20260102", domainx.Partition{Field: "at", Period: domainx.Daily}.Table("events", at))
+	assert.Equal(t, "events_202601", domainx.Partition{Field: "at", Period: domainx.Monthly}.Table("events", at))
+	assert.Equal(t, "events_2026", domainx.Partition{Field: "at", Period: domainx.Yearly}.Table("events", at))
+}
+
+const memPartition domainx.ConType = "memory"
+
+// memPartitionDB serves the tables of a partitioned table from memory, the rows of a table are kept in its sort order
+type memPartitionDB struct {
+	fakeDB
+	tables  map[string][]*domainx.Complex[PartitionModel]
+	queried []string
+	probes  int
+}
+
+func (m *memPartitionDB) Tables(c *domainx.Con, prefix string) ([]string, error) {
+	var tables []string
+	for table := range m.tables {
+		if strings.HasPrefix(table, prefix) {
+			tables = append(tables, table)
+		}
+	}
+	return append(tables, prefix+"bad", prefix+"2026"), nil
+}
+
+func (m *memPartitionDB) ExistsByMatch(c *domainx.Con, matchList []domainx.Match) (bool, error) {
+	m.probes++
+	for _, row := range m.tables[c.TableName()] {
+		for _, match := range matchList {
+			if match.Field == "id" && match.Value == row.GetID().Int64() {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (m *memPartitionDB) GetByID(c *domainx.Con, id int64, result interface{}) error {
+	for _, row := range m.tables[c.TableName()] {
+		if row.GetID().Int64() == id {
+			*result.(*domainx.Complex[PartitionModel]) = *row
+		}
+	}
+	return nil
+}
+
+func (m *memPartitionDB) FindByMatch(c *domainx.Con, matchList []domainx.Match, result interface{}, prefixes ...string) error {
+	m.queried = append(m.queried, c.TableName())
+	*result.(*[]*domainx.Complex[PartitionModel]) = append([]*domainx.Complex[PartitionModel](nil), m.tables[c.TableName()]...)
+	return nil
+}
+
+func (m *memPartitionDB) FindByPageMatch(c *domainx.Con, matchList []domainx.Match, page *load.Page, total *load.Total, result interface{}, prefixes ...string) error {
+	rows := m.tables[c.TableName()]
+	total.Set(int64(len(rows)))
+	from, to := min(page.Offset(), int64(len(rows))), min(page.Offset()+page.Size, int64(len(rows)))
+	*result.(*[]*domainx.Complex[PartitionModel]) = append([]*domainx.Complex[PartitionModel](nil), rows[from:to]...)
+	return nil
+}
+
+func (m *memPartitionDB) CursorValues(c *domainx.Con, row interface{}) ([]interface{}, error) {
+	return []interface{}{row.(*domainx.Complex[PartitionModel]).Data.Name}, nil
+}
+
+// snowflakeAt is an id generated at t
+func snowflakeAt(t time.Time, seq int64) int64 {
+	return (t.UnixMilli()-consts.StartTimeStamp)<<consts.TimestampShift | seq
+}
+
+func partitionRow(id int64, name string, at time.Time) *domainx.Complex[PartitionModel] {
+	return &domainx.Complex[PartitionModel]{Con: &domainx.Con{ID: id}, Data: &PartitionModel{Name: name, At: at}}
+}
+
+func partitionNames(rows []*domainx.Complex[PartitionModel]) []string {
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		names = append(names, row.Data.Name)
+	}
+	return names
+}
+
+func TestPartitionRouting(t *testing.T) {
+	day := func(m time.Month, d int) time.Time {
+		return time.Date(2026, m, d, 12, 0, 0, 0, time.Local)
+	}
+	dec := time.Date(2025, time.December, 10, 12, 0, 0, 0, time.Local)
+	// the backdated record was created in january and stored in march
+	backdated := snowflakeAt(day(time.January, 20), 9)
+	db := &memPartitionDB{tables: map[string][]*domainx.Complex[PartitionModel]{
+		"test_mem_events_202603": {partitionRow(snowflakeAt(day(time.March, 20), 1), "b", day(time.March, 20)), partitionRow(backdated, "f", day(time.March, 5))},
+		"test_mem_events_202602": {partitionRow(snowflakeAt(day(time.February, 15), 2), "d", day(time.February, 15))},
+		"test_mem_events_202601": {partitionRow(snowflakeAt(day(time.January, 3), 3), "a", day(time.January, 3)), partitionRow(snowflakeAt(day(time.January, 25), 4), "e", day(time.January, 25))},
+		"test_mem_events_202512": {partitionRow(snowflakeAt(dec, 5), "c", dec)},
+	}}
+	registerFakeDB(t, memPartition, db)
+	newCon := func(sorts ...*domainx.Sort) *domainx.Con {
+		return &domainx.Con{
+			ConType:   memPartition,
+			GTable:    "test_mem_events",
+			Partition: &domainx.Partition{Field: "at", Period: domainx.Monthly},
+			Sort:      sorts,
+		}
+	}
+
+	t.Run("Range", func(t *testing.T) {
+		cases := []struct {
+			matches []domainx.Match
+			tables  []string
+		}{
+			{nil, []string{"test_mem_events_202603", "test_mem_events_202602", "test_mem_events_202601", "test_mem_events_202512"}},
+			{[]domainx.Match{{Field: "at", Value: day(time.February, 10), Type: domainx.MGte}}, []string{"test_mem_events_202603", "test_mem_events_202602"}},
+			{[]domainx.Match{{Field: "at", Value: day(time.January, 15), Type: domainx.MLte}}, []string{"test_mem_events_202601", "test_mem_events_202512"}},
+			{[]domainx.Match{{Field: "at", Value: day(time.February, 15), Type: domainx.MEq}}, []string{"test_mem_events_202602"}},
+			{[]domainx.Match{
+				{Field: "at", Value: day(time.January, 1), Type: domainx.MGt},
+				{Field: "at", Value: day(time.February, 28), Type: domainx.MLt},
+				{Field: "name", Value: day(time.March, 1), Type: domainx.MGt},
+			}, []string{"test_mem_events_202602", "test_mem_events_202601"}},
+		}
+		for _, tc := range cases {
+			db.queried = nil
+			var rows []*domainx.Complex[PartitionModel]
+			assert.Nil(t, domainx.FindByMatch(newCon(), tc.matches, &rows))
+			assert.Equal(t, tc.tables, db.queried)
+		}
+	})
+
+	t.Run("MergeSort", func(t *testing.T) {
+		var rows []*domainx.Complex[PartitionModel]
+		assert.Nil(t, domainx.FindByMatch(newCon(&domainx.Sort{Field: "name", Asc: true}), nil, &rows))
+		assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, partitionNames(rows))
+
+		rows = nil
+		assert.Nil(t, domainx.FindByMatch(newCon(&domainx.Sort{Field: "name"}), nil, &rows))
+		assert.Equal(t, []string{"f", "e", "d", "c", "b", "a"}, partitionNames(rows))
+
+		// without a sort the tables are read newest first in their own order
+		rows = nil
+		assert.Nil(t, domainx.FindByMatch(newCon(), nil, &rows))
+		assert.Equal(t, []string{"b", "f", "d", "a", "e", "c"}, partitionNames(rows))
+	})
+
+	t.Run("Page", func(t *testing.T) {
+		for page, names := range [][]string{{"a", "b"}, {"c", "d"}, {"e", "f"}, {}} {
+			var rows []*domainx.Complex[PartitionModel]
+			resp := &load.PageResp{}
+			assert.Nil(t, domainx.FindByPageMatch(newCon(&domainx.Sort{Field: "name", Asc: true}), nil, &load.Page{Page: int64(page + 1), Size: 2}, resp, &rows))
+			assert.Equal(t, names, partitionNames(rows))
+			assert.Equal(t, int64(6), resp.Total.Get())
+		}
+	})
+
+	t.Run("ByID", func(t *testing.T) {
+		id := snowflakeAt(day(time.February, 15), 2)
+		db.probes = 0
+		row := domainx.Complex[PartitionModel]{}
+		assert.Nil(t, domainx.GetByID(newCon(), id, &row))
+		assert.Equal(t, "d", row.Data.Name)
+		assert.Equal(t, 1, db.probes)
+
+		// probed from january outwards: 202601, 202602, 202512, 202603
+		db.probes = 0
+		row = domainx.Complex[PartitionModel]{}
+		assert.Nil(t, domainx.GetByID(newCon(), backdated, &row))
+		assert.Equal(t, "f", row.Data.Name)
+		assert.Equal(t, 4, db.probes)
+
+		viper.Set("domainx.partition.lookup", 2)
+		defer viper.Set("domainx.partition.lookup", nil)
+		db.probes = 0
+		row = domainx.Complex[PartitionModel]{}
+		assert.Nil(t, domainx.GetByID(newCon(), backdated, &row))
+		assert.Nil(t, row.Data)
+		assert.Equal(t, 2, db.probes)
+	})
+}
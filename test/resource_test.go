@@ -0,0 +1,190 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/apix"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/jom-io/gorig/domainx/dx"
+	"github.com/jom-io/gorig/httpx"
+	"github.com/jom-io/gorig/serv"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ResourceModel struct {
+	TenantID string `gorm:"column:tenant_id;type:varchar(64)" bson:"tenant_id" json:"tenantID"`
+	Name     string `gorm:"column:name;type:varchar(64)" bson:"name" json:"name"`
+	Score    int    `gorm:"column:score;type:int" bson:"score" json:"userScore"`
+	Secret   string `gorm:"column:secret;type:varchar(64)" bson:"secret" json:"secret"`
+	Cached   string `gorm:"-" bson:"-" json:"cached"`
+}
+
+func (m *ResourceModel) DConfig() (domainx.ConType, string, string) {
+	return domainx.Mongo, "main", "test_resource_model"
+}
+
+func (m *ResourceModel) DTenant() string {
+	return "tenant_id"
+}
+
+type resourceResp struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+func resourceRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		apix.SetTenantID(c, c.GetHeader("X-Tenant"))
+		c.Next()
+	})
+	httpx.Resource[ResourceModel](router.Group("/api"), "/items", httpx.ResourceOptions[ResourceModel]{
+		Updatable: []string{"name", "userScore", "tenantID"},
+		Authorize: func(c *gin.Context, action httpx.Action, row *domainx.Complex[ResourceModel]) *errors.Error {
+			if c.GetHeader("X-Role") == "guest" && action != httpx.ActionGet {
+				return errors.Verify("guests may only read")
+			}
+			return nil
+		},
+		Mask: func(c *gin.Context, row *domainx.Complex[ResourceModel]) {
+			row.Data.Secret = ""
+		},
+	})
+	return router
+}
+
+func resourceDo(router *gin.Engine, method, path, tenant, role, body string) (int, resourceResp) {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tenant", tenant)
+	req.Header.Set("X-Role", role)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp resourceResp
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func TestResourceUpdateFields(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"tenantID":  "tenant_id",
+		"name":      "name",
+		"userScore": "score",
+		"secret":    "secret",
+	}, domainx.UpdateFields[ResourceModel](domainx.Mysql))
+	assert.Equal(t, map[string]string{
+		"tenantID":  "tenant_id",
+		"name":      "name",
+		"userScore": "score",
+		"secret":    "secret",
+	}, domainx.UpdateFields[ResourceModel](domainx.Mongo))
+	assert.Equal(t, "test_field1", domainx.UpdateFields[TestModel](domainx.Mysql)["testField1"])
+	assert.NotContains(t, domainx.UpdateFields[TestModel](domainx.Mysql), "testField6")
+	assert.NotContains(t, domainx.UpdateFields[TestModel](domainx.Mongo), "testField5")
+}
+
+func TestResource(t *testing.T) {
+	router := resourceRouter()
+
+	t.Run("RejectField", func(t *testing.T) {
+		for _, field := range []string{"id", "$set", "con.id", "options.version", "tenantID", "secret", "cached", "unknown", "test_score"} {
+			code, resp := resourceDo(router, http.MethodPatch, "/api/items/1", "t1", "", fmt.Sprintf(`{%q: "x"}`, field))
+			assert.Equal(t, http.StatusBadRequest, code, field)
+			assert.Contains(t, string(resp.Data), "is not updatable", field)
+		}
+	})
+
+	t.Run("EmptyBody", func(t *testing.T) {
+		code, _ := resourceDo(router, http.MethodPatch, "/api/items/1", "t1", "", `{}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("BadID", func(t *testing.T) {
+		code, _ := resourceDo(router, http.MethodGet, "/api/items/abc", "t1", "", "")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		code, _ := resourceDo(router, http.MethodGet, "/api/items", "t1", "guest", "")
+		assert.Equal(t, http.StatusForbidden, code)
+		code, _ = resourceDo(router, http.MethodPost, "/api/items", "t1", "guest", `{"name": "a"}`)
+		assert.Equal(t, http.StatusForbidden, code)
+	})
+
+	ctx := context.Background()
+	domainx.AutoMigrate(func() (value domainx.ConTable) {
+		return dx.On[ResourceModel](ctx).Complex()
+	})
+	if codeErr := serv.StartCode(domainx.ServiceCode); codeErr != nil {
+		panic(codeErr)
+	}
+	time.Sleep(3 * time.Second)
+	if _, err := dx.On[ResourceModel](domainx.WithTenant(ctx, "t1")).Count(); err != nil {
+		t.Skipf("mongo is not available: %v", err)
+	}
+
+	var id int64
+	if !t.Run("Create", func(t *testing.T) {
+		code, resp := resourceDo(router, http.MethodPost, "/api/items", "t1", "", `{"name": "a", "userScore": 1, "secret": "s"}`)
+		require.Equal(t, http.StatusOK, code)
+		var row domainx.Complex[ResourceModel]
+		require.Nil(t, json.Unmarshal(resp.Data, &row))
+		require.NotNil(t, row.Data)
+		id = row.GetID().Int64()
+		require.True(t, id > 0)
+		assert.Equal(t, "t1", row.Data.TenantID)
+		assert.Equal(t, "", row.Data.Secret)
+	}) {
+		return
+	}
+
+	path := fmt.Sprintf("/api/items/%d", id)
+
+	t.Run("Update", func(t *testing.T) {
+		code, resp := resourceDo(router, http.MethodPatch, path, "t1", "", `{"name": "b", "userScore": 2}`)
+		require.Equal(t, http.StatusOK, code)
+		var row domainx.Complex[ResourceModel]
+		require.Nil(t, json.Unmarshal(resp.Data, &row))
+		require.NotNil(t, row.Data)
+		assert.Equal(t, "b", row.Data.Name)
+		assert.Equal(t, 2, row.Data.Score)
+		assert.Equal(t, "t1", row.Data.TenantID)
+	})
+
+	t.Run("GetAndList", func(t *testing.T) {
+		code, _ := resourceDo(router, http.MethodGet, path, "t1", "guest", "")
+		assert.Equal(t, http.StatusOK, code)
+		code, resp := resourceDo(router, http.MethodGet, "/api/items", "t1", "", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Contains(t, string(resp.Data), fmt.Sprintf(`"id":"%d"`, id))
+	})
+
+	t.Run("OtherTenant", func(t *testing.T) {
+		code, _ := resourceDo(router, http.MethodGet, path, "t2", "", "")
+		assert.Equal(t, http.StatusNotFound, code)
+		code, _ = resourceDo(router, http.MethodPatch, path, "t2", "", `{"name": "c"}`)
+		assert.Equal(t, http.StatusNotFound, code)
+		code, _ = resourceDo(router, http.MethodDelete, path, "t2", "", "")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		code, _ := resourceDo(router, http.MethodDelete, path, "t1", "guest", "")
+		assert.Equal(t, http.StatusForbidden, code)
+		code, _ = resourceDo(router, http.MethodDelete, path, "t1", "", "")
+		assert.Equal(t, http.StatusOK, code)
+		code, _ = resourceDo(router, http.MethodGet, path, "t1", "", "")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
@@ -0,0 +1,77 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig/cache"
+	"github.com/jom-io/gorig/utils/snowflake"
+	"github.com/spf13/viper"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnowflake_UniqueAndMonotonic(t *testing.T) {
+	node := snowflake.NewNode(7)
+	var last int64
+	for i := 0; i < 100000; i++ {
+		id := node.Generate()
+		if id <= last {
+			t.Fatalf("Expected increasing ids, got %d after %d", id, last)
+		}
+		last = id
+	}
+
+	ids := sync.Map{}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				if _, dup := ids.LoadOrStore(node.Generate(), true); dup {
+					t.Error("Duplicate id generated")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSnowflake_Parse(t *testing.T) {
+	before := time.Now().Add(-time.Millisecond)
+	node := snowflake.NewNode(513)
+	id := node.Generate()
+	info := snowflake.Parse(id)
+	if info.WorkerID != 513 {
+		t.Fatalf("Expected worker id 513, got %d", info.WorkerID)
+	}
+	if info.Time.Before(before) || info.Time.After(time.Now().Add(time.Millisecond)) {
+		t.Fatalf("Unexpected id time %v", info.Time)
+	}
+	if snowflake.NewNode(snowflake.MaxWorkerID+1).WorkerID() > snowflake.MaxWorkerID {
+		t.Fatal("Expected worker id to be kept in range")
+	}
+}
+
+func TestSnowflake_LeaseLost(t *testing.T) {
+	rc := cache.GetRedisInstance[string](context.Background())
+	if rc == nil || !rc.IsInitialized() {
+		t.Skip("redis is not configured")
+	}
+	viper.Set("snowflake.leaseTTL", 600*time.Millisecond)
+	defer viper.Set("snowflake.leaseTTL", nil)
+
+	node := snowflake.LeaseNode()
+	worker := snowflake.Parse(node.Generate()).WorkerID
+	// another instance takes the worker id, the node must not issue it anymore
+	key := fmt.Sprintf("snowflake:worker:%d", worker)
+	rc.Client.Set(context.Background(), key, "other", time.Minute)
+	defer rc.Client.Del(context.Background(), key)
+
+	time.Sleep(400 * time.Millisecond)
+	if got := snowflake.Parse(node.Generate()).WorkerID; got == worker {
+		t.Fatalf("Expected a new worker id after the lease was lost, got %d again", got)
+	}
+}
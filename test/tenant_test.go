@@ -0,0 +1,78 @@
+package test
+
+import (
+	"context"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// tenantDB owns every record and accepts every write
+type tenantDB struct {
+	fakeDB
+	updates int
+}
+
+func (*tenantDB) ExistsByMatch(c *domainx.Con, matchList []domainx.Match) (bool, error) {
+	return true, nil
+}
+
+func (s *tenantDB) UpdatePart(c *domainx.Con, id int64, data map[string]interface{}) (int64, error) {
+	s.updates++
+	return 1, nil
+}
+
+func (s *tenantDB) UpdateByMatch(c *domainx.Con, matchList []domainx.Match, data map[string]interface{}) (int64, error) {
+	s.updates++
+	return 1, nil
+}
+
+func (s *tenantDB) BulkWrite(c *domainx.Con, ops []domainx.BulkOp, result *domainx.BulkResult) error {
+	for i := range ops {
+		if !result.Failed(i) {
+			s.updates++
+		}
+	}
+	return nil
+}
+
+func TestTenantUpdate(t *testing.T) {
+	db := &tenantDB{}
+	registerFakeDB(t, "tenant", db)
+	domainx.ResetQueryMetrics()
+	c := &domainx.Con{Ctx: domainx.WithTenant(context.Background(), "tenant-a"), ConType: "tenant", GTable: "orders"}
+	c.SetTenantField("tenant_id")
+	byID := []domainx.Match{{Field: "id", Value: 1, Type: domainx.MEq}}
+
+	for _, data := range []map[string]interface{}{
+		{"tenant_id": "tenant-b"},
+		{"data.tenant_id": "tenant-b"},
+		{"tenantID": "tenant-b"},
+		{"tenant_id": domainx.Unset()},
+	} {
+		assert.NotNil(t, domainx.UpdatePart(c, 1, data), data)
+		assert.NotNil(t, domainx.UpdateByMatch(c, byID, data), data)
+		_, err := domainx.FindOneAndUpdate(c, byID, data, true, &domainx.Complex[TenantModel]{})
+		assert.NotNil(t, err, data)
+		result, err := domainx.BulkWrite(c, []domainx.BulkOp{{Type: domainx.BulkUpdate, ID: 1, Update: data}})
+		assert.Nil(t, err)
+		assert.True(t, result.Failed(0), data)
+	}
+	assert.Equal(t, 0, db.updates)
+
+	assert.Nil(t, domainx.UpdatePart(c, 1, map[string]interface{}{"tenant_id": "tenant-a", "name": "b"}))
+	assert.Nil(t, domainx.UpdateByMatch(c, byID, map[string]interface{}{"name": "b"}))
+	assert.Equal(t, 2, db.updates)
+
+	c.SetUnscoped(true)
+	assert.Nil(t, domainx.UpdatePart(c, 1, map[string]interface{}{"tenant_id": "tenant-b"}))
+
+	rows := make(map[string]int64)
+	for _, m := range domainx.QueryMetrics() {
+		if m.ConType == "tenant" {
+			rows[m.Op] = m.Rows
+		}
+	}
+	assert.Equal(t, int64(2), rows["UpdatePart"])
+	assert.Equal(t, int64(1), rows["UpdateByMatch"])
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig/domainx"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+	"time"
+)
+
+func watchRaw(t *testing.T, event bson.M) bson.Raw {
+	raw, err := bson.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestDecodeWatchEvent(t *testing.T) {
+	ctx := context.Background()
+	con := &domainx.Con{GTable: "test_watch"}
+	docID := primitive.NewObjectID()
+
+	t.Run("Insert", func(t *testing.T) {
+		event, err := domainx.DecodeWatchEvent[TestModel](ctx, con, watchRaw(t, bson.M{
+			"operationType": "insert",
+			"documentKey":   bson.M{"_id": docID},
+			"fullDocument":  bson.M{"_id": docID, "con": bson.M{"id": int64(7)}, "data": bson.M{"test_field1": "a", "test_field2": 2}},
+		}))
+		assert.Nil(t, err)
+		assert.NotNil(t, event)
+		assert.Equal(t, domainx.WatchInsert, event.Op)
+		assert.Equal(t, int64(7), event.ID)
+		assert.Equal(t, docID, event.DocID)
+		assert.Equal(t, "a", event.Row.Data.TestField1)
+		assert.Equal(t, 2, event.Row.Data.TestField2)
+		assert.Equal(t, int64(7), event.Row.GetID().Int64())
+		assert.Equal(t, "test_watch", event.Row.TableName())
+		assert.Nil(t, event.Before)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		event, err := domainx.DecodeWatchEvent[TestModel](ctx, con, watchRaw(t, bson.M{
+			"operationType": "update",
+			"documentKey":   bson.M{"_id": docID},
+			"fullDocument":  bson.M{"_id": docID, "con": bson.M{"id": int64(7)}, "data": bson.M{"test_field1": "b"}},
+			"updateDescription": bson.M{
+				"updatedFields": bson.M{"data.test_field1": "b"},
+				"removedFields": bson.A{"data.test_field2"},
+			},
+		}))
+		assert.Nil(t, err)
+		assert.Equal(t, domainx.WatchUpdate, event.Op)
+		assert.Equal(t, int64(7), event.ID)
+		assert.Equal(t, "b", event.Updated["data.test_field1"])
+		assert.Equal(t, []string{"data.test_field2"}, event.Removed)
+	})
+
+	t.Run("DeleteWithPreImage", func(t *testing.T) {
+		event, err := domainx.DecodeWatchEvent[TestModel](ctx, con, watchRaw(t, bson.M{
+			"operationType":            "delete",
+			"documentKey":              bson.M{"_id": docID},
+			"fullDocumentBeforeChange": bson.M{"_id": docID, "con": bson.M{"id": int64(9)}, "data": bson.M{"test_field1": "c"}},
+		}))
+		assert.Nil(t, err)
+		assert.Equal(t, domainx.WatchDelete, event.Op)
+		assert.Nil(t, event.Row)
+		assert.Equal(t, int64(9), event.ID)
+		assert.Equal(t, "c", event.Before.Data.TestField1)
+	})
+
+	t.Run("DeleteWithoutPreImage", func(t *testing.T) {
+		event, err := domainx.DecodeWatchEvent[TestModel](ctx, con, watchRaw(t, bson.M{
+			"operationType": "delete",
+			"documentKey":   bson.M{"_id": docID},
+		}))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), event.ID)
+		assert.Equal(t, docID, event.DocID)
+		assert.Nil(t, event.Row)
+		assert.Nil(t, event.Before)
+	})
+
+	t.Run("Invalidate", func(t *testing.T) {
+		event, err := domainx.DecodeWatchEvent[TestModel](ctx, con, watchRaw(t, bson.M{"operationType": "invalidate"}))
+		assert.Nil(t, err)
+		assert.Nil(t, event)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		_, err := domainx.DecodeWatchEvent[TestModel](ctx, con, bson.Raw{0x01})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestWatchRetry(t *testing.T) {
+	viper.Set("domainx.watch.retryWait", 10*time.Millisecond)
+	defer viper.Set("domainx.watch.retryWait", nil)
+
+	lost := mongo.CommandError{Code: 286, Name: "ChangeStreamHistoryLost"}
+	assert.True(t, domainx.IsWatchTokenLost(lost))
+	assert.True(t, domainx.IsWatchTokenLost(fmt.Errorf("next: %w", lost)))
+	assert.False(t, domainx.IsWatchTokenLost(mongo.CommandError{Code: 11000}))
+	assert.False(t, domainx.IsWatchTokenLost(nil))
+
+	// two failures back off, a lost token is reset and reopened at once, a second loss in a row backs off again
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := []error{fmt.Errorf("down"), fmt.Errorf("down"), lost, lost, nil}
+	var calls []time.Time
+	resets := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		domainx.WatchRetry(ctx, func(ctx context.Context) error {
+			calls = append(calls, time.Now())
+			if len(calls) == len(results) {
+				cancel()
+			}
+			return results[len(calls)-1]
+		}, func(err error) {
+			assert.True(t, domainx.IsWatchTokenLost(err))
+			resets++
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchRetry did not stop")
+	}
+	assert.Len(t, calls, len(results))
+	assert.Equal(t, 1, resets)
+	assert.GreaterOrEqual(t, calls[1].Sub(calls[0]), 10*time.Millisecond)
+	assert.GreaterOrEqual(t, calls[2].Sub(calls[1]), 20*time.Millisecond)
+	assert.Less(t, calls[3].Sub(calls[2]), 10*time.Millisecond)
+	assert.GreaterOrEqual(t, calls[4].Sub(calls[3]), 10*time.Millisecond)
+}
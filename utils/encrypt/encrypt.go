@@ -3,8 +3,11 @@ package encrypt
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 )
@@ -90,3 +93,60 @@ func Decrypt(encodedCipher, key string) (string, error) {
 	plaintext := plaintextPadded[:len(plaintextPadded)-padding]
 	return string(plaintext), nil
 }
+
+// Hmac returns the hex HMAC-SHA256 of text, key is base64 encoded like the keys of Encrypt
+func Hmac(text, key string) (string, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, k)
+	mac.Write([]byte(text))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// EncryptGCM encrypts text with AES-GCM, the ciphertext is authenticated and cannot be altered unnoticed.
+// key is base64 encoded like the keys of Encrypt, the result is the base64 of nonce and sealed text.
+func EncryptGCM(text, key string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(text), nil)), nil
+}
+
+// DecryptGCM decrypts a value of EncryptGCM, a tampered value fails
+func DecryptGCM(encodedCipher, key string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encodedCipher)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key string) (cipher.AEAD, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
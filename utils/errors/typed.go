@@ -0,0 +1,57 @@
+package errors
+
+// Codes of the typed errors, HTTP handlers map them to 404, 409 and 504
+const (
+	CodeNotFound     = "not_found"
+	CodeDuplicateKey = "duplicate_key"
+	CodeConflict     = "conflict"
+	CodeTimeout      = "timeout"
+)
+
+func NotFound(message string, nativeErr ...error) *Error {
+	return Of(Application, CodeNotFound, message, nativeErr...)
+}
+
+// DuplicateKey is a unique index violation
+func DuplicateKey(message string, nativeErr ...error) *Error {
+	return Of(Application, CodeDuplicateKey, message, nativeErr...)
+}
+
+// Conflict is a concurrent modification, the operation may succeed after a reload and retry
+func Conflict(message string, nativeErr ...error) *Error {
+	return Of(Application, CodeConflict, message, nativeErr...)
+}
+
+// Timeout is a system error, the operation did not complete in time
+func Timeout(message string, nativeErr ...error) *Error {
+	return Of(System, CodeTimeout, message, nativeErr...)
+}
+
+func (e *Error) IsNotFound() bool {
+	return e != nil && e.Code == CodeNotFound
+}
+
+func (e *Error) IsDuplicateKey() bool {
+	return e != nil && e.Code == CodeDuplicateKey
+}
+
+func (e *Error) IsConflict() bool {
+	return e != nil && e.Code == CodeConflict
+}
+
+func (e *Error) IsTimeout() bool {
+	return e != nil && e.Code == CodeTimeout
+}
+
+// Is matches a typed error by its code, so errors.Is(err, domainx.ErrNotFound) holds for any not found error
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || e == nil || t == nil {
+		return false
+	}
+	switch t.Code {
+	case CodeNotFound, CodeDuplicateKey, CodeConflict, CodeTimeout:
+		return e.Code == t.Code
+	}
+	return e == t
+}
@@ -0,0 +1,139 @@
+package geo
+
+import (
+	"fmt"
+	"strings"
+)
+
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Direction of a neighbour cell
+type Direction int
+
+const (
+	North Direction = iota
+	NorthEast
+	East
+	SouthEast
+	South
+	SouthWest
+	West
+	NorthWest
+)
+
+// Encode returns the geohash of the point with precision characters, 12 at most
+func Encode(lat, lng float64, precision int) string {
+	if precision <= 0 || precision > 12 {
+		precision = 12
+	}
+	latRange, lngRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+	var b strings.Builder
+	even, bit, ch := true, 0, 0
+	for b.Len() < precision {
+		if even {
+			mid := (lngRange[0] + lngRange[1]) / 2
+			if lng >= mid {
+				ch = ch<<1 | 1
+				lngRange[0] = mid
+			} else {
+				ch <<= 1
+				lngRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if lat >= mid {
+				ch = ch<<1 | 1
+				latRange[0] = mid
+			} else {
+				ch <<= 1
+				latRange[1] = mid
+			}
+		}
+		even = !even
+		if bit++; bit == 5 {
+			b.WriteByte(base32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return b.String()
+}
+
+// DecodeBox returns the cell of the geohash
+func DecodeBox(hash string) (Box, error) {
+	latRange, lngRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+	even := true
+	for i := 0; i < len(hash); i++ {
+		idx := strings.IndexByte(base32, hash[i])
+		if idx < 0 {
+			return Box{}, fmt.Errorf("geohash: invalid character %q", hash[i])
+		}
+		for mask := 16; mask > 0; mask >>= 1 {
+			r := &latRange
+			if even {
+				r = &lngRange
+			}
+			mid := (r[0] + r[1]) / 2
+			if idx&mask != 0 {
+				r[0] = mid
+			} else {
+				r[1] = mid
+			}
+			even = !even
+		}
+	}
+	return Box{Min: Point{Lat: latRange[0], Lng: lngRange[0]}, Max: Point{Lat: latRange[1], Lng: lngRange[1]}}, nil
+}
+
+// Decode returns the center of the cell of the geohash
+func Decode(hash string) (Point, error) {
+	box, err := DecodeBox(hash)
+	if err != nil {
+		return Point{}, err
+	}
+	return Point{Lat: (box.Min.Lat + box.Max.Lat) / 2, Lng: (box.Min.Lng + box.Max.Lng) / 2}, nil
+}
+
+// Neighbor returns the geohash of the adjacent cell of the same precision in direction, wrapping around the antimeridian
+func Neighbor(hash string, direction Direction) (string, error) {
+	box, err := DecodeBox(hash)
+	if err != nil {
+		return "", err
+	}
+	height, width := box.Max.Lat-box.Min.Lat, box.Max.Lng-box.Min.Lng
+	center := Point{Lat: (box.Min.Lat + box.Max.Lat) / 2, Lng: (box.Min.Lng + box.Max.Lng) / 2}
+	switch direction {
+	case North, NorthEast, NorthWest:
+		center.Lat += height
+	case South, SouthEast, SouthWest:
+		center.Lat -= height
+	}
+	switch direction {
+	case East, NorthEast, SouthEast:
+		center.Lng += width
+	case West, NorthWest, SouthWest:
+		center.Lng -= width
+	}
+	if center.Lat > 90 || center.Lat < -90 {
+		return "", fmt.Errorf("geohash: %s has no neighbour beyond the pole", hash)
+	}
+	if center.Lng > 180 {
+		center.Lng -= 360
+	} else if center.Lng < -180 {
+		center.Lng += 360
+	}
+	return Encode(center.Lat, center.Lng, len(hash)), nil
+}
+
+// Neighbors returns the adjacent cells of the geohash from North clockwise, the cells beyond a pole are left out
+func Neighbors(hash string) ([]string, error) {
+	if _, err := DecodeBox(hash); err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, 8)
+	for d := North; d <= NorthWest; d++ {
+		if n, err := Neighbor(hash, d); err == nil {
+			list = append(list, n)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,82 @@
+package geo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Point is a WGS84 coordinate
+type Point struct {
+	Lat float64 `json:"lat" bson:"lat"`
+	Lng float64 `json:"lng" bson:"lng"`
+}
+
+// Box is the bounding box of the south-west corner Min and the north-east corner Max
+type Box struct {
+	Min Point `json:"min" bson:"min"`
+	Max Point `json:"max" bson:"max"`
+}
+
+// Contains reports whether p is inside the box, borders included
+func (b Box) Contains(p Point) bool {
+	return p.Lat >= b.Min.Lat && p.Lat <= b.Max.Lat && p.Lng >= b.Min.Lng && p.Lng <= b.Max.Lng
+}
+
+// Polygon returns the corners of the box as a polygon
+func (b Box) Polygon() []Point {
+	return []Point{
+		{Lat: b.Min.Lat, Lng: b.Min.Lng},
+		{Lat: b.Min.Lat, Lng: b.Max.Lng},
+		{Lat: b.Max.Lat, Lng: b.Max.Lng},
+		{Lat: b.Max.Lat, Lng: b.Min.Lng},
+	}
+}
+
+// Bounds is the bounding box of the points
+func Bounds(points []Point) Box {
+	if len(points) == 0 {
+		return Box{}
+	}
+	b := Box{Min: points[0], Max: points[0]}
+	for _, p := range points[1:] {
+		b.Min.Lat = min(b.Min.Lat, p.Lat)
+		b.Min.Lng = min(b.Min.Lng, p.Lng)
+		b.Max.Lat = max(b.Max.Lat, p.Lat)
+		b.Max.Lng = max(b.Max.Lng, p.Lng)
+	}
+	return b
+}
+
+// Closed returns the ring of the polygon with the first point repeated at the end, as GeoJSON and WKT require
+func Closed(polygon []Point) []Point {
+	if len(polygon) == 0 || polygon[0] == polygon[len(polygon)-1] {
+		return polygon
+	}
+	return append(append([]Point(nil), polygon...), polygon[0])
+}
+
+// InPolygon reports whether p is inside the polygon by ray casting, on a plane of lat and lng
+func InPolygon(p Point, polygon []Point) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lng < (b.Lng-a.Lng)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// WKT renders a single point as POINT and more points as a closed POLYGON, in the lng lat order of WKT
+func WKT(points []Point) string {
+	if len(points) == 1 {
+		return fmt.Sprintf("POINT(%g %g)", points[0].Lng, points[0].Lat)
+	}
+	ring := Closed(points)
+	coords := make([]string, len(ring))
+	for i, p := range ring {
+		coords[i] = fmt.Sprintf("%g %g", p.Lng, p.Lat)
+	}
+	return "POLYGON((" + strings.Join(coords, ",") + "))"
+}
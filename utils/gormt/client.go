@@ -44,6 +44,7 @@ func GetSqlDriver(sqlType string, sqlName string, readDbIsOpen int, dbConf ...Co
 
 	// 如果开启了读写分离，配置读数据库（resource、read、replicas）
 	// 读写分离配置只
+	var resolver *dbresolver.DBResolver
 	if readDbIsOpen == 1 {
 		if val, err := getDbDialector(sqlType, sqlName, "Read", dbConf...); err != nil {
 			logger.Logger.Error(errc.ErrorsDialectorDbInitFail+sqlType, zap.Error(err))
@@ -54,7 +55,26 @@ func GetSqlDriver(sqlType string, sqlName string, readDbIsOpen int, dbConf ...Co
 			Replicas: []gorm.Dialector{dbDialector}, //  读 操作库，查询类
 			Policy:   dbresolver.RandomPolicy{},     // sources/replicas 负载均衡策略适用于
 		}
-		err = gormDb.Use(dbresolver.Register(resolverConf).SetConnMaxIdleTime(time.Second * 30).
+		resolver = dbresolver.Register(resolverConf)
+	}
+
+	// 命名的只读库 Mysql.<sqlName>.Replicas.<name>, 通过 dbresolver.Use(name) 指定查询的只读库
+	for name := range configure.GetSub(sqlType + "." + sqlName + ".Replicas") {
+		replica, err := getDbDialector(sqlType, sqlName, "Replicas."+name, dbConf...)
+		if err != nil {
+			logger.Logger.Error(errc.ErrorsDialectorDbInitFail+sqlType, zap.Error(err))
+			continue
+		}
+		resolverConf := dbresolver.Config{Replicas: []gorm.Dialector{replica}}
+		if resolver == nil {
+			resolver = dbresolver.Register(resolverConf, name)
+		} else {
+			resolver = resolver.Register(resolverConf, name)
+		}
+	}
+
+	if resolver != nil {
+		err = gormDb.Use(resolver.SetConnMaxIdleTime(time.Second * 30).
 			SetConnMaxLifetime(configure.GetDuration(sqlName+".Read.SetConnMaxLifetime") * time.Second).
 			SetMaxIdleConns(configure.GetInt(sqlName + ".Read.SetMaxIdleConns")).
 			SetMaxOpenConns(configure.GetInt(sqlName + ".Read.SetMaxOpenConns")))
@@ -131,6 +131,10 @@ func getTraceID(ctx context.Context) any {
 	return ctx.Value(consts.TraceIDKey)
 }
 
+func GetUserID(ctx context.Context) string {
+	return cast.ToString(getUserID(ctx))
+}
+
 func getUserID(ctx context.Context) any {
 	if ctx == nil {
 		return nil
@@ -144,6 +148,13 @@ func getUserID(ctx context.Context) any {
 	return ctx.Value(consts.UserIDKey)
 }
 
+func GetTenantID(ctx context.Context) string {
+	if ctx == nil || isNilPointer(ctx) {
+		return ""
+	}
+	return cast.ToString(ctx.Value(consts.TenantIDKey))
+}
+
 func putTraceId(ctx context.Context, fields ...zap.Field) []zap.Field {
 	defer func() {
 		if r := recover(); r != nil {
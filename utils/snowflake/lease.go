@@ -0,0 +1,117 @@
+package snowflake
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/jom-io/gorig/cache"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+	"math/rand"
+	"time"
+)
+
+// renewScript extends the lease only if it is still held by this instance
+var renewScript = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
+
+// resolveNode reads the worker id from ${ snowflake.workerID }, otherwise leases it from redis when ${ redis.addr }
+// is set, otherwise picks a random one
+func resolveNode() *Node {
+	if id := configure.GetInt("snowflake.workerID", -1); id >= 0 {
+		return NewNode(int64(id))
+	}
+	if configure.GetString("redis.addr") != "" {
+		return LeaseNode()
+	}
+	id := rand.Int63n(MaxWorkerID + 1)
+	logger.Warn(nil, "snowflake worker id is random, set snowflake.workerID or redis to avoid collisions", zap.Int64("workerID", id))
+	return NewNode(id)
+}
+
+// LeaseNode returns a node whose worker id is leased from redis for ${ snowflake.leaseTTL } and renewed every third
+// of it. Generate blocks until the first lease, waiting for redis if needed, and again after a lost lease or renewals
+// failing for two thirds of the ttl, until a worker id is leased again.
+func LeaseNode() *Node {
+	n := NewNode(0)
+	n.release()
+	go n.keepLease(func() *redis.Client {
+		rc := cache.GetRedisInstance[string](context.Background())
+		if rc == nil || !rc.IsInitialized() {
+			return nil
+		}
+		return rc.Client
+	})
+	return n
+}
+
+func leaseKey(id int64) string {
+	return fmt.Sprintf("%s:%d", configure.GetString("snowflake.leaseKey", "snowflake:worker"), id)
+}
+
+func (n *Node) keepLease(redisClient func() *redis.Client) {
+	ttl := configure.GetDuration("snowflake.leaseTTL", time.Minute)
+	token := xid.New().String()
+	id := int64(-1)
+	var renewedAt time.Time
+	for {
+		if client := redisClient(); client == nil {
+			logger.Warn(nil, "snowflake worker id lease waits for redis")
+		} else {
+			id, renewedAt = n.renewLease(client, token, ttl, id, renewedAt)
+		}
+		wait := ttl / 3
+		if id < 0 {
+			// ids are blocked until a lease is held
+			wait = min(wait, time.Second)
+		}
+		time.Sleep(wait)
+	}
+}
+
+// renewLease renews the lease of id, or leases a new worker id when id is -1 or its lease is lost.
+// It returns the leased worker id, -1 if none, and the time of the last renewal.
+func (n *Node) renewLease(client *redis.Client, token string, ttl time.Duration, id int64, renewedAt time.Time) (int64, time.Time) {
+	if id >= 0 {
+		renewed, err := renewScript.Run(context.Background(), client, []string{leaseKey(id)}, token, ttl.Milliseconds()).Int()
+		switch {
+		case err == nil && renewed == 1:
+			return id, time.Now()
+		case err != nil:
+			logger.Warn(nil, "snowflake worker id renew failed", zap.Int64("workerID", id), zap.Error(err))
+			if time.Since(renewedAt) < ttl*2/3 {
+				return id, renewedAt
+			}
+			// the lease may expire before the next renewal, another instance could then take the worker id
+			n.release()
+			return -1, renewedAt
+		}
+		n.release()
+		logger.Warn(nil, "snowflake worker id lease lost", zap.Int64("workerID", id))
+	}
+	newID, ok := acquireWorkerID(client, token, ttl)
+	if !ok {
+		return -1, renewedAt
+	}
+	n.hold(newID)
+	logger.Info(nil, "snowflake worker id leased", zap.Int64("workerID", newID))
+	return newID, time.Now()
+}
+
+func acquireWorkerID(client *redis.Client, token string, ttl time.Duration) (int64, bool) {
+	start := rand.Int63n(MaxWorkerID + 1)
+	for i := int64(0); i <= MaxWorkerID; i++ {
+		id := (start + i) & MaxWorkerID
+		ok, err := client.SetNX(context.Background(), leaseKey(id), token, ttl).Result()
+		if err != nil {
+			logger.Error(nil, "snowflake worker id lease failed", zap.Error(err))
+			return 0, false
+		}
+		if ok {
+			return id, true
+		}
+	}
+	logger.Error(nil, "snowflake worker ids are all leased")
+	return 0, false
+}
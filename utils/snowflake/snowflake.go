@@ -0,0 +1,123 @@
+package snowflake
+
+import (
+	"github.com/jom-io/gorig/global/consts"
+	"github.com/jom-io/gorig/utils/logger"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// MaxWorkerID is the largest worker id that fits in consts.MachineIdBits
+const MaxWorkerID = int64(-1 ^ (-1 << consts.MachineIdBits))
+
+// Node generates ids of 41 bits milliseconds since consts.StartTimeStamp, 10 bits worker id and 12 bits sequence.
+// Ids of a node are strictly increasing: when the clock moves backwards the node keeps counting on its last
+// timestamp and borrows the next millisecond when the sequence overflows, until the clock catches up.
+// A leased node issues no id while it holds no worker id lease, Generate blocks until it holds one.
+type Node struct {
+	mu       sync.Mutex
+	held     *sync.Cond
+	leased   bool // the node has a worker id lease, or its worker id needs none
+	workerID int64
+	lastMs   int64
+	seq      int64
+}
+
+// Info is the decoded content of an id
+type Info struct {
+	Time     time.Time `json:"time"`
+	WorkerID int64     `json:"workerID"`
+	Sequence int64     `json:"sequence"`
+}
+
+// NewNode creates a node, workerID must be unique among the running instances
+func NewNode(workerID int64) *Node {
+	if workerID < 0 || workerID > MaxWorkerID {
+		logger.Error(nil, "snowflake worker id out of range, use the low bits", zap.Int64("workerID", workerID))
+		workerID &= MaxWorkerID
+	}
+	n := &Node{workerID: workerID, leased: true}
+	n.held = sync.NewCond(&n.mu)
+	return n
+}
+
+func (n *Node) WorkerID() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.workerID
+}
+
+// hold sets the worker id of a lease and resumes Generate
+func (n *Node) hold(workerID int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.workerID = workerID & MaxWorkerID
+	n.leased = true
+	n.held.Broadcast()
+}
+
+// release stops Generate until the next hold, the worker id may be leased by another instance
+func (n *Node) release() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.leased = false
+}
+
+func (n *Node) Generate() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for !n.leased {
+		n.held.Wait()
+	}
+
+	now := time.Now().UnixMilli() - consts.StartTimeStamp
+	if now < n.lastMs {
+		if n.lastMs-now > int64(time.Second/time.Millisecond) {
+			logger.Warn(nil, "snowflake clock moved backwards", zap.Int64("skewMs", n.lastMs-now))
+		}
+		now = n.lastMs
+	}
+	if now == n.lastMs {
+		n.seq = (n.seq + 1) & consts.SequenceMask
+		if n.seq == 0 {
+			now++
+		}
+	} else {
+		n.seq = 0
+	}
+	n.lastMs = now
+	return now<<consts.TimestampShift | n.workerID<<consts.MachineIdShift | n.seq
+}
+
+// Parse decodes the time, worker id and sequence of an id
+func Parse(id int64) Info {
+	return Info{
+		Time:     time.UnixMilli(id>>consts.TimestampShift + consts.StartTimeStamp),
+		WorkerID: id >> consts.MachineIdShift & MaxWorkerID,
+		Sequence: id & consts.SequenceMask,
+	}
+}
+
+// Time returns the generation time of an id
+func Time(id int64) time.Time {
+	return Parse(id).Time
+}
+
+var (
+	defNode *Node
+	defOnce sync.Once
+)
+
+// Default is the node of this instance, its worker id is read from ${ snowflake.workerID } or leased from redis
+func Default() *Node {
+	defOnce.Do(func() {
+		defNode = resolveNode()
+	})
+	return defNode
+}
+
+// NextID generates an id with the default node
+func NextID() int64 {
+	return Default().Generate()
+}